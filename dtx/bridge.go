@@ -0,0 +1,711 @@
+package core
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// frame types of the bridge protocol
+// each frame is a 4 bytes big endian length, the frame type and the payload
+// message frames start with the 8 bytes sequence acknowledged by ack frames,
+// messages not acknowledged are resent after reconnected
+const (
+	frameHello     byte = 1
+	frameMessage   byte = 2
+	frameHeartbeat byte = 3
+	frameAck       byte = 4
+
+	bridgeMagic  = "dtx-bridge/1"
+	maxFrameSize = 16 << 20
+)
+
+var (
+	// ErrPeerBusy messages buffered for the peer exceeded
+	ErrPeerBusy = errors.New("core: bridge peer buffer full")
+	// ErrBridgeClosed bridge already closed
+	ErrBridgeClosed = errors.New("core: bridge closed")
+)
+
+// hello handshake payload exchanged after connected
+type hello struct {
+	Magic string `json:"magic"`
+	Node  string `json:"node"`
+	// Session changes each time the bridge created, sequences restart with it
+	Session string `json:"session"`
+	// Heartbeat interval of the sender in milliseconds
+	Heartbeat int64 `json:"heartbeat"`
+}
+
+// Bridge exchange messages with kernels in other processes over TCP
+type Bridge struct {
+	node        string
+	session     string
+	heartbeat   time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	dialTimeout time.Duration
+	bufferSize  int
+	deliver     func(Message) error
+
+	m        sync.Mutex
+	listener net.Listener
+	peers    map[string]*peer
+	routes   map[int64]string
+	closed   bool
+	done     chan struct{}
+	wg       sync.WaitGroup
+}
+
+// peer the other kernel, implements Transport for the routes to it
+type peer struct {
+	bridge *Bridge
+	name   string
+	out    chan Message
+	// serving only one connection of the peer is served at the same time
+	serving sync.Mutex
+	// acked notify writer inflight trimmed, ackDue notify writer to acknowledge
+	acked  chan struct{}
+	ackDue chan struct{}
+
+	m    sync.Mutex
+	conn net.Conn
+	// dialed conn connected by this side, not accepted
+	dialed   bool
+	seq      uint64
+	inflight []pending
+	session  string
+	received uint64
+}
+
+// pending message sent but not acknowledged, data is the encoded message
+type pending struct {
+	seq  uint64
+	data []byte
+}
+
+type bridgeOption func(*Bridge)
+
+// WithHeartbeat interval of heartbeats, connection idle for 3 intervals is closed
+func WithHeartbeat(interval time.Duration) bridgeOption {
+	return func(b *Bridge) {
+		b.heartbeat = interval
+	}
+}
+
+// WithReconnect wait before reconnecting, doubled after each failure up to max
+func WithReconnect(min, max time.Duration) bridgeOption {
+	return func(b *Bridge) {
+		b.minBackoff = min
+		b.maxBackoff = max
+	}
+}
+
+// WithDialTimeout timeout of each connecting attempt
+func WithDialTimeout(timeout time.Duration) bridgeOption {
+	return func(b *Bridge) {
+		b.dialTimeout = timeout
+	}
+}
+
+// WithPeerBuffer messages buffered for each peer while sending or disconnected
+func WithPeerBuffer(size int) bridgeOption {
+	return func(b *Bridge) {
+		b.bufferSize = size
+	}
+}
+
+// WithDeliver deal with the messages received from peers, Inject by default
+func WithDeliver(deliver func(Message) error) bridgeOption {
+	return func(b *Bridge) {
+		b.deliver = deliver
+	}
+}
+
+// NewBridge bridge of the local kernel, node is the name peers know it by
+func NewBridge(node string, opts ...bridgeOption) *Bridge {
+	sid := make([]byte, 8)
+	_, _ = rand.Read(sid)
+
+	b := &Bridge{
+		node:        node,
+		session:     hex.EncodeToString(sid),
+		heartbeat:   5 * time.Second,
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  30 * time.Second,
+		dialTimeout: 5 * time.Second,
+		bufferSize:  1024,
+		deliver:     Inject,
+		peers:       make(map[string]*peer),
+		routes:      make(map[int64]string),
+		done:        make(chan struct{}),
+	}
+	for _, o := range opts {
+		o(b)
+	}
+	b.validate()
+	return b
+}
+
+// validate replace the invalid options by the defaults, such as the heartbeat the ticker panics with
+func (b *Bridge) validate() {
+	if b.heartbeat <= 0 {
+		Logger.Warn(fmt.Sprintf("Bridge[%s] invalid heartbeat %s, use 5s", b.node, b.heartbeat))
+		b.heartbeat = 5 * time.Second
+	}
+	if b.minBackoff <= 0 {
+		b.minBackoff = 100 * time.Millisecond
+	}
+	if b.maxBackoff < b.minBackoff {
+		b.maxBackoff = b.minBackoff
+	}
+	if b.dialTimeout <= 0 {
+		b.dialTimeout = 5 * time.Second
+	}
+	if b.bufferSize <= 0 {
+		b.bufferSize = 1024
+	}
+	if b.deliver == nil {
+		b.deliver = Inject
+	}
+}
+
+// Listen accept connections from peers on addr
+func (b *Bridge) Listen(addr string) error {
+	l, e := net.Listen("tcp", addr)
+	if e != nil {
+		return e
+	}
+
+	b.m.Lock()
+	if b.closed {
+		b.m.Unlock()
+		_ = l.Close()
+		return ErrBridgeClosed
+	}
+	b.listener = l
+	b.wg.Add(1)
+	b.m.Unlock()
+
+	go b.accept(l)
+	return nil
+}
+
+// Addr the address listened, nil before Listen
+func (b *Bridge) Addr() net.Addr {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.listener == nil {
+		return nil
+	}
+	return b.listener.Addr()
+}
+
+// Connect keep a connection to the peer named name at addr,
+// reconnect with backoff when the connection lost
+func (b *Bridge) Connect(name, addr string) error {
+	b.m.Lock()
+	if b.closed {
+		b.m.Unlock()
+		return ErrBridgeClosed
+	}
+	p := b.peerLocked(name)
+	b.wg.Add(1)
+	b.m.Unlock()
+
+	go b.dial(p, addr)
+	return nil
+}
+
+// Route forward the messages of identifier to the peer,
+// the identifier is installed into kernel as remote module
+func (b *Bridge) Route(identifier int64, name string) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.closed {
+		return false
+	}
+
+	if !InstallRemote(identifier, b.peerLocked(name)) {
+		return false
+	}
+	b.routes[identifier] = name
+	return true
+}
+
+// Unroute stop forwarding the messages of identifier
+func (b *Bridge) Unroute(identifier int64) bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	if _, ok := b.routes[identifier]; !ok {
+		return false
+	}
+	delete(b.routes, identifier)
+	return UninstallModule(identifier)
+}
+
+// Routes copy of the routing table, module identifier to peer name
+func (b *Bridge) Routes() map[int64]string {
+	b.m.Lock()
+	defer b.m.Unlock()
+
+	r := make(map[int64]string, len(b.routes))
+	for id, name := range b.routes {
+		r[id] = name
+	}
+	return r
+}
+
+// Connected whether the peer named name is connected now
+func (b *Bridge) Connected(name string) bool {
+	b.m.Lock()
+	p, ok := b.peers[name]
+	b.m.Unlock()
+	if !ok {
+		return false
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.conn != nil
+}
+
+// Close stop listening, close all connections and uninstall the routes
+// messages still buffered for the peers are discarded
+func (b *Bridge) Close() error {
+	b.m.Lock()
+	if b.closed {
+		b.m.Unlock()
+		return nil
+	}
+	b.closed = true
+	close(b.done)
+
+	var e error
+	if b.listener != nil {
+		e = b.listener.Close()
+	}
+	for id := range b.routes {
+		UninstallModule(id)
+	}
+	b.routes = make(map[int64]string)
+	for _, p := range b.peers {
+		p.m.Lock()
+		if p.conn != nil {
+			_ = p.conn.Close()
+		}
+		p.m.Unlock()
+	}
+	b.m.Unlock()
+
+	b.wg.Wait()
+	return e
+}
+
+// peerLocked get or create the peer, b.m should be locked
+func (b *Bridge) peerLocked(name string) *peer {
+	p, ok := b.peers[name]
+	if !ok {
+		p = &peer{
+			bridge: b,
+			name:   name,
+			out:    make(chan Message, b.bufferSize),
+			acked:  make(chan struct{}, 1),
+			ackDue: make(chan struct{}, 1),
+		}
+		b.peers[name] = p
+	}
+	return p
+}
+
+// isClosed whether the bridge closed
+func (b *Bridge) isClosed() bool {
+	select {
+	case <-b.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// accept the connections until listener closed
+func (b *Bridge) accept(l net.Listener) {
+	defer b.wg.Done()
+
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			if !b.isClosed() {
+				Logger.Error(fmt.Sprintf("Bridge[%s] accept failed: %v", b.node, e))
+			}
+			return
+		}
+
+		b.wg.Add(1)
+		go func() {
+			defer b.wg.Done()
+
+			r := bufio.NewReader(conn)
+			h, e := b.handshake(conn, r, true)
+			if e != nil {
+				Logger.Error(fmt.Sprintf("Bridge[%s] handshake with %s failed: %v", b.node, conn.RemoteAddr(), e))
+				_ = conn.Close()
+				return
+			}
+
+			b.m.Lock()
+			if b.closed {
+				b.m.Unlock()
+				_ = conn.Close()
+				return
+			}
+			p := b.peerLocked(h.Node)
+			b.m.Unlock()
+
+			Logger.Info(fmt.Sprintf("Bridge[%s] peer %s connected from %s", b.node, h.Node, conn.RemoteAddr()))
+			b.serve(p, conn, r, h, false)
+		}()
+	}
+}
+
+// dial connect to the peer until bridge closed
+func (b *Bridge) dial(p *peer, addr string) {
+	defer b.wg.Done()
+
+	backoff := b.minBackoff
+	for !b.isClosed() {
+		// the peer dialed this side too, its connection is kept
+		if b.standby(p) {
+			select {
+			case <-b.done:
+				return
+			case <-time.After(b.heartbeat):
+			}
+			continue
+		}
+
+		conn, e := net.DialTimeout("tcp", addr, b.dialTimeout)
+		var (
+			r *bufio.Reader
+			h hello
+		)
+		if e == nil {
+			r = bufio.NewReader(conn)
+			if h, e = b.handshake(conn, r, false); e == nil && h.Node != p.name {
+				e = fmt.Errorf("core: expected peer %s, but %s answered", p.name, h.Node)
+			}
+			if e != nil {
+				_ = conn.Close()
+			}
+		}
+
+		if e != nil {
+			Logger.Error(fmt.Sprintf("Bridge[%s] connect to %s(%s) failed: %v, retry in %s", b.node, p.name, addr, e, backoff))
+			select {
+			case <-b.done:
+				return
+			case <-time.After(backoff):
+			}
+			if backoff *= 2; backoff > b.maxBackoff {
+				backoff = b.maxBackoff
+			}
+			continue
+		}
+
+		backoff = b.minBackoff
+		Logger.Info(fmt.Sprintf("Bridge[%s] connected to %s(%s)", b.node, p.name, addr))
+		b.serve(p, conn, r, h, true)
+	}
+}
+
+// standby the peer connected to this side and its connection is preferred, dialing waits
+func (b *Bridge) standby(p *peer) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.conn != nil && !p.dialed && p.name < b.node
+}
+
+// preferred when both sides connected to each other, the connection dialed by the lower node is kept
+func (b *Bridge) preferred(p *peer, dialed bool) bool {
+	return dialed == (b.node < p.name)
+}
+
+// handshake exchange hello with the other side, accepted side waits the hello first
+func (b *Bridge) handshake(conn net.Conn, r *bufio.Reader, accepted bool) (hello, error) {
+	var h hello
+	_ = conn.SetDeadline(time.Now().Add(b.dialTimeout))
+	defer func() {
+		_ = conn.SetDeadline(time.Time{})
+	}()
+
+	mine, _ := json.Marshal(hello{
+		Magic:     bridgeMagic,
+		Node:      b.node,
+		Session:   b.session,
+		Heartbeat: int64(b.heartbeat / time.Millisecond),
+	})
+	if !accepted {
+		if e := writeFrame(conn, frameHello, mine); e != nil {
+			return h, e
+		}
+	}
+
+	t, payload, e := readFrame(r)
+	if e != nil {
+		return h, e
+	}
+	if t != frameHello {
+		return h, fmt.Errorf("core: expected hello frame, got %d", t)
+	}
+	if e = json.Unmarshal(payload, &h); e != nil {
+		return h, e
+	}
+	if h.Magic != bridgeMagic {
+		return h, fmt.Errorf("core: unknown bridge protocol: %s", h.Magic)
+	}
+
+	if accepted {
+		if e = writeFrame(conn, frameHello, mine); e != nil {
+			return h, e
+		}
+	}
+	return h, nil
+}
+
+// serve exchange messages over the connection until it broken
+// dialed the connection connected by this side, it is closed at once if the peer
+// is connected the other way and that connection is preferred
+func (b *Bridge) serve(p *peer, conn net.Conn, r *bufio.Reader, h hello, dialed bool) {
+	p.m.Lock()
+	old := p.conn
+	// both sides dialed, unless the peer restarted and the old connection is stale
+	if old != nil && p.dialed != dialed && p.session == h.Session && b.preferred(p, p.dialed) {
+		p.m.Unlock()
+		Logger.Info(fmt.Sprintf("Bridge[%s] peer %s already connected, the duplicate connection closed", b.node, p.name))
+		_ = conn.Close()
+		return
+	}
+	p.conn = conn
+	p.dialed = dialed
+	// the peer restarted, its sequences restart too
+	if p.session != h.Session {
+		p.session = h.Session
+		p.received = 0
+	}
+	p.m.Unlock()
+	// the peer reconnected, the old connection is replaced
+	if old != nil {
+		_ = old.Close()
+	}
+
+	p.serving.Lock()
+	defer p.serving.Unlock()
+
+	// connection idle for 3 heartbeats of the slower side is broken
+	idle := b.heartbeat
+	if d := time.Duration(h.Heartbeat) * time.Millisecond; d > idle {
+		idle = d
+	}
+	idle *= 3
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		b.read(p, conn, r, idle)
+	}()
+	b.write(p, conn, done)
+	_ = conn.Close()
+	<-done
+
+	p.m.Lock()
+	if p.conn == conn {
+		p.conn = nil
+	}
+	p.m.Unlock()
+	Logger.Info(fmt.Sprintf("Bridge[%s] connection to %s closed", b.node, p.name))
+}
+
+// read the frames from connection until broken or idle too long
+func (b *Bridge) read(p *peer, conn net.Conn, r *bufio.Reader, idle time.Duration) {
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idle))
+		t, payload, e := readFrame(r)
+		if e != nil {
+			if !b.isClosed() && e != io.EOF {
+				Logger.Error(fmt.Sprintf("Bridge[%s] read from %s failed: %v", b.node, p.name, e))
+			}
+			return
+		}
+		if (t == frameMessage || t == frameAck) && len(payload) < 8 {
+			Logger.Error(fmt.Sprintf("Bridge[%s] short frame %d from %s", b.node, t, p.name))
+			return
+		}
+
+		switch t {
+		case frameHeartbeat:
+		case frameAck:
+			p.ack(binary.BigEndian.Uint64(payload))
+		case frameMessage:
+			seq := binary.BigEndian.Uint64(payload)
+			p.m.Lock()
+			duplicated := seq <= p.received
+			p.m.Unlock()
+
+			if !duplicated {
+				var m Message
+				if e = json.Unmarshal(payload[8:], &m); e != nil {
+					Logger.Error(fmt.Sprintf("Bridge[%s] invalid message from %s: %v", b.node, p.name, e))
+				} else {
+					_ = b.deliver(m)
+				}
+				p.m.Lock()
+				p.received = seq
+				p.m.Unlock()
+			}
+			notify(p.ackDue)
+		default:
+			Logger.Error(fmt.Sprintf("Bridge[%s] unknown frame %d from %s", b.node, t, p.name))
+		}
+	}
+}
+
+// write the buffered messages, acks and heartbeats until connection broken
+func (b *Bridge) write(p *peer, conn net.Conn, done <-chan struct{}) {
+	ticker := time.NewTicker(b.heartbeat)
+	defer ticker.Stop()
+
+	frame := func(t byte, payload []byte) bool {
+		_ = conn.SetWriteDeadline(time.Now().Add(3 * b.heartbeat))
+		return writeFrame(conn, t, payload) == nil
+	}
+	send := func(pd pending) bool {
+		payload := make([]byte, 8+len(pd.data))
+		binary.BigEndian.PutUint64(payload, pd.seq)
+		copy(payload[8:], pd.data)
+		return frame(frameMessage, payload)
+	}
+
+	// resend the messages not acknowledged on the previous connection
+	p.m.Lock()
+	resend := append([]pending(nil), p.inflight...)
+	p.m.Unlock()
+	for _, pd := range resend {
+		if !send(pd) {
+			return
+		}
+	}
+
+	for {
+		// stop taking messages when too many not acknowledged
+		var out chan Message
+		p.m.Lock()
+		if len(p.inflight) < b.bufferSize {
+			out = p.out
+		}
+		p.m.Unlock()
+
+		select {
+		case <-done:
+			return
+		case <-b.done:
+			return
+		case <-ticker.C:
+			if !frame(frameHeartbeat, nil) {
+				return
+			}
+		case <-p.acked:
+		case <-p.ackDue:
+			payload := make([]byte, 8)
+			p.m.Lock()
+			binary.BigEndian.PutUint64(payload, p.received)
+			p.m.Unlock()
+			if !frame(frameAck, payload) {
+				return
+			}
+		case m := <-out:
+			data, e := json.Marshal(m)
+			if e != nil {
+				Logger.Error(fmt.Sprintf("Bridge[%s] encode message[id: %d] failed: %v", b.node, m.Id, e))
+				continue
+			}
+			p.m.Lock()
+			p.seq++
+			pd := pending{seq: p.seq, data: data}
+			p.inflight = append(p.inflight, pd)
+			p.m.Unlock()
+			// kept in inflight and resent on the next connection
+			if !send(pd) {
+				return
+			}
+		}
+	}
+}
+
+// ack remove the messages acknowledged by the peer
+func (p *peer) ack(seq uint64) {
+	p.m.Lock()
+	i := 0
+	for i < len(p.inflight) && p.inflight[i].seq <= seq {
+		i++
+	}
+	p.inflight = append(p.inflight[:0], p.inflight[i:]...)
+	p.m.Unlock()
+	notify(p.acked)
+}
+
+// notify wake up the goroutine waiting on c without blocking
+func notify(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// Send buffer the message for the peer, implements Transport
+func (p *peer) Send(m Message) error {
+	if p.bridge.isClosed() {
+		return ErrBridgeClosed
+	}
+	select {
+	case p.out <- m:
+		return nil
+	default:
+		return ErrPeerBusy
+	}
+}
+
+// writeFrame write one frame in a single call
+func writeFrame(w io.Writer, t byte, payload []byte) error {
+	buf := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(buf, uint32(1+len(payload)))
+	buf[4] = t
+	copy(buf[5:], payload)
+	_, e := w.Write(buf)
+	return e
+}
+
+// readFrame read one frame, frames larger than maxFrameSize are refused
+func readFrame(r io.Reader) (byte, []byte, error) {
+	var head [4]byte
+	if _, e := io.ReadFull(r, head[:]); e != nil {
+		return 0, nil, e
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n == 0 || n > maxFrameSize {
+		return 0, nil, fmt.Errorf("core: invalid frame size %d", n)
+	}
+	buf := make([]byte, n)
+	if _, e := io.ReadFull(r, buf); e != nil {
+		return 0, nil, e
+	}
+	return buf[0], buf[1:], nil
+}
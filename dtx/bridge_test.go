@@ -0,0 +1,252 @@
+package core
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// proxy forward the connections to addr, the forwarding can be held and the connections cut
+type proxy struct {
+	t    *testing.T
+	l    net.Listener
+	addr string
+	// gate held to stop forwarding to addr
+	gate sync.Mutex
+
+	m     sync.Mutex
+	conns []net.Conn
+}
+
+func newProxy(t *testing.T, addr string) *proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &proxy{t: t, l: l, addr: addr}
+	go p.accept()
+	return p
+}
+
+func (p *proxy) accept() {
+	for {
+		in, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+		out, err := net.Dial("tcp", p.addr)
+		if err != nil {
+			_ = in.Close()
+			continue
+		}
+		p.m.Lock()
+		p.conns = append(p.conns, in, out)
+		p.m.Unlock()
+
+		go func() {
+			buf := make([]byte, 4096)
+			for {
+				n, err := in.Read(buf)
+				if err != nil {
+					_ = out.Close()
+					return
+				}
+				p.gate.Lock()
+				_, err = out.Write(buf[:n])
+				p.gate.Unlock()
+				if err != nil {
+					_ = in.Close()
+					return
+				}
+			}
+		}()
+		go func() {
+			_, _ = io.Copy(in, out)
+			_ = in.Close()
+		}()
+	}
+}
+
+// cut close the connections forwarded
+func (p *proxy) cut() {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, c := range p.conns {
+		_ = c.Close()
+	}
+	p.conns = nil
+}
+
+func (p *proxy) close() {
+	_ = p.l.Close()
+	p.cut()
+}
+
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBridgeLoopback(t *testing.T) {
+	received := make(chan Message, 16)
+	a := NewBridge("a", WithHeartbeat(50*time.Millisecond), WithDeliver(func(m Message) error {
+		received <- m
+		return nil
+	}))
+	defer a.Close()
+	if err := a.Listen("127.0.0.1:0"); err != nil {
+		t.Fatal(err)
+	}
+	px := newProxy(t, a.Addr().String())
+	defer px.close()
+
+	b := NewBridge("b", WithHeartbeat(50*time.Millisecond), WithReconnect(10*time.Millisecond, 50*time.Millisecond))
+	defer b.Close()
+	if err := b.Connect("a", px.l.Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "connected", func() bool { return b.Connected("a") })
+
+	b.m.Lock()
+	p := b.peers["a"]
+	b.m.Unlock()
+	inflight := func() int {
+		p.m.Lock()
+		defer p.m.Unlock()
+		return len(p.inflight)
+	}
+	expect := func(data string) {
+		t.Helper()
+		select {
+		case m := <-received:
+			if m.Data != data {
+				t.Fatalf("received %q, want %q", m.Data, data)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("%q not received", data)
+		}
+	}
+
+	if err := p.Send(Message{Id: 1, Identifier: 500, Data: "m1"}); err != nil {
+		t.Fatal(err)
+	}
+	expect("m1")
+	waitFor(t, "m1 acknowledged", func() bool { return inflight() == 0 })
+
+	// messages sent while the link is stalled stay not acknowledged
+	px.gate.Lock()
+	for _, data := range []string{"m2", "m3"} {
+		if err := p.Send(Message{Id: 2, Identifier: 500, Data: data}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor(t, "m2 m3 in flight", func() bool { return inflight() == 2 })
+
+	// the connection lost, b reconnects and resends them
+	px.cut()
+	px.gate.Unlock()
+	expect("m2")
+	expect("m3")
+	waitFor(t, "m2 m3 acknowledged", func() bool { return inflight() == 0 })
+	if !b.Connected("a") {
+		t.Error("not reconnected")
+	}
+
+	select {
+	case m := <-received:
+		t.Errorf("%q received twice", m.Data)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestBridgeInvalidOptions(t *testing.T) {
+	b := NewBridge("x", WithHeartbeat(0), WithReconnect(-1, -1), WithDialTimeout(0), WithPeerBuffer(0), WithDeliver(nil))
+	if b.heartbeat <= 0 || b.minBackoff <= 0 || b.maxBackoff < b.minBackoff || b.dialTimeout <= 0 || b.bufferSize <= 0 || b.deliver == nil {
+		t.Errorf("invalid options kept: %+v", b)
+	}
+}
+
+func TestBridgeSimultaneousDial(t *testing.T) {
+	received := map[string]chan Message{"a": make(chan Message, 16), "b": make(chan Message, 16)}
+	bridges := map[string]*Bridge{}
+	for _, name := range []string{"a", "b"} {
+		ch := received[name]
+		b := NewBridge(name, WithHeartbeat(20*time.Millisecond), WithReconnect(5*time.Millisecond, 20*time.Millisecond),
+			WithDeliver(func(m Message) error {
+				ch <- m
+				return nil
+			}))
+		defer b.Close()
+		if err := b.Listen("127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		bridges[name] = b
+	}
+	// both sides dial at once
+	if err := bridges["a"].Connect("b", bridges["b"].Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+	if err := bridges["b"].Connect("a", bridges["a"].Addr().String()); err != nil {
+		t.Fatal(err)
+	}
+
+	peerOf := func(b *Bridge, name string) *peer {
+		b.m.Lock()
+		defer b.m.Unlock()
+		return b.peers[name]
+	}
+	conn := func(p *peer) (net.Conn, bool) {
+		p.m.Lock()
+		defer p.m.Unlock()
+		return p.conn, p.dialed
+	}
+	tests := []struct {
+		node, peer string
+		// dialed the kept connection dialed by the node
+		dialed bool
+	}{
+		// the connection dialed by the lower node is kept by both sides
+		{"a", "b", true},
+		{"b", "a", false},
+	}
+	waitFor(t, "connected", func() bool {
+		for _, tt := range tests {
+			if c, dialed := conn(peerOf(bridges[tt.node], tt.peer)); c == nil || dialed != tt.dialed {
+				return false
+			}
+		}
+		return true
+	})
+	kept := map[string]net.Conn{}
+	for _, tt := range tests {
+		kept[tt.node], _ = conn(peerOf(bridges[tt.node], tt.peer))
+	}
+
+	// several heartbeats and reconnect intervals pass without the connections replaced
+	time.Sleep(200 * time.Millisecond)
+	for _, tt := range tests {
+		p := peerOf(bridges[tt.node], tt.peer)
+		if c, dialed := conn(p); c != kept[tt.node] || dialed != tt.dialed {
+			t.Errorf("%s: connection to %s replaced", tt.node, tt.peer)
+		}
+		if err := p.Send(Message{Id: 1, Identifier: 500, Data: tt.node}); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case m := <-received[tt.peer]:
+			if m.Data != tt.node {
+				t.Errorf("%s received %q, want %q", tt.peer, m.Data, tt.node)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("message of %s not received by %s", tt.node, tt.peer)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Clock source of the kernel time used by delays, deadlines and scheduled tasks
+// tests replace it with a virtual clock, see the coretest package
+type Clock interface {
+	Now() time.Time
+}
+
+// realClock the wall clock
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+// clockHolder keep the same concrete type in atomic.Value
+type clockHolder struct {
+	Clock
+}
+
+// kernelClock current clock of the kernel
+var kernelClock atomic.Value
+
+func init() {
+	kernelClock.Store(clockHolder{realClock{}})
+}
+
+// SetClock replace the kernel clock, nil restores the wall clock
+// the timers of a started kernel still wait in real time, drive the kernel with Poll and Fire instead
+func SetClock(c Clock) {
+	if c == nil {
+		c = realClock{}
+	}
+	kernelClock.Store(clockHolder{c})
+}
+
+// now time of the kernel clock
+func now() time.Time {
+	return kernelClock.Load().(clockHolder).Now()
+}
+
+// afterFunc call f once the kernel clock reaches at, at once when at passed, stop cancels the call
+// the wall clock uses a timer, other clocks a task of the scheduler fired by Fire
+func afterFunc(at time.Time, f func()) (stop func() bool) {
+	c := kernelClock.Load().(clockHolder).Clock
+	if _, ok := c.(realClock); ok {
+		return time.AfterFunc(time.Until(at), f).Stop
+	}
+	if !c.Now().Before(at) {
+		f()
+		return func() bool { return false }
+	}
+	id := ScheduleTask(At(at), func(context.Context) { f() }, WithTaskName("timer"), internalTask())
+	return func() bool { return CancelTask(id) }
+}
+
+// Poll route one message waiting in the inbox on the calling goroutine,
+// false when inbox empty
+// used to drive the kernel step by step instead of Start
+func Poll() bool {
+	m, ok := inbox.poll()
+	if !ok {
+		return false
+	}
+	routeInbox(m)
+	return true
+}
+
+// Fire post the delayed messages due and run the tasks due at the kernel clock,
+// tasks run on the calling goroutine, return the number of messages and tasks fired
+// used with a virtual clock instead of Start
+func Fire() int {
+	t := now()
+	n := 0
+	for _, m := range delays.popDue(t) {
+		if postMessage(m) {
+			n++
+		}
+	}
+	for _, task := range sched.due(t) {
+		if !atomic.CompareAndSwapInt32(&task.running, 0, 1) {
+			continue
+		}
+		sched.call(task)
+		atomic.StoreInt32(&task.running, 0)
+		n++
+	}
+	return n
+}
+
+// NextDue the earliest time a delayed message or task is due, false when none
+func NextDue() (time.Time, bool) {
+	at, ok := delays.next()
+	if next, scheduled := sched.next(); scheduled && (!ok || next.Before(at)) {
+		at, ok = next, true
+	}
+	return at, ok
+}
+
+// Reset restore the kernel to the state before any module installed, used between tests
+// modules are uninstalled, the messages, tasks, interceptors, dead letters, metrics and
+// pauses discarded, the default id generator, dead letter store and the wall clock restored
+// the kernel must not be started by Start, the modules are not notified
+func Reset() {
+	modules.Range(func(id, _ interface{}) bool {
+		modules.Delete(id)
+		return true
+	})
+	registry.m.Lock()
+	registry.names = make(map[string]int64)
+	registry.m.Unlock()
+
+	inbox = newPriorityQueue(cap(inbox.lanes[0]))
+	atomic.StoreInt64(&routing, 0)
+	kills.reset()
+	delays.reset()
+	sched.reset()
+	pauses.reset()
+
+	groups.m.Lock()
+	groups.g = make(map[string][]*supervised)
+	groups.m.Unlock()
+	failedModules.Range(func(id, _ interface{}) bool {
+		failedModules.Delete(id)
+		return true
+	})
+
+	interceptorLock.Lock()
+	interceptors.Store([]*interceptorEntry(nil))
+	interceptorLock.Unlock()
+	SetDeadLetterStore(NewMemoryStore(1000))
+	metrics.Range(func(id, _ interface{}) bool {
+		metrics.Delete(id)
+		return true
+	})
+	unknown = &moduleMetrics{latency: newHistogram()}
+
+	SetIdGenerator(nil)
+	resetDefaultId()
+	SetClock(nil)
+}
@@ -0,0 +1,166 @@
+package core
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+const (
+	// ContentTypeJSON payload encoded with encoding/json
+	ContentTypeJSON = "application/json"
+	// ContentTypeGob payload encoded with encoding/gob
+	ContentTypeGob = "application/x-gob"
+	// ContentTypeBytes raw bytes payload
+	ContentTypeBytes = "application/octet-stream"
+	// ContentTypeProtobuf raw bytes of a protobuf message
+	ContentTypeProtobuf = "application/x-protobuf"
+)
+
+// Codec encode and decode the typed payload carried by Message.Data
+type Codec interface {
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// codecs registered codecs, map[string]Codec
+var codecs sync.Map
+
+func init() {
+	RegisterCodec(jsonCodec{})
+	RegisterCodec(gobCodec{})
+	RegisterCodec(rawCodec{contentType: ContentTypeBytes})
+	RegisterCodec(rawCodec{contentType: ContentTypeProtobuf})
+}
+
+// RegisterCodec register codec for its content type, replace the old one
+func RegisterCodec(c Codec) {
+	codecs.Store(c.ContentType(), c)
+}
+
+// CodecOf get the codec of the content type
+func CodecOf(contentType string) (Codec, bool) {
+	c, ok := codecs.Load(contentType)
+	if !ok {
+		return nil, false
+	}
+	return c.(Codec), true
+}
+
+// WithContentType content type of the message data
+func WithContentType(contentType string) sendOption {
+	return func(o *option) {
+		o.ContentType = contentType
+	}
+}
+
+// SendTyped encode v with the codec of content type and send it to the module
+// content type is JSON unless WithContentType given
+func SendTyped(identifier int64, v interface{}, opts ...sendOption) bool {
+	opt := &option{ContentType: ContentTypeJSON}
+	for _, o := range opts {
+		o(opt)
+	}
+
+	c, ok := CodecOf(opt.ContentType)
+	if !ok {
+		Logger.Error(fmt.Sprintf("No codec registered for content type: [%s]", opt.ContentType))
+		return false
+	}
+	data, e := c.Marshal(v)
+	if e != nil {
+		Logger.Error(fmt.Sprintf("Encode message to: %d failed: %v", identifier, e))
+		return false
+	}
+
+	return SendMessage(append(opts,
+		WithIdInt64(identifier),
+		WithData(string(data)),
+		WithContentType(opt.ContentType),
+	)...)
+}
+
+// Decode decode the message data into v with the codec of its content type
+// message without content type is decoded as JSON, or copied when v is *string
+func (m *Message) Decode(v interface{}) error {
+	contentType := m.ContentType
+	if contentType == "" {
+		if s, ok := v.(*string); ok {
+			*s = m.Data
+			return nil
+		}
+		contentType = ContentTypeJSON
+	}
+
+	c, ok := CodecOf(contentType)
+	if !ok {
+		return fmt.Errorf("core: no codec registered for content type: [%s]", contentType)
+	}
+	return c.Unmarshal([]byte(m.Data), v)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string { return ContentTypeJSON }
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) { return json.Marshal(v) }
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) ContentType() string { return ContentTypeGob }
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if e := gob.NewEncoder(&buf).Encode(v); e != nil {
+		return nil, e
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// rawCodec pass through bytes, values with Marshal/Unmarshal methods
+// like the protobuf generated messages are supported too
+type rawCodec struct {
+	contentType string
+}
+
+func (c rawCodec) ContentType() string { return c.contentType }
+
+func (c rawCodec) Marshal(v interface{}) ([]byte, error) {
+	switch x := v.(type) {
+	case []byte:
+		return x, nil
+	case string:
+		return []byte(x), nil
+	case interface{ Marshal() ([]byte, error) }:
+		return x.Marshal()
+	case encoding.BinaryMarshaler:
+		return x.MarshalBinary()
+	}
+	return nil, fmt.Errorf("core: %s codec can not marshal %T", c.contentType, v)
+}
+
+func (c rawCodec) Unmarshal(data []byte, v interface{}) error {
+	switch x := v.(type) {
+	case *[]byte:
+		*x = append((*x)[:0], data...)
+		return nil
+	case *string:
+		*x = string(data)
+		return nil
+	case interface{ Unmarshal([]byte) error }:
+		return x.Unmarshal(data)
+	case encoding.BinaryUnmarshaler:
+		return x.UnmarshalBinary(data)
+	}
+	return fmt.Errorf("core: %s codec can not unmarshal into %T", c.contentType, v)
+}
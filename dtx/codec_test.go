@@ -0,0 +1,127 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+type point struct {
+	X, Y int
+}
+
+// fakeProto value with the methods of the protobuf generated messages
+type fakeProto struct {
+	data string
+}
+
+func (p *fakeProto) Marshal() ([]byte, error) { return []byte(p.data), nil }
+
+func (p *fakeProto) Unmarshal(b []byte) error {
+	p.data = string(b)
+	return nil
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	tests := []struct {
+		contentType string
+		in          interface{}
+		// out pointer the data decoded into
+		out  interface{}
+		want interface{}
+	}{
+		{ContentTypeJSON, point{1, 2}, &point{}, &point{1, 2}},
+		{ContentTypeGob, point{3, 4}, &point{}, &point{3, 4}},
+		{ContentTypeBytes, []byte{0, 0xff}, &[]byte{}, &[]byte{0, 0xff}},
+		{ContentTypeBytes, "text", new(string), func() *string { s := "text"; return &s }()},
+		{ContentTypeProtobuf, &fakeProto{"proto"}, &fakeProto{}, &fakeProto{"proto"}},
+	}
+	for _, tt := range tests {
+		c, ok := CodecOf(tt.contentType)
+		if !ok {
+			t.Fatalf("no codec for %s", tt.contentType)
+		}
+		data, err := c.Marshal(tt.in)
+		if err != nil {
+			t.Errorf("%s: Marshal(%v): %v", tt.contentType, tt.in, err)
+			continue
+		}
+		m := Message{ContentType: tt.contentType, Data: string(data)}
+		if err = m.Decode(tt.out); err != nil {
+			t.Errorf("%s: Decode: %v", tt.contentType, err)
+			continue
+		}
+		if !reflect.DeepEqual(tt.out, tt.want) {
+			t.Errorf("%s: decoded %v, want %v", tt.contentType, tt.out, tt.want)
+		}
+	}
+}
+
+func TestCodecRawInvalid(t *testing.T) {
+	c, _ := CodecOf(ContentTypeProtobuf)
+	if _, err := c.Marshal(point{}); err == nil {
+		t.Error("struct marshaled by raw codec")
+	}
+	if err := c.Unmarshal(nil, &point{}); err == nil {
+		t.Error("raw data unmarshaled into struct")
+	}
+}
+
+func TestDecodeWithoutContentType(t *testing.T) {
+	var s string
+	if err := (&Message{Data: "plain"}).Decode(&s); err != nil || s != "plain" {
+		t.Errorf("Decode into string = %q, %v", s, err)
+	}
+	var p point
+	if err := (&Message{Data: `{"X": 5}`}).Decode(&p); err != nil || p.X != 5 {
+		t.Errorf("Decode as JSON = %+v, %v", p, err)
+	}
+	if err := (&Message{ContentType: "unknown", Data: "x"}).Decode(&s); err == nil {
+		t.Error("decoded without codec")
+	}
+}
+
+func TestSendTyped(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		v           interface{}
+		sent        bool
+		// reason of the dead letter, empty when delivered
+		reason Reason
+	}{
+		{"json by default", "", point{1, 2}, true, ""},
+		{"gob", ContentTypeGob, point{1, 2}, true, ""},
+		{"not accepted", ContentTypeBytes, []byte("a"), false, ReasonNotAccepted},
+		{"no codec", "unknown", point{}, false, ""},
+		{"not encodable", ContentTypeJSON, make(chan int), false, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			queue := make(chan Message, 1)
+			InstallModule(1, queue, WithAccepts(ContentTypeJSON, ContentTypeGob))
+
+			var opts []sendOption
+			if tt.contentType != "" {
+				opts = append(opts, WithContentType(tt.contentType))
+			}
+			if sent := SendTyped(1, tt.v, opts...); sent != tt.sent {
+				t.Fatalf("SendTyped = %v, want %v", sent, tt.sent)
+			}
+			for Poll() {
+			}
+			if tt.sent {
+				m := <-queue
+				var p point
+				if err := m.Decode(&p); err != nil || !reflect.DeepEqual(p, tt.v) {
+					t.Errorf("received %+v, %v", p, err)
+				}
+			}
+			letters, _ := DeadLetters(DeadLetterQuery{})
+			if (len(letters) == 1) != (tt.reason != "") || (len(letters) == 1 && letters[0].Reason != tt.reason) {
+				t.Errorf("dead letters %+v, want reason %q", letters, tt.reason)
+			}
+		})
+	}
+}
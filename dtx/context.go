@@ -0,0 +1,255 @@
+package core
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MetadataTraceparent metadata key of the W3C traceparent of the message
+const MetadataTraceparent = "traceparent"
+
+// ErrExpired message deadline passed before delivered
+var ErrExpired = errors.New("core: message deadline exceeded")
+
+// Trace W3C trace context: version 00, trace id, parent span id and flags
+type Trace struct {
+	TraceId [16]byte
+	SpanId  [8]byte
+	Flags   byte
+}
+
+// NewTrace start a new sampled trace
+func NewTrace() Trace {
+	t := Trace{Flags: 1}
+	_, _ = rand.Read(t.TraceId[:])
+	_, _ = rand.Read(t.SpanId[:])
+	return t
+}
+
+// Child the span of the next hop in the same trace
+func (t Trace) Child() Trace {
+	c := Trace{TraceId: t.TraceId, Flags: t.Flags}
+	_, _ = rand.Read(c.SpanId[:])
+	return c
+}
+
+// String the traceparent header value
+func (t Trace) String() string {
+	return fmt.Sprintf("00-%s-%s-%02x", hex.EncodeToString(t.TraceId[:]), hex.EncodeToString(t.SpanId[:]), t.Flags)
+}
+
+// ParseTraceparent parse the traceparent header value
+func ParseTraceparent(s string) (Trace, error) {
+	var t Trace
+	parts := strings.Split(strings.TrimSpace(s), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return t, fmt.Errorf("core: invalid traceparent: %s", s)
+	}
+	if parts[0] == "ff" || (parts[0] == "00" && len(parts) != 4) {
+		return t, fmt.Errorf("core: unsupported traceparent version: %s", s)
+	}
+	if _, e := hex.Decode(t.TraceId[:], []byte(parts[1])); e != nil {
+		return t, fmt.Errorf("core: invalid trace id: %s", s)
+	}
+	if _, e := hex.Decode(t.SpanId[:], []byte(parts[2])); e != nil {
+		return t, fmt.Errorf("core: invalid span id: %s", s)
+	}
+	var flags [1]byte
+	if _, e := hex.Decode(flags[:], []byte(parts[3])); e != nil {
+		return t, fmt.Errorf("core: invalid trace flags: %s", s)
+	}
+	t.Flags = flags[0]
+	if t.TraceId == ([16]byte{}) || t.SpanId == ([8]byte{}) {
+		return t, fmt.Errorf("core: all zero trace id or span id: %s", s)
+	}
+	return t, nil
+}
+
+// traceKey context key of the Trace
+type traceKey struct{}
+
+// ContextWithTrace attach trace to ctx, messages sent WithContext(ctx) continue the trace
+func ContextWithTrace(ctx context.Context, t Trace) context.Context {
+	return context.WithValue(ctx, traceKey{}, t)
+}
+
+// TraceFromContext trace attached to ctx
+func TraceFromContext(ctx context.Context) (Trace, bool) {
+	t, ok := ctx.Value(traceKey{}).(Trace)
+	return t, ok
+}
+
+// Trace the trace of the message from its traceparent metadata
+func (m *Message) Trace() (Trace, bool) {
+	v, ok := m.Metadata[MetadataTraceparent]
+	if !ok {
+		return Trace{}, false
+	}
+	t, e := ParseTraceparent(v)
+	return t, e == nil
+}
+
+// Expired whether the deadline of message passed
+func (m *Message) Expired(now time.Time) bool {
+	return !m.Deadline.IsZero() && now.After(m.Deadline)
+}
+
+// Context of the message carrying its deadline and trace,
+// messages dealt by supervised handlers have the context cancelled after the handler returned,
+// the others have it cancelled at the deadline
+// send the next hop WithContext(m.Context()) to continue the trace
+func (m *Message) Context() context.Context {
+	if m.ctx == nil {
+		if m.Deadline.IsZero() {
+			m.ctx = m.traceContext()
+		} else {
+			// the timer is released when the deadline passed
+			m.ctx, _ = withDeadline(m.traceContext(), m.Deadline)
+		}
+	}
+	return m.ctx
+}
+
+// traceContext background context with the trace of message
+func (m *Message) traceContext() context.Context {
+	ctx := context.Background()
+	if t, ok := m.Trace(); ok {
+		ctx = ContextWithTrace(ctx, t)
+	}
+	return ctx
+}
+
+// newContext context with the deadline and trace of message, cancel releases its timer
+func (m *Message) newContext() (context.Context, context.CancelFunc) {
+	ctx := m.traceContext()
+	if m.Deadline.IsZero() {
+		return context.WithCancel(ctx)
+	}
+	return withDeadline(ctx, m.Deadline)
+}
+
+// context states of deadlineContext
+const (
+	contextLive int32 = iota
+	contextExpired
+	contextCancelled
+)
+
+// deadlineContext context cancelled when the kernel clock reaches deadline,
+// context.WithDeadline measures the deadline by the wall clock instead
+type deadlineContext struct {
+	context.Context
+	deadline time.Time
+	state    int32
+}
+
+func (c *deadlineContext) Deadline() (time.Time, bool) {
+	return c.deadline, true
+}
+
+func (c *deadlineContext) Err() error {
+	if atomic.LoadInt32(&c.state) == contextExpired {
+		return context.DeadlineExceeded
+	}
+	return c.Context.Err()
+}
+
+// withDeadline context of parent expired at deadline by the kernel clock
+func withDeadline(parent context.Context, deadline time.Time) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(parent)
+	c := &deadlineContext{Context: ctx, deadline: deadline}
+	stop := afterFunc(deadline, func() {
+		if atomic.CompareAndSwapInt32(&c.state, contextLive, contextExpired) {
+			cancel()
+		}
+	})
+	return c, func() {
+		atomic.CompareAndSwapInt32(&c.state, contextLive, contextCancelled)
+		stop()
+		cancel()
+	}
+}
+
+// WithContext deadline and trace of the message come from ctx
+func WithContext(ctx context.Context) sendOption {
+	return func(o *option) {
+		o.Context = ctx
+	}
+}
+
+// WithDeadline message is discarded when not delivered before t
+func WithDeadline(t time.Time) sendOption {
+	return func(o *option) {
+		o.Deadline = t
+	}
+}
+
+// WithTTL message is discarded when not delivered in ttl
+func WithTTL(ttl time.Duration) sendOption {
+	return func(o *option) {
+		o.Deadline = now().Add(ttl)
+	}
+}
+
+// WithTrace start a new trace when the message continues none
+func WithTrace() sendOption {
+	return func(o *option) {
+		o.Trace = true
+	}
+}
+
+// WithMetadata attach key value to the message
+func WithMetadata(key, value string) sendOption {
+	return func(o *option) {
+		if o.Metadata == nil {
+			o.Metadata = make(map[string]string)
+		}
+		o.Metadata[key] = value
+	}
+}
+
+// propagate fill the deadline and trace of message from the send options
+// the trace continues the one of the context or the traceparent metadata given,
+// a new one is started only WithTrace
+func propagate(m *Message, opt *option) {
+	m.Deadline = opt.Deadline
+	if opt.Context != nil {
+		if d, ok := opt.Context.Deadline(); ok && (m.Deadline.IsZero() || d.Before(m.Deadline)) {
+			m.Deadline = d
+		}
+	}
+
+	var trace string
+	if v, ok := opt.Metadata[MetadataTraceparent]; ok {
+		if parent, e := ParseTraceparent(v); e == nil {
+			trace = parent.Child().String()
+		}
+	}
+	if trace == "" && opt.Context != nil {
+		if parent, ok := TraceFromContext(opt.Context); ok {
+			trace = parent.Child().String()
+		}
+	}
+	if trace == "" && opt.Trace {
+		trace = NewTrace().String()
+	}
+	if trace == "" && len(opt.Metadata) == 0 {
+		return
+	}
+
+	m.Metadata = make(map[string]string, len(opt.Metadata)+1)
+	for k, v := range opt.Metadata {
+		if k != MetadataTraceparent {
+			m.Metadata[k] = v
+		}
+	}
+	if trace != "" {
+		m.Metadata[MetadataTraceparent] = trace
+	}
+}
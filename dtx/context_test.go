@@ -0,0 +1,83 @@
+package core
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func internalTasks() int {
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	n := 0
+	for _, t := range sched.tasks {
+		if t.internal {
+			n++
+		}
+	}
+	return n
+}
+
+func TestMessageContextDeadline(t *testing.T) {
+	c := newTestClock(t)
+	t0 := c.Now()
+	m := Message{Deadline: t0.Add(time.Minute)}
+	ctx := m.Context()
+	if deadline, ok := ctx.Deadline(); !ok || !deadline.Equal(m.Deadline) {
+		t.Errorf("Deadline() = %s %v, want %s", deadline, ok, m.Deadline)
+	}
+
+	tests := []struct {
+		advance time.Duration
+		err     error
+	}{
+		// the wall clock is far past the deadline, the kernel clock is not
+		{0, nil},
+		{59 * time.Second, nil},
+		{time.Second, context.DeadlineExceeded},
+	}
+	for _, tt := range tests {
+		c.advance(tt.advance)
+		Fire()
+		if err := ctx.Err(); err != tt.err {
+			t.Errorf("at %s: Err() = %v, want %v", c.Now().Sub(t0), err, tt.err)
+		}
+	}
+	select {
+	case <-ctx.Done():
+	default:
+		t.Error("Done() not closed after the deadline")
+	}
+	if n := internalTasks(); n != 0 {
+		t.Errorf("%d timers left after the deadline", n)
+	}
+}
+
+func TestMessageContextCancel(t *testing.T) {
+	c := newTestClock(t)
+	m := Message{Deadline: c.Now().Add(time.Minute)}
+	ctx, cancel := m.newContext()
+	if n := internalTasks(); n != 1 {
+		t.Fatalf("%d timers, want 1", n)
+	}
+	if len(Stats().Tasks) != 0 {
+		t.Errorf("timer listed in stats %+v", Stats().Tasks)
+	}
+
+	// the handler returned
+	cancel()
+	if n := internalTasks(); n != 0 {
+		t.Errorf("%d timers left after cancel", n)
+	}
+	c.advance(time.Hour)
+	Fire()
+	if err := ctx.Err(); err != context.Canceled {
+		t.Errorf("Err() = %v, want %v", err, context.Canceled)
+	}
+
+	// deadline passed before the context created
+	expired := Message{Deadline: c.Now().Add(-time.Second)}
+	if err := expired.Context().Err(); err != context.DeadlineExceeded {
+		t.Errorf("Err() of expired message = %v", err)
+	}
+}
@@ -1,8 +1,11 @@
 package core
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"go.uber.org/zap"
@@ -11,41 +14,149 @@ import (
 // Message all messages delivered with the following structure
 // When sending message to core kernel, omit ID parameter
 type Message struct {
-	Id         int64  `json:"id"`
-	Identifier int64  `json:"identifier"`
-	Data       string `json:"data"`
-	Signal     int    `json:"signal"`
+	Id          int64             `json:"id"`
+	Identifier  int64             `json:"identifier"`
+	Source      int64             `json:"source,omitempty"`
+	Data        string            `json:"data"`
+	Signal      int               `json:"signal"`
+	ContentType string            `json:"content_type"`
+	Priority    int               `json:"priority"`
+	Key         string            `json:"key,omitempty"`
+	Metadata    map[string]string `json:"metadata,omitempty"`
+	Deadline    time.Time         `json:"deadline"`
+
+	// ctx context of the message, see Context
+	ctx context.Context
 }
 
-// Tasks all tasks need to be running each seconds
-type Tasks struct {
-	m      sync.Mutex
-	runner []func()
+// module inner struct of the plugin installed in kernel
+type module struct {
+	id    int64
+	queue chan Message
+	// lanes priority queues of the module, used instead of queue when set
+	lanes *priorityQueue
+	// dispatched take the messages the module took out of lanes but not yet dealt
+	dispatched func() []Message
+	// accepts content types the module declared, empty accepts all
+	accepts map[string]bool
+	// remote transport of the module installed in other process
+	remote Transport
+	// sendLimit and receiveLimit throttle the messages sent by and to the module
+	sendLimit    *limiter
+	receiveLimit *limiter
+	// name, version and capabilities declared in registry
+	name         string
+	version      string
+	capabilities map[string]string
 }
 
-// idType inner struct used for global message identifier
-type idType struct {
-	M  sync.Mutex
-	Id int64
+type installOption func(*module)
+
+// WithAccepts declare the content types the module accepts
+// messages with other content types are rejected by SendMessage
+// use "" to accept the plain messages sent WithData
+func WithAccepts(contentTypes ...string) installOption {
+	return func(m *module) {
+		if m.accepts == nil {
+			m.accepts = make(map[string]bool)
+		}
+		for _, ct := range contentTypes {
+			m.accepts[ct] = true
+		}
+	}
+}
+
+// withLanes deliver the messages into priority lanes instead of queue
+func withLanes(lanes *priorityQueue) installOption {
+	return func(m *module) {
+		m.lanes = lanes
+	}
+}
+
+// withDispatched drain the messages taken out of lanes along with the lanes
+func withDispatched(dispatched func() []Message) installOption {
+	return func(m *module) {
+		m.dispatched = dispatched
+	}
+}
+
+// put message into the queue of module, blocks when full
+func (m *module) put(message Message) {
+	if m.lanes != nil {
+		m.lanes.put(message)
+		return
+	}
+	m.queue <- message
+}
+
+// depth messages waiting in the queue of module
+func (m *module) depth() int {
+	if m.lanes != nil {
+		return m.lanes.len()
+	}
+	return len(m.queue)
+}
+
+// capacity of the queue of module
+func (m *module) capacity() int {
+	if m.lanes != nil {
+		return m.lanes.cap()
+	}
+	return cap(m.queue)
+}
+
+// newModule module with the queue and options
+func newModule(id int64, queue chan Message, opts []installOption) *module {
+	m := &module{
+		id:    id,
+		queue: queue,
+	}
+	for _, o := range opts {
+		o(m)
+	}
+	return m
+}
+
+// drain take the messages waiting in the queue of module without blocking,
+// the ones already dispatched by the module come first
+func (m *module) drain() []Message {
+	if m.lanes != nil {
+		var pending []Message
+		if m.dispatched != nil {
+			pending = m.dispatched()
+		}
+		return append(pending, m.lanes.drain()...)
+	}
+	var pending []Message
+	for {
+		select {
+		case message := <-m.queue:
+			pending = append(pending, message)
+		default:
+			return pending
+		}
+	}
+}
+
+// accept whether the module accepts the message, signals are always accepted
+func (m *module) accept(message *Message) bool {
+	if len(m.accepts) == 0 || message.Signal != NORMAL {
+		return true
+	}
+	return m.accepts[message.ContentType]
 }
 
 var (
-	// inMessage messages from other plugins
-	inMessage chan Message
+	// inbox messages from other plugins, one lane per priority
+	inbox *priorityQueue
 
 	// modules plugins that installed
-	// modules map[int64]chan Message
+	// modules map[int64]*module
 	modules sync.Map
 
 	// initialised once used sync.Once.Do(func(){})
 	once sync.Once
 
-	// _gId global id(identifier)
-	_gId idType
-
-	// runner 1s tasks
-	runner Tasks
-
 	// Logger kernel logger
 	Logger *zap.Logger
 
@@ -53,30 +164,27 @@ var (
 	err error
 )
 
+var (
+	// ErrNotInstalled no module installed with the message identifier
+	ErrNotInstalled = errors.New("core: module not installed")
+	// ErrShutdown kernel already shutdown
+	ErrShutdown = errors.New("core: kernel shutdown")
+)
+
 const (
 	// NORMAL common signal
 	NORMAL = 0
 	// SignalKill exit signal
 	// This signal will need the plugin to close the message channel
+	// it is delivered after the messages sent to the module before it,
+	// the module is uninstalled once it is delivered
 	SignalKill = 1
 )
 
-// increaseId Increase global id and return the result
-func increaseId() int64 {
-	_gId.M.Lock()
-	defer _gId.M.Unlock()
-	if _gId.Id >= 2<<60 {
-		_gId.Id = 0
-	}
-	_gId.Id++
-	return _gId.Id
-}
-
-// init
 func init() {
 	once.Do(func() {
-		if inMessage == nil {
-			inMessage = make(chan Message, 1000)
+		if inbox == nil {
+			inbox = newPriorityQueue(1000)
 		}
 
 		Logger, err = zap.NewProduction()
@@ -87,12 +195,22 @@ func init() {
 }
 
 // InstallModule install plugin into core kernel
-func InstallModule(id int64, queue chan Message) bool {
+// the name of module installed WithName should be unique
+func InstallModule(id int64, queue chan Message, opts ...installOption) bool {
+	mod := newModule(id, queue, opts)
+
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
 	if _, ok := modules.Load(id); ok {
 		Logger.Error(fmt.Sprintf("Plugins already installed with the identifier: %d", id))
 		return false
 	}
-	modules.Store(id, queue)
+	if !claim(mod) {
+		Logger.Error(fmt.Sprintf("Plugins already installed with the name: %s", mod.name))
+		return false
+	}
+	modules.Store(id, mod)
 	return true
 }
 
@@ -106,25 +224,36 @@ func IsExitSignal(m *Message) bool {
 }
 
 // PrintModule Print all modules which installed in core-kernel
+// Deprecated: use Modules to list the installed modules
 func PrintModule() {
-	modules.Range(func(key, value interface{}) bool {
-		Logger.Info(fmt.Sprintf("Installed module[id: %d]", key.(int64)))
-		return true
-	})
+	for _, i := range Modules() {
+		Logger.Info(fmt.Sprintf("Installed %s", i))
+	}
 }
 
 // UninstallModule uninstall plugin from core kernel
 func UninstallModule(id int64) bool {
-	if _, ok := modules.Load(id); ok {
+	if v, ok := modules.Load(id); ok {
 		modules.Delete(id)
+		forget(v.(*module))
 	}
 	return true
 }
 
 type option struct {
-	Identifier int64
-	Data       string
-	Signal     int
+	Identifier  int64
+	Name        string
+	Source      int64
+	Data        string
+	Signal      int
+	ContentType string
+	Priority    int
+	Key         string
+	DeliverAt   time.Time
+	Deadline    time.Time
+	Context     context.Context
+	Metadata    map[string]string
+	Trace       bool
 }
 
 type sendOption func(*option)
@@ -153,8 +282,29 @@ func WithSignal(signal int) sendOption {
 	}
 }
 
+// WithDelay deliver the message after the delay
+func WithDelay(delay time.Duration) sendOption {
+	return func(o *option) {
+		o.DeliverAt = now().Add(delay)
+	}
+}
+
+// WithDeliverAt deliver the message at the given moment
+func WithDeliverAt(t time.Time) sendOption {
+	return func(o *option) {
+		o.DeliverAt = t
+	}
+}
+
 // SendMessage send message to core
 func SendMessage(opts ...sendOption) bool {
+	_, ok := SendMessageId(opts...)
+	return ok
+}
+
+// SendMessageId send message to core and return the message id
+// the id can be used to cancel the delayed message with CancelMessage
+func SendMessageId(opts ...sendOption) (int64, bool) {
 
 	opt := &option{
 		Identifier: 0,
@@ -165,101 +315,199 @@ func SendMessage(opts ...sendOption) bool {
 	for _, o := range opts {
 		o(opt)
 	}
+	if opt.Name != "" {
+		id, ok := Resolve(opt.Name)
+		if !ok {
+			Logger.Info(fmt.Sprintf("Please install plugin to deal with the message with name: %s", opt.Name))
+			return 0, false
+		}
+		opt.Identifier = id
+	}
 
-	if _, ok := modules.Load(opt.Identifier); !ok {
+	message := Message{
+		Id:          nextId(),
+		Signal:      opt.Signal,
+		Identifier:  opt.Identifier,
+		Source:      opt.Source,
+		Data:        opt.Data,
+		ContentType: opt.ContentType,
+		Priority:    opt.Priority,
+		Key:         opt.Key,
+	}
+	propagate(&message, opt)
+
+	v, ok := modules.Load(opt.Identifier)
+	if !ok {
 		Logger.Info(fmt.Sprintf("Please install plugin to deal with the message with identifier: %d", opt.Identifier))
-		return false
+		atomic.AddUint64(&metricsOf(opt.Identifier).dropped, 1)
+		bury(message, ErrNotInstalled)
+		return 0, false
+	}
+
+	if message.Expired(now()) {
+		atomic.AddUint64(&metricsOf(opt.Identifier).expired, 1)
+		settle(message, ErrExpired)
+		return 0, false
+	}
+
+	if !v.(*module).accept(&message) {
+		Logger.Info(fmt.Sprintf("Module: %d does not accept content type: [%s]", opt.Identifier, opt.ContentType))
+		atomic.AddUint64(&metricsOf(opt.Identifier).dropped, 1)
+		bury(message, ErrNotAccepted)
+		return 0, false
+	}
+
+	if d, e := throttle(&message, v.(*module)); e != nil {
+		settle(message, e)
+		return 0, false
+	} else if at := now().Add(d); d > 0 && opt.DeliverAt.Before(at) {
+		opt.DeliverAt = at
+	}
+
+	e := intercept(StageSend, &message, func(m *Message) error {
+		if opt.DeliverAt.After(now()) {
+			if !delays.add(opt.DeliverAt, *m) {
+				return ErrShutdown
+			}
+			return nil
+		}
+		if !postMessage(*m) {
+			return ErrShutdown
+		}
+		return nil
+	})
+	if !settle(message, e) {
+		return 0, false
 	}
+	return message.Id, true
+}
 
+// postMessage put message into kernel, false when kernel shutdown
+func postMessage(message Message) (ok bool) {
 	defer func() {
 		if r := recover(); r != nil {
-
+			// inbox closed by Shutdown
+			kills.take(message)
+			atomic.AddInt64(&routing, -1)
+			ok = false
 		}
 	}()
 
-	inMessage <- Message{
-		Signal:     opt.Signal,
-		Identifier: opt.Identifier,
-		Data:       opt.Data,
-	}
-
+	atomic.AddInt64(&routing, 1)
+	kills.post(&message)
+	inbox.put(message)
 	return true
 }
 
+// settle deal with the result of the intercepted message, false when message discarded
+// message delayed by interceptor is put into the delay queue, discarded one becomes dead letter
+func settle(message Message, e error) bool {
+	if e == nil {
+		return true
+	}
+	if d, ok := e.(*DelayError); ok {
+		if delays.add(now().Add(d.After), message) {
+			return true
+		}
+		e = ErrShutdown
+	}
+	// Message discard immediately
+	Logger.Info(fmt.Sprintf("Message from: %d value:[%s] discarded: %v", message.Identifier, message.Data, e))
+	atomic.AddUint64(&metricsOf(message.Identifier).dropped, 1)
+	bury(message, e)
+	return false
+}
+
 // deliverMessage Deliver message into different message queue
 func deliverMessage(message Message) bool {
-	if queue, exists := modules.Load(message.Identifier); exists {
-		defer func() {
-			// IsExitSignal will uninstall module when meeting the SignalKill
-			if IsExitSignal(&message) {
-				UninstallModule(message.Identifier)
-			}
-		}()
-		queue.(chan Message) <- Message{
-			Id:         increaseId(),
-			Identifier: message.Identifier,
-			Data:       message.Data,
-			Signal:     message.Signal,
+	if message.Id == 0 {
+		message.Id = nextId()
+	}
+	// expired messages are discarded before delivered
+	if message.Expired(now()) {
+		atomic.AddUint64(&metricsOf(message.Identifier).expired, 1)
+		return settle(message, ErrExpired)
+	}
+	return settle(message, intercept(StageDeliver, &message, enqueue))
+}
+
+// enqueue put message into the queue of the module, buffered when the module paused
+func enqueue(message *Message) error {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	if pauses.hold(message) {
+		return nil
+	}
+	return route(message)
+}
+
+// route put message into the queue of the module or its transport
+func route(message *Message) error {
+	v, exists := modules.Load(message.Identifier)
+	if !exists {
+		return ErrNotInstalled
+	}
+	if mod := v.(*module); mod.remote != nil {
+		if e := mod.remote.Send(*message); e != nil {
+			return e
 		}
-		return true
 	} else {
-		// Message discard immediately
-		Logger.Info(fmt.Sprintf("Message from: %d value:[%s] discarded", message.Identifier, message.Data))
-		return false
+		mod.put(*message)
 	}
+	atomic.AddUint64(&metricsOf(message.Identifier).delivered, 1)
+
+	// IsExitSignal will uninstall module when meeting the SignalKill
+	if IsExitSignal(message) {
+		UninstallModule(message.Identifier)
+	}
+	return nil
 }
 
 // startInMessage this function will run forever
 func startInMessage(w *sync.WaitGroup) {
 	for {
-		select {
-		case message, ok := <-inMessage:
-			if ok {
-				deliverMessage(message)
-			} else {
-				w.Done()
-				return
-			}
-		case <-time.NewTimer(time.Second).C:
-			for _, t := range runner.runner {
-				t()
-			}
+		message, ok := inbox.get()
+		if !ok {
+			w.Done()
+			return
 		}
+		routeInbox(message)
 	}
 }
 
+// routeInbox deliver the message taken out of inbox, an exit signal is held
+// until the messages sent to the module before it are delivered
+func routeInbox(message Message) {
+	for _, m := range kills.take(message) {
+		deliverMessage(m)
+	}
+	atomic.AddInt64(&routing, -1)
+}
+
 // Shutdown the core kernel
 // very dangerous, when called, all goroutine will exit
+// delayed messages not yet delivered are discarded as dead letters
 func Shutdown() {
-	close(inMessage)
+	for _, m := range delays.close() {
+		Logger.Info(fmt.Sprintf("Kernel shutdown, delayed message[id: %d] to: %d discarded", m.Id, m.Identifier))
+		atomic.AddUint64(&metricsOf(m.Identifier).dropped, 1)
+		bury(m, ErrShutdown)
+	}
+	inbox.close()
 }
 
 // AppendToSecondTask Append task to kernel 1s task
+// The returned value is the TaskId of the task, it keeps valid after other tasks removed
 func AppendToSecondTask(_t func()) int {
-	runner.m.Lock()
-	defer runner.m.Unlock()
-
-	runner.runner = append(runner.runner, _t)
-	return len(runner.runner) - 1
+	return int(ScheduleTask(Every(time.Second), func(context.Context) {
+		_t()
+	}))
 }
 
 // RemoveSecondTask Remove task from kernel 1s task
 func RemoveSecondTask(i int) bool {
-	if len(runner.runner) <= i {
-		return false
-	}
-	runner.m.Lock()
-	defer runner.m.Unlock()
-
-	var _v []func()
-	for _i, t := range runner.runner {
-		if _i == i {
-			continue
-		}
-		_v = append(_v, t)
-	}
-	runner.runner = _v
-	return true
+	return CancelTask(TaskId(i))
 }
 
 // Start the engine core
@@ -267,8 +515,13 @@ func Start() {
 	defer func() {
 		_ = Logger.Sync()
 	}()
+	stop := make(chan struct{})
+	go sched.run(stop)
+	go delays.run(stop)
+
 	wait := sync.WaitGroup{}
 	wait.Add(1)
 	go startInMessage(&wait)
 	wait.Wait()
+	close(stop)
 }
@@ -0,0 +1,238 @@
+// Package coretest drive the dtx kernel deterministically in tests
+// messages are routed and dealt on the test goroutine and time only moves when advanced
+package coretest
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	core "devtools/dtx"
+)
+
+// maxSteps steps Run takes before failing the test, guards against message loops
+const maxSteps = 100000
+
+// Clock virtual clock, time only moves when advanced
+type Clock struct {
+	m   sync.Mutex
+	now time.Time
+}
+
+// NewClock clock starting at start
+func NewClock(start time.Time) *Clock {
+	return &Clock{now: start}
+}
+
+// Now current virtual time, implements core.Clock
+func (c *Clock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+// Advance move the clock forward by d and return the new time
+func (c *Clock) Advance(d time.Duration) time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+// Set move the clock to t, the clock never goes backward
+func (c *Clock) Set(t time.Time) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if t.After(c.now) {
+		c.now = t
+	}
+}
+
+// inline module whose handler is called on the test goroutine
+type inline struct {
+	id      int64
+	queue   chan core.Message
+	handler core.Handler
+}
+
+// Harness step-driven kernel, the kernel must not be started by core.Start
+// the kernel is reset by core.Reset when the harness created and again when the test ends,
+// so the modules, messages, tasks, interceptors, dead letters and metrics are never shared between tests
+type Harness struct {
+	tb    testing.TB
+	Clock *Clock
+
+	handlers []*inline
+	captures []*Capture
+}
+
+// New harness with a virtual clock starting at 2000-01-01 UTC
+func New(tb testing.TB) *Harness {
+	h := &Harness{
+		tb:    tb,
+		Clock: NewClock(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)),
+	}
+	core.Reset()
+	core.SetClock(h.Clock)
+	tb.Cleanup(core.Reset)
+	return h
+}
+
+// install module with queue, fail the test when already installed
+func (h *Harness) install(id int64, size int) chan core.Message {
+	h.tb.Helper()
+	queue := make(chan core.Message, size)
+	if !core.InstallModule(id, queue) {
+		h.tb.Fatalf("coretest: module %d already installed", id)
+	}
+	return queue
+}
+
+// Install module whose handler is called synchronously by Step
+func (h *Harness) Install(id int64, handler core.Handler) {
+	h.tb.Helper()
+	h.handlers = append(h.handlers, &inline{id: id, queue: h.install(id, 10000), handler: handler})
+}
+
+// Capture install module recording the messages delivered to id
+func (h *Harness) Capture(id int64) *Capture {
+	h.tb.Helper()
+	c := &Capture{id: id, queue: h.install(id, 10000)}
+	h.captures = append(h.captures, c)
+	return c
+}
+
+// Step route one message waiting in kernel and deal with the messages delivered
+// to the installed handlers and captures, false when nothing happened
+func (h *Harness) Step() bool {
+	stepped := core.Poll()
+	for _, in := range h.handlers {
+		for len(in.queue) > 0 {
+			in.handler(<-in.queue)
+			stepped = true
+		}
+	}
+	for _, c := range h.captures {
+		if c.collect() {
+			stepped = true
+		}
+	}
+	return stepped
+}
+
+// Run step until the kernel is idle and return the number of steps,
+// the test fails when the messages never settle
+func (h *Harness) Run() int {
+	h.tb.Helper()
+	n := 0
+	for h.Step() {
+		if n++; n > maxSteps {
+			h.tb.Fatalf("coretest: kernel not idle after %d steps", maxSteps)
+		}
+	}
+	return n
+}
+
+// Advance move the virtual time forward by d, firing the delayed messages and
+// tasks at each time they are due, and run the kernel until idle after each firing
+func (h *Harness) Advance(d time.Duration) {
+	h.tb.Helper()
+	target := h.Clock.Now().Add(d)
+	for {
+		next, ok := core.NextDue()
+		if !ok || next.After(target) {
+			break
+		}
+		h.Clock.Set(next)
+		core.Fire()
+		h.Run()
+	}
+	h.Clock.Set(target)
+	core.Fire()
+	h.Run()
+}
+
+// Capture messages delivered to a module installed by Harness.Capture
+type Capture struct {
+	id       int64
+	queue    chan core.Message
+	m        sync.Mutex
+	messages []core.Message
+}
+
+// collect move the delivered messages from queue, false when none
+func (c *Capture) collect() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	collected := false
+	for {
+		select {
+		case m := <-c.queue:
+			c.messages = append(c.messages, m)
+			collected = true
+		default:
+			return collected
+		}
+	}
+}
+
+// Messages copy of the messages captured in order
+func (c *Capture) Messages() []core.Message {
+	c.collect()
+	c.m.Lock()
+	defer c.m.Unlock()
+	return append([]core.Message(nil), c.messages...)
+}
+
+// Len number of the messages captured
+func (c *Capture) Len() int {
+	c.collect()
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.messages)
+}
+
+// Last the latest message captured, false when none
+func (c *Capture) Last() (core.Message, bool) {
+	c.collect()
+	c.m.Lock()
+	defer c.m.Unlock()
+	if len(c.messages) == 0 {
+		return core.Message{}, false
+	}
+	return c.messages[len(c.messages)-1], true
+}
+
+// Reset forget the messages captured
+func (c *Capture) Reset() {
+	c.collect()
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.messages = nil
+}
+
+// AssertCount fail the test unless n messages captured
+func (c *Capture) AssertCount(tb testing.TB, n int) {
+	tb.Helper()
+	if got := c.Len(); got != n {
+		tb.Errorf("coretest: module %d captured %d messages, want %d", c.id, got, n)
+	}
+}
+
+// AssertData fail the test unless the data of the messages captured equal data in order
+func (c *Capture) AssertData(tb testing.TB, data ...string) {
+	tb.Helper()
+	messages := c.Messages()
+	got := make([]string, len(messages))
+	for i, m := range messages {
+		got[i] = m.Data
+	}
+	equal := len(got) == len(data)
+	for i := 0; equal && i < len(got); i++ {
+		equal = got[i] == data[i]
+	}
+	if !equal {
+		tb.Errorf("coretest: module %d captured data %q, want %q", c.id, got, data)
+	}
+}
@@ -0,0 +1,132 @@
+package coretest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	core "devtools/dtx"
+)
+
+func TestHarnessHandler(t *testing.T) {
+	h := New(t)
+	h.Install(1, func(m core.Message) {
+		// the deadline is measured by the virtual clock
+		if err := m.Context().Err(); err != nil {
+			t.Errorf("message %q arrived with %v", m.Data, err)
+		}
+		core.SendMessage(core.WithIdInt64(2), core.WithData(m.Data+"!"))
+	})
+	c := h.Capture(2)
+
+	core.SendMessage(core.WithIdInt64(1), core.WithData("a"))
+	core.SendMessage(core.WithIdInt64(1), core.WithData("b"), core.WithTTL(time.Minute))
+	h.Run()
+	c.AssertData(t, "a!", "b!")
+}
+
+func TestHarnessAdvance(t *testing.T) {
+	h := New(t)
+	c := h.Capture(1)
+	var runs int
+	core.ScheduleTask(core.Every(10*time.Second), func(context.Context) {
+		runs++
+	})
+
+	tests := []struct {
+		data    string
+		send    func(data string) bool
+		arrives bool
+	}{
+		{"now", func(data string) bool {
+			return core.SendMessage(core.WithIdInt64(1), core.WithData(data))
+		}, true},
+		{"in time", func(data string) bool {
+			return core.SendMessage(core.WithIdInt64(1), core.WithData(data), core.WithDelay(10*time.Second), core.WithTTL(20*time.Second))
+		}, true},
+		{"later", func(data string) bool {
+			return core.SendMessage(core.WithIdInt64(1), core.WithData(data), core.WithDelay(30*time.Second))
+		}, true},
+		// due after its deadline
+		{"expired", func(data string) bool {
+			return core.SendMessage(core.WithIdInt64(1), core.WithData(data), core.WithDelay(30*time.Second), core.WithTTL(20*time.Second))
+		}, false},
+	}
+	var want []string
+	for _, tt := range tests {
+		if !tt.send(tt.data) {
+			t.Fatalf("send %q failed", tt.data)
+		}
+		if tt.arrives {
+			want = append(want, tt.data)
+		}
+	}
+	h.Run()
+	c.AssertData(t, "now")
+
+	h.Advance(35 * time.Second)
+	c.AssertData(t, want...)
+	if runs != 3 {
+		t.Errorf("task ran %d times in 35s, want 3", runs)
+	}
+	letters, _ := core.DeadLetters(core.DeadLetterQuery{Reasons: []core.Reason{core.ReasonExpired}})
+	if len(letters) != 1 || letters[0].Message.Data != "expired" {
+		t.Errorf("expired dead letters %+v", letters)
+	}
+}
+
+func TestHarnessReset(t *testing.T) {
+	t.Run("dirty", func(t *testing.T) {
+		h := New(t)
+		h.Capture(1)
+		core.ScheduleTask(core.Every(time.Second), func(context.Context) {})
+		core.UseInterceptor(func(_ core.Stage, m *core.Message, next core.Invoker) error {
+			return core.ErrRejected
+		})
+		core.SendMessage(core.WithIdInt64(1), core.WithDelay(time.Hour))
+		core.SendMessage(core.WithIdInt64(2))
+		core.Pause(1)
+		h.Run()
+	})
+	t.Run("clean", func(t *testing.T) {
+		h := New(t)
+		if at, ok := core.NextDue(); ok {
+			t.Errorf("delayed message or task of the other test due at %s", at)
+		}
+		if s := core.Stats(); len(s.Modules) != 0 || len(s.Tasks) != 0 || s.InboxDepth != 0 || s.Unknown.Dropped != 0 {
+			t.Errorf("stats of the other test %+v", s)
+		}
+		if letters, _ := core.DeadLetters(core.DeadLetterQuery{}); len(letters) != 0 {
+			t.Errorf("dead letters of the other test %+v", letters)
+		}
+		if _, ok := core.Paused(1); ok {
+			t.Error("module paused by the other test")
+		}
+		c := h.Capture(1)
+		core.SendMessage(core.WithIdInt64(1), core.WithData("a"))
+		h.Advance(2 * time.Hour)
+		c.AssertData(t, "a")
+	})
+}
+
+func TestHarnessIds(t *testing.T) {
+	h := New(t)
+	c := h.Capture(1)
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	h.Clock.Set(start)
+
+	core.SendMessage(core.WithIdInt64(1))
+	h.Advance(time.Hour)
+	core.SendMessage(core.WithIdInt64(1))
+	h.Run()
+
+	messages := c.Messages()
+	if len(messages) != 2 {
+		t.Fatalf("%d messages, want 2", len(messages))
+	}
+	for i, want := range []time.Time{start, start.Add(time.Hour)} {
+		if at, _, _ := core.ParseSnowflake(messages[i].Id); !at.Equal(want) {
+			t.Errorf("id %d generated at %s, want the virtual time %s", i, at, want)
+		}
+	}
+}
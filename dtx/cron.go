@@ -0,0 +1,176 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule standard 5 fields cron expression: minute hour day-of-month month day-of-week
+// each field is a bit set of the allowed values
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// domStar, dowStar whether day-of-month or day-of-week is '*'
+	domStar, dowStar bool
+}
+
+// cronField the bounds of each cron field
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var (
+	cronFields = []cronField{
+		{"minute", 0, 59},
+		{"hour", 0, 23},
+		{"day-of-month", 1, 31},
+		{"month", 1, 12},
+		{"day-of-week", 0, 6},
+	}
+
+	cronDescriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// Cron parse the cron expression, the descriptors like @hourly and @every 5s are supported
+// Times are computed in the location of the time passed to Next
+func Cron(expr string) (Schedule, error) {
+	expr = strings.TrimSpace(expr)
+	if strings.HasPrefix(expr, "@every ") {
+		d, e := time.ParseDuration(strings.TrimSpace(expr[len("@every "):]))
+		if e != nil {
+			return nil, fmt.Errorf("cron: invalid @every duration: %v", e)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("cron: @every duration should be positive")
+		}
+		return Every(d), nil
+	}
+	if v, ok := cronDescriptors[expr]; ok {
+		expr = v
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron: expected %d fields, found %d: %s", len(cronFields), len(fields), expr)
+	}
+
+	var bits [5]uint64
+	for i, f := range fields {
+		b, e := parseCronField(f, cronFields[i])
+		if e != nil {
+			return nil, e
+		}
+		bits[i] = b
+	}
+	// Sunday can be written as 7
+	if bits[4]&(1<<7) != 0 {
+		bits[4] = bits[4]&^(1<<7) | 1
+	}
+
+	return &cronSchedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*" || fields[2] == "?",
+		dowStar: fields[4] == "*" || fields[4] == "?",
+	}, nil
+}
+
+// parseCronField parse one field: '*', 'a', 'a-b', '*/n', 'a-b/n' and comma separated lists
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	max := f.max
+	if f.name == "day-of-week" {
+		max = 7
+	}
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, e := strconv.Atoi(part[i+1:])
+			if e != nil || s <= 0 {
+				return 0, fmt.Errorf("cron: invalid step in %s field: %s", f.name, part)
+			}
+			step = s
+			part = part[:i]
+		}
+
+		low, high := f.min, f.max
+		switch {
+		case part == "*" || part == "?":
+		case strings.Contains(part, "-"):
+			r := strings.SplitN(part, "-", 2)
+			l, e1 := strconv.Atoi(r[0])
+			h, e2 := strconv.Atoi(r[1])
+			if e1 != nil || e2 != nil {
+				return 0, fmt.Errorf("cron: invalid range in %s field: %s", f.name, part)
+			}
+			low, high = l, h
+		default:
+			v, e := strconv.Atoi(part)
+			if e != nil {
+				return 0, fmt.Errorf("cron: invalid value in %s field: %s", f.name, part)
+			}
+			low, high = v, v
+			if step > 1 {
+				high = f.max
+			}
+		}
+
+		if low < f.min || high > max || low > high {
+			return 0, fmt.Errorf("cron: %s field out of range [%d, %d]: %s", f.name, f.min, f.max, part)
+		}
+		for v := low; v <= high; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// dayMatches day-of-month and day-of-week are OR'ed when both of them restricted
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next the first matched minute after t, zero time if nothing matched in 5 years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse("2006-01-02 15:04:05", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	// 2024-01-01 is a Monday
+	tests := []struct {
+		expr, from, want string
+	}{
+		{"* * * * *", "2024-01-01 10:07:30", "2024-01-01 10:08:00"},
+		{"*/15 * * * *", "2024-01-01 10:07:30", "2024-01-01 10:15:00"},
+		{"5,10 * * * *", "2024-01-01 10:07:00", "2024-01-01 10:10:00"},
+		{"0 9-17/4 * * *", "2024-01-01 10:00:00", "2024-01-01 13:00:00"},
+		{"10/20 * * * *", "2024-01-01 10:31:00", "2024-01-01 10:50:00"},
+		{"30 2 1-3 * *", "2024-01-03 03:00:00", "2024-02-01 02:30:00"},
+		{"0 0 1 */3 *", "2024-01-01 00:00:00", "2024-04-01 00:00:00"},
+		// Sunday as 0 and as 7
+		{"0 0 * * 0", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 7", "2024-01-01 00:00:00", "2024-01-07 00:00:00"},
+		{"0 0 * * 5-7", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		// day-of-month or day-of-week when both restricted
+		{"0 0 13 * 5", "2024-01-01 00:00:00", "2024-01-05 00:00:00"},
+		{"0 0 13 * 5", "2024-01-12 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 13 * *", "2024-01-01 00:00:00", "2024-01-13 00:00:00"},
+		{"0 0 * * 5", "2024-01-05 00:00:00", "2024-01-12 00:00:00"},
+		// Feb 29 only in leap years
+		{"0 0 29 2 *", "2023-03-01 00:00:00", "2024-02-29 00:00:00"},
+		{"0 12 29 2 *", "2024-02-29 12:00:00", "2028-02-29 12:00:00"},
+		{"0 0 31 * *", "2024-04-01 00:00:00", "2024-05-31 00:00:00"},
+		{"@hourly", "2024-01-01 10:07:00", "2024-01-01 11:00:00"},
+		{"@yearly", "2024-01-01 00:00:00", "2025-01-01 00:00:00"},
+	}
+	for _, tt := range tests {
+		s, err := Cron(tt.expr)
+		if err != nil {
+			t.Errorf("Cron(%q): %v", tt.expr, err)
+			continue
+		}
+		if got := s.Next(at(tt.from)); !got.Equal(at(tt.want)) {
+			t.Errorf("Cron(%q).Next(%s) = %s, want %s", tt.expr, tt.from, got, tt.want)
+		}
+	}
+}
+
+func TestCronNeverMatched(t *testing.T) {
+	s, err := Cron("0 0 30 2 *")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+		t.Errorf("Feb 30 matched at %s", got)
+	}
+}
+
+func TestCronEvery(t *testing.T) {
+	s, err := Cron("@every 5s")
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	if got := s.Next(from); !got.Equal(from.Add(5 * time.Second)) {
+		t.Errorf("@every 5s: %s", got)
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"a * * * *",
+		"1-a * * * *",
+		"@every -1s",
+		"@every x",
+	} {
+		if _, err := Cron(expr); err == nil {
+			t.Errorf("Cron(%q) accepted", expr)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package core
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Reason why the message became dead letter
+type Reason string
+
+const (
+	// ReasonNotInstalled no module installed with the message identifier
+	ReasonNotInstalled Reason = "not_installed"
+	// ReasonExpired message deadline passed before delivered
+	ReasonExpired Reason = "expired"
+	// ReasonRejected message rejected by interceptor
+	ReasonRejected Reason = "rejected"
+	// ReasonNotAccepted module does not accept the content type of message
+	ReasonNotAccepted Reason = "not_accepted"
+	// ReasonFailed handler of the module panicked on the message
+	ReasonFailed Reason = "failed"
+	// ReasonThrottled message rejected by the rate limit or quota
+	ReasonThrottled Reason = "throttled"
+	// ReasonShutdown kernel shutdown before the message delivered
+	ReasonShutdown Reason = "shutdown"
+	// ReasonUndeliverable other errors, such as the transport failed
+	ReasonUndeliverable Reason = "undeliverable"
+)
+
+// MetadataRedrives metadata key of the times the message was redriven
+const MetadataRedrives = "dtx-redrives"
+
+var (
+	// ErrNotAccepted module does not accept the content type of message
+	ErrNotAccepted = errors.New("core: content type not accepted")
+	// ErrHandlerPanic handler of the module panicked on the message
+	ErrHandlerPanic = errors.New("core: handler panicked")
+)
+
+// reasonOf reason code of the error message discarded with
+func reasonOf(e error) Reason {
+	switch {
+	case errors.Is(e, ErrNotInstalled):
+		return ReasonNotInstalled
+	case errors.Is(e, ErrExpired):
+		return ReasonExpired
+	case errors.Is(e, ErrRejected):
+		return ReasonRejected
+	case errors.Is(e, ErrNotAccepted):
+		return ReasonNotAccepted
+	case errors.Is(e, ErrHandlerPanic):
+		return ReasonFailed
+	case errors.Is(e, ErrThrottled):
+		return ReasonThrottled
+	case errors.Is(e, ErrShutdown):
+		return ReasonShutdown
+	}
+	return ReasonUndeliverable
+}
+
+// DeadLetter message the kernel could not deliver
+type DeadLetter struct {
+	// Id assigned by the store
+	Id      int64     `json:"id"`
+	Message Message   `json:"message"`
+	Reason  Reason    `json:"reason"`
+	Error   string    `json:"error"`
+	Time    time.Time `json:"time"`
+	// Attempts times the message was redriven before
+	Attempts int `json:"attempts"`
+}
+
+// DeadLetterQuery filter of the dead letters, zero fields match all
+type DeadLetterQuery struct {
+	Identifiers []int64
+	Reasons     []Reason
+	Since       time.Time
+	Until       time.Time
+	// Limit max dead letters returned, oldest first
+	Limit int
+}
+
+// match whether the dead letter satisfies the query
+func (q *DeadLetterQuery) match(d *DeadLetter) bool {
+	if len(q.Identifiers) > 0 {
+		found := false
+		for _, id := range q.Identifiers {
+			if id == d.Message.Identifier {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if len(q.Reasons) > 0 {
+		found := false
+		for _, r := range q.Reasons {
+			if r == d.Reason {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	if !q.Since.IsZero() && d.Time.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !d.Time.Before(q.Until) {
+		return false
+	}
+	return true
+}
+
+// DeadLetterStore keeps the dead letters, Put is called on the kernel goroutines
+// and should not block long
+type DeadLetterStore interface {
+	// Put store the dead letter and assign its Id
+	Put(d *DeadLetter) error
+	// Query dead letters matching q, oldest first
+	Query(q DeadLetterQuery) ([]DeadLetter, error)
+	// Remove the dead letters with ids
+	Remove(ids ...int64) error
+}
+
+// MemoryStore dead letters in memory, the oldest are dropped when full
+type MemoryStore struct {
+	m        sync.RWMutex
+	capacity int
+	seq      int64
+	// letters ring of the dead letters when capacity reached, oldest at head
+	letters []DeadLetter
+	head    int
+}
+
+// NewMemoryStore store keeping at most capacity dead letters, unlimited when capacity <= 0
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{capacity: capacity}
+}
+
+func (s *MemoryStore) Put(d *DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.seq++
+	d.Id = s.seq
+	if s.capacity > 0 && len(s.letters) >= s.capacity {
+		// overwrite the oldest
+		s.letters[s.head] = *d
+		s.head = (s.head + 1) % len(s.letters)
+		return nil
+	}
+	s.letters = append(s.letters, *d)
+	return nil
+}
+
+// at the i-th oldest dead letter, lock held by caller
+func (s *MemoryStore) at(i int) *DeadLetter {
+	return &s.letters[(s.head+i)%len(s.letters)]
+}
+
+func (s *MemoryStore) Query(q DeadLetterQuery) ([]DeadLetter, error) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	var r []DeadLetter
+	for i := range s.letters {
+		if q.Limit > 0 && len(r) >= q.Limit {
+			break
+		}
+		if d := s.at(i); q.match(d) {
+			r = append(r, *d)
+		}
+	}
+	return r, nil
+}
+
+func (s *MemoryStore) Remove(ids ...int64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.remove(ids)
+	return nil
+}
+
+// remove the dead letters with ids, lock held by caller
+func (s *MemoryStore) remove(ids []int64) {
+	s.letters, s.head = s.kept(ids), 0
+}
+
+// kept the dead letters without ids, oldest first, lock held by caller
+func (s *MemoryStore) kept(ids []int64) []DeadLetter {
+	drop := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		drop[id] = true
+	}
+	kept := make([]DeadLetter, 0, len(s.letters))
+	for i := range s.letters {
+		if d := s.at(i); !drop[d.Id] {
+			kept = append(kept, *d)
+		}
+	}
+	return kept
+}
+
+// DiskStore dead letters appended to a JSON lines file, survive the process restarts
+// all dead letters are also kept in memory for querying
+type DiskStore struct {
+	MemoryStore
+	path string
+	file *os.File
+}
+
+// OpenDiskStore open the store on file path, the dead letters already in it are loaded
+func OpenDiskStore(path string) (*DiskStore, error) {
+	s := &DiskStore{path: path}
+	if f, e := os.Open(path); e == nil {
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+		for scanner.Scan() {
+			var d DeadLetter
+			if e = json.Unmarshal(scanner.Bytes(), &d); e != nil {
+				_ = f.Close()
+				return nil, fmt.Errorf("core: corrupted dead letter file %s: %v", path, e)
+			}
+			s.letters = append(s.letters, d)
+			if d.Id > s.seq {
+				s.seq = d.Id
+			}
+		}
+		_ = f.Close()
+		if e = scanner.Err(); e != nil {
+			return nil, e
+		}
+	} else if !os.IsNotExist(e) {
+		return nil, e
+	}
+
+	f, e := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if e != nil {
+		return nil, e
+	}
+	s.file = f
+	return s, nil
+}
+
+func (s *DiskStore) Put(d *DeadLetter) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.seq++
+	d.Id = s.seq
+	line, e := json.Marshal(d)
+	if e != nil {
+		return e
+	}
+	if _, e = s.file.Write(append(line, '\n')); e != nil {
+		return e
+	}
+	s.letters = append(s.letters, *d)
+	return nil
+}
+
+// Remove the dead letters and rewrite the file with the remaining ones
+// the dead letters in memory are kept when the file can not be rewritten
+func (s *DiskStore) Remove(ids ...int64) error {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	kept := s.kept(ids)
+	tmp := s.path + ".tmp"
+	f, e := os.Create(tmp)
+	if e != nil {
+		return e
+	}
+	w := bufio.NewWriter(f)
+	for i := range kept {
+		line, _ := json.Marshal(&kept[i])
+		_, _ = w.Write(append(line, '\n'))
+	}
+	if e = w.Flush(); e != nil {
+		_ = f.Close()
+		_ = os.Remove(tmp)
+		return e
+	}
+	if e = f.Close(); e != nil {
+		_ = os.Remove(tmp)
+		return e
+	}
+	if e = os.Rename(tmp, s.path); e != nil {
+		_ = os.Remove(tmp)
+		return e
+	}
+	s.letters, s.head = kept, 0
+
+	_ = s.file.Close()
+	s.file, e = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0644)
+	return e
+}
+
+// Close the file of store
+func (s *DiskStore) Close() error {
+	s.m.Lock()
+	defer s.m.Unlock()
+	return s.file.Close()
+}
+
+// deadLetters store of the kernel, nil discards the dead letters
+var deadLetters = struct {
+	m     sync.RWMutex
+	store DeadLetterStore
+}{store: NewMemoryStore(1000)}
+
+// SetDeadLetterStore replace the dead letter store, nil stops keeping dead letters
+// an in-memory store of 1000 dead letters is used by default
+func SetDeadLetterStore(store DeadLetterStore) {
+	deadLetters.m.Lock()
+	deadLetters.store = store
+	deadLetters.m.Unlock()
+}
+
+// deadLetterStore the current store of the kernel
+func deadLetterStore() DeadLetterStore {
+	deadLetters.m.RLock()
+	defer deadLetters.m.RUnlock()
+	return deadLetters.store
+}
+
+// bury keep the message discarded with e as dead letter
+func bury(message Message, e error) {
+	store := deadLetterStore()
+	if store == nil {
+		return
+	}
+	attempts, _ := strconv.Atoi(message.Metadata[MetadataRedrives])
+	message.ctx = nil
+	d := &DeadLetter{
+		Message:  message,
+		Reason:   reasonOf(e),
+		Error:    e.Error(),
+		Time:     now(),
+		Attempts: attempts,
+	}
+	if e := store.Put(d); e != nil {
+		Logger.Error(fmt.Sprintf("Dead letter of message[id: %d] to: %d lost: %v", message.Id, message.Identifier, e))
+	}
+}
+
+// DeadLetters query the dead letters kept by kernel
+func DeadLetters(q DeadLetterQuery) ([]DeadLetter, error) {
+	store := deadLetterStore()
+	if store == nil {
+		return nil, nil
+	}
+	return store.Query(q)
+}
+
+// Redrive re-inject the dead letters matching q into kernel and remove them from store,
+// dead letters whose module is still not installed are kept
+// the deadline of the redriven messages is cleared, return the number of messages redriven
+func Redrive(q DeadLetterQuery) (int, error) {
+	store := deadLetterStore()
+	if store == nil {
+		return 0, nil
+	}
+	letters, e := store.Query(q)
+	if e != nil {
+		return 0, e
+	}
+
+	var done []int64
+	for _, d := range letters {
+		if _, ok := modules.Load(d.Message.Identifier); !ok {
+			continue
+		}
+		m := d.Message
+		m.Deadline = time.Time{}
+		m.Metadata = make(map[string]string, len(d.Message.Metadata)+1)
+		for k, v := range d.Message.Metadata {
+			m.Metadata[k] = v
+		}
+		m.Metadata[MetadataRedrives] = strconv.Itoa(d.Attempts + 1)
+		if !postMessage(m) {
+			e = ErrShutdown
+			break
+		}
+		done = append(done, d.Id)
+	}
+	if len(done) > 0 {
+		if re := store.Remove(done...); re != nil && e == nil {
+			e = re
+		}
+	}
+	return len(done), e
+}
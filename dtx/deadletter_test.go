@@ -0,0 +1,72 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func letterIds(t *testing.T, s DeadLetterStore) []int64 {
+	t.Helper()
+	letters, err := s.Query(DeadLetterQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []int64
+	for _, d := range letters {
+		ids = append(ids, d.Id)
+	}
+	return ids
+}
+
+func TestDiskStoreRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		// broken the file can not be rewritten
+		broken bool
+		want   []int64
+	}{
+		{"removed", false, []int64{1, 3}},
+		{"rewrite failed", true, []int64{1, 2, 3}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "dead.jsonl")
+			s, err := OpenDiskStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, data := range []string{"a", "b", "c"} {
+				if err = s.Put(&DeadLetter{Message: Message{Data: data}, Reason: ReasonExpired}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.broken {
+				// the temporary file taken by a directory
+				if err = os.Mkdir(path+".tmp", 0755); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if err = s.Remove(2); (err != nil) != tt.broken {
+				t.Errorf("Remove error %v", err)
+			}
+			if got := letterIds(t, s); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("in memory %v, want %v", got, tt.want)
+			}
+			// the file agrees with the memory
+			if err = s.Close(); err != nil {
+				t.Fatal(err)
+			}
+			reopened, err := OpenDiskStore(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer reopened.Close()
+			if got := letterIds(t, reopened); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("in file %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
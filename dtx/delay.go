@@ -0,0 +1,198 @@
+package core
+
+import (
+	"container/heap"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// delayed inner struct of a message waiting for its delivery time
+type delayed struct {
+	at      time.Time
+	message Message
+	index   int
+}
+
+// delayHeap delayed messages ordered by the delivery time
+type delayHeap []*delayed
+
+func (h delayHeap) Len() int { return len(h) }
+
+func (h delayHeap) Less(i, j int) bool { return h[i].at.Before(h[j].at) }
+
+func (h delayHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *delayHeap) Push(x interface{}) {
+	d := x.(*delayed)
+	d.index = len(*h)
+	*h = append(*h, d)
+}
+
+func (h *delayHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	d := old[n-1]
+	old[n-1] = nil
+	d.index = -1
+	*h = old[:n-1]
+	return d
+}
+
+// delayQueue messages sent WithDelay or WithDeliverAt
+type delayQueue struct {
+	m      sync.Mutex
+	items  delayHeap
+	byId   map[int64]*delayed
+	wake   chan struct{}
+	closed bool
+}
+
+// delays kernel delayed messages
+var delays = &delayQueue{
+	byId: make(map[int64]*delayed),
+	wake: make(chan struct{}, 1),
+}
+
+// add message delivered at the given moment, false when kernel shutdown
+func (q *delayQueue) add(at time.Time, m Message) bool {
+	q.m.Lock()
+	if q.closed {
+		q.m.Unlock()
+		return false
+	}
+	d := &delayed{at: at, message: m}
+	heap.Push(&q.items, d)
+	q.byId[m.Id] = d
+	q.m.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// cancel remove the message from queue before delivered
+func (q *delayQueue) cancel(id int64) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	d, ok := q.byId[id]
+	if !ok {
+		return false
+	}
+	delete(q.byId, id)
+	heap.Remove(&q.items, d.index)
+	return true
+}
+
+// popDue remove and return all messages whose delivery time reached
+func (q *delayQueue) popDue(now time.Time) []Message {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	var due []Message
+	for len(q.items) > 0 && !q.items[0].at.After(now) {
+		d := heap.Pop(&q.items).(*delayed)
+		delete(q.byId, d.message.Id)
+		due = append(due, d.message)
+	}
+	return due
+}
+
+// close refuse new messages and return the pending ones
+func (q *delayQueue) close() []Message {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.closed = true
+	pending := make([]Message, 0, len(q.items))
+	for len(q.items) > 0 {
+		pending = append(pending, heap.Pop(&q.items).(*delayed).message)
+	}
+	q.byId = make(map[int64]*delayed)
+	return pending
+}
+
+// reset discard the messages and accept new ones again
+func (q *delayQueue) reset() {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	q.closed = false
+	q.items = nil
+	q.byId = make(map[int64]*delayed)
+}
+
+// next delivery time of the first message, false when queue empty
+func (q *delayQueue) next() (time.Time, bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	if len(q.items) == 0 {
+		return time.Time{}, false
+	}
+	return q.items[0].at, true
+}
+
+// drain remove and return all pending messages, the queue keeps running
+func (q *delayQueue) drain() []*delayed {
+	q.m.Lock()
+	defer q.m.Unlock()
+
+	pending := make([]*delayed, 0, len(q.items))
+	for len(q.items) > 0 {
+		pending = append(pending, heap.Pop(&q.items).(*delayed))
+	}
+	q.byId = make(map[int64]*delayed)
+	return pending
+}
+
+// run move the due messages into kernel until stop closed
+func (q *delayQueue) run(stop <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		q.m.Lock()
+		wait := time.Hour
+		if len(q.items) > 0 {
+			wait = q.items[0].at.Sub(now())
+		}
+		q.m.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-stop:
+			return
+		case <-q.wake:
+		case <-timer.C:
+			for _, m := range q.popDue(now()) {
+				if !postMessage(m) {
+					Logger.Info("Delayed message discarded, kernel shutdown", zap.Int64("id", m.Id), zap.Int64("identifier", m.Identifier))
+					atomic.AddUint64(&metricsOf(m.Identifier).dropped, 1)
+				}
+			}
+		}
+	}
+}
+
+// CancelMessage cancel the delayed message by the id returned from SendMessageId
+// false when the message already delivered or not found
+func CancelMessage(id int64) bool {
+	return delays.cancel(id)
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+// received data of the messages waiting in the queue
+func received(queue chan Message) []string {
+	var data []string
+	for {
+		select {
+		case m := <-queue:
+			data = append(data, m.Data)
+		default:
+			return data
+		}
+	}
+}
+
+func TestDelayedDelivery(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	c := newTestClock(t)
+	t0 := c.Now()
+	queue := make(chan Message, 10)
+	InstallModule(1, queue)
+
+	sends := []struct {
+		data string
+		opt  sendOption
+	}{
+		{"later", WithDelay(time.Minute)},
+		{"sooner", WithDelay(10 * time.Second)},
+		{"at", WithDeliverAt(t0.Add(30 * time.Second))},
+		// already due
+		{"past", WithDeliverAt(t0.Add(-time.Second))},
+		{"cancelled", WithDelay(20 * time.Second)},
+	}
+	ids := map[string]int64{}
+	for _, s := range sends {
+		id, ok := SendMessageId(WithIdInt64(1), WithData(s.data), s.opt)
+		if !ok {
+			t.Fatalf("send %q failed", s.data)
+		}
+		ids[s.data] = id
+	}
+	if !CancelMessage(ids["cancelled"]) {
+		t.Error("delayed message not cancelled")
+	}
+	if CancelMessage(ids["past"]) {
+		t.Error("message not delayed cancelled")
+	}
+
+	tests := []struct {
+		advance time.Duration
+		want    []string
+	}{
+		{0, []string{"past"}},
+		{9 * time.Second, nil},
+		{time.Second, []string{"sooner"}},
+		// the cancelled one due at 20s never arrives
+		{25 * time.Second, []string{"at"}},
+		{time.Hour, []string{"later"}},
+	}
+	for _, tt := range tests {
+		c.advance(tt.advance)
+		Fire()
+		for Poll() {
+		}
+		if got := received(queue); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("at %s: received %v, want %v", c.Now().Sub(t0), got, tt.want)
+		}
+	}
+	if CancelMessage(ids["later"]) {
+		t.Error("delivered message cancelled")
+	}
+}
+
+func TestDelayedShutdown(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	newTestClock(t)
+	InstallModule(1, make(chan Message, 1))
+	SendMessage(WithIdInt64(1), WithData("a"), WithDelay(time.Minute))
+
+	Shutdown()
+	letters, _ := DeadLetters(DeadLetterQuery{})
+	if len(letters) != 1 || letters[0].Message.Data != "a" || letters[0].Reason != ReasonShutdown {
+		t.Errorf("dead letters after shutdown %+v", letters)
+	}
+	if SendMessage(WithIdInt64(1), WithDelay(time.Minute)) {
+		t.Error("delayed message accepted after shutdown")
+	}
+}
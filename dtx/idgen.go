@@ -0,0 +1,148 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Snowflake id layout: 41 bits milliseconds since SnowflakeEpoch, 10 bits node, 12 bits sequence
+const (
+	snowflakeNodeBits     = 10
+	snowflakeSequenceBits = 12
+
+	// MaxSnowflakeNode largest node id of Snowflake
+	MaxSnowflakeNode = 1<<snowflakeNodeBits - 1
+)
+
+// SnowflakeEpoch the zero time of the Snowflake ids
+var SnowflakeEpoch = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// IdGenerator assign the ids of messages, Next is called concurrently
+// ids should be positive and unique, 0 means no id
+type IdGenerator interface {
+	Next() int64
+}
+
+// Snowflake time-ordered ids unique across the processes with different node ids,
+// monotonic within the process even when the kernel clock goes backward
+type Snowflake struct {
+	node int64
+	// state milliseconds since epoch << sequence bits | sequence of the last id
+	state uint64
+}
+
+// NewSnowflake generator for node, node in [0, MaxSnowflakeNode]
+func NewSnowflake(node int64) (*Snowflake, error) {
+	if node < 0 || node > MaxSnowflakeNode {
+		return nil, fmt.Errorf("core: snowflake node out of range [0, %d]: %d", MaxSnowflakeNode, node)
+	}
+	return &Snowflake{node: node}, nil
+}
+
+// Next id of the kernel clock, lock-free, the sequence exhausted in one millisecond borrows the next millisecond
+// the time before SnowflakeEpoch counts as the epoch
+func (s *Snowflake) Next() int64 {
+	var ms uint64
+	if d := now().Sub(SnowflakeEpoch); d > 0 {
+		ms = uint64(d / time.Millisecond)
+	}
+	for {
+		last := atomic.LoadUint64(&s.state)
+		next := ms << snowflakeSequenceBits
+		if next <= last {
+			next = last + 1
+		}
+		if atomic.CompareAndSwapUint64(&s.state, last, next) {
+			return int64((next>>snowflakeSequenceBits)<<(snowflakeNodeBits+snowflakeSequenceBits) |
+				uint64(s.node)<<snowflakeSequenceBits |
+				next&(1<<snowflakeSequenceBits-1))
+		}
+	}
+}
+
+// ParseSnowflake the time, node and sequence of the Snowflake id
+func ParseSnowflake(id int64) (t time.Time, node int64, sequence int64) {
+	ms := id >> (snowflakeNodeBits + snowflakeSequenceBits)
+	node = id >> snowflakeSequenceBits & MaxSnowflakeNode
+	sequence = id & (1<<snowflakeSequenceBits - 1)
+	return SnowflakeEpoch.Add(time.Duration(ms) * time.Millisecond), node, sequence
+}
+
+// generatorHolder keep the same concrete type in atomic.Value
+type generatorHolder struct {
+	IdGenerator
+}
+
+var (
+	// idGenerator generator set by SetIdGenerator, the default one when none
+	idGenerator atomic.Value
+
+	// defaultId default generator of the kernel, resolved at the first id
+	// instead of init so that the Logger is ready and the environment read late
+	defaultId struct {
+		once      sync.Once
+		generator IdGenerator
+	}
+)
+
+// defaultIdGenerator the default generator, the same one for the process
+func defaultIdGenerator() IdGenerator {
+	defaultId.once.Do(func() {
+		defaultId.generator = defaultGenerator()
+	})
+	return defaultId.generator
+}
+
+// resetDefaultId forget the last id of the default generator, the ids restart at the kernel clock
+func resetDefaultId() {
+	if s, ok := defaultIdGenerator().(*Snowflake); ok {
+		atomic.StoreUint64(&s.state, 0)
+	}
+}
+
+// defaultGenerator Snowflake of the node in env DTX_NODE_ID,
+// derived from the host name and process id when not set
+// derived nodes of different processes may collide as there are only 1024 of them,
+// set a unique DTX_NODE_ID for each process when the ids must be unique across processes
+func defaultGenerator() IdGenerator {
+	if v := os.Getenv("DTX_NODE_ID"); v != "" {
+		node, e := strconv.ParseInt(v, 10, 64)
+		if e == nil {
+			if s, e := NewSnowflake(node); e == nil {
+				return s
+			}
+		}
+		Logger.Warn(fmt.Sprintf("Invalid DTX_NODE_ID %q, node derived from host name and process id", v))
+	}
+	node := derivedNode()
+	Logger.Info(fmt.Sprintf("Snowflake node %d derived from host name and process id, set DTX_NODE_ID to avoid collisions", node))
+	s, _ := NewSnowflake(node)
+	return s
+}
+
+// derivedNode node of the host name and process id, the same for the process restarted with the same pid
+func derivedNode() int64 {
+	host, _ := os.Hostname()
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(host))
+	_, _ = h.Write([]byte(strconv.Itoa(os.Getpid())))
+	return int64(h.Sum32() & MaxSnowflakeNode)
+}
+
+// SetIdGenerator replace the generator of message ids, nil restores the default Snowflake
+func SetIdGenerator(g IdGenerator) {
+	idGenerator.Store(generatorHolder{g})
+}
+
+// nextId id of the next message
+func nextId() int64 {
+	if h, ok := idGenerator.Load().(generatorHolder); ok && h.IdGenerator != nil {
+		return h.Next()
+	}
+	return defaultIdGenerator().Next()
+}
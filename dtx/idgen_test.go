@@ -0,0 +1,86 @@
+package core
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestDefaultNode(t *testing.T) {
+	old, set := os.LookupEnv("DTX_NODE_ID")
+	t.Cleanup(func() {
+		if set {
+			os.Setenv("DTX_NODE_ID", old)
+		} else {
+			os.Unsetenv("DTX_NODE_ID")
+		}
+	})
+
+	derived := derivedNode()
+	if derived < 0 || derived > MaxSnowflakeNode || derived != derivedNode() {
+		t.Fatalf("derived node %d", derived)
+	}
+	tests := []struct {
+		env  string
+		want int64
+	}{
+		{"", derived},
+		{"0", 0},
+		{"42", 42},
+		{"1023", 1023},
+		// invalid nodes fall back to the derived one
+		{"1024", derived},
+		{"-1", derived},
+		{"node-1", derived},
+	}
+	for _, tt := range tests {
+		os.Setenv("DTX_NODE_ID", tt.env)
+		s, ok := defaultGenerator().(*Snowflake)
+		if !ok {
+			t.Fatalf("default generator %T", defaultGenerator())
+		}
+		if s.node != tt.want {
+			t.Errorf("DTX_NODE_ID=%q: node %d, want %d", tt.env, s.node, tt.want)
+		}
+	}
+}
+
+func TestSnowflake(t *testing.T) {
+	c := newTestClock(t)
+	t0 := c.Now()
+	s, _ := NewSnowflake(7)
+	last := s.Next()
+
+	tests := []struct {
+		name    string
+		advance time.Duration
+		// ids generated, the time and sequence of the last one
+		ids      int
+		at       time.Duration
+		sequence int64
+	}{
+		{"next millisecond", time.Millisecond, 1, time.Millisecond, 0},
+		{"same millisecond", 0, 3, time.Millisecond, 3},
+		// the clock going backward keeps the last time
+		{"clock rollback", -time.Hour, 2, time.Millisecond, 5},
+		{"clock caught up", time.Hour, 1, time.Millisecond, 6},
+		// the sequence exhausted borrows the next millisecond
+		{"sequence exhausted", 0, 1 << snowflakeSequenceBits, 2 * time.Millisecond, 6},
+		{"after borrowed", 2 * time.Millisecond, 1, 3 * time.Millisecond, 0},
+	}
+	for _, tt := range tests {
+		c.advance(tt.advance)
+		for i := 0; i < tt.ids; i++ {
+			id := s.Next()
+			if id <= last {
+				t.Fatalf("%s: id %d after %d", tt.name, id, last)
+			}
+			last = id
+		}
+		at, node, sequence := ParseSnowflake(last)
+		if !at.Equal(t0.Add(tt.at)) || node != 7 || sequence != tt.sequence {
+			t.Errorf("%s: id at %s node %d sequence %d, want %s 7 %d",
+				tt.name, at.Sub(t0), node, sequence, tt.at, tt.sequence)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stage where the interceptor wraps the message
+type Stage int
+
+const (
+	// StageSend message sent by SendMessage, before it enters the kernel
+	StageSend Stage = iota
+	// StageDeliver message routed by kernel, before it enters the module queue
+	StageDeliver
+)
+
+func (s Stage) String() string {
+	switch s {
+	case StageSend:
+		return "send"
+	case StageDeliver:
+		return "deliver"
+	}
+	return fmt.Sprintf("stage(%d)", int(s))
+}
+
+// Invoker continue the message along the chain
+type Invoker func(m *Message) error
+
+// Interceptor wrap the message at stage
+// call next to continue, the message can be changed before next called,
+// return an error without calling next to reject the message,
+// return Delay(d) to deliver the message later
+// the error returned by next is the result of the delivery
+type Interceptor func(stage Stage, m *Message, next Invoker) error
+
+// InterceptorId handle used to remove the interceptor
+type InterceptorId int64
+
+// DelayError returned by interceptor to postpone the message
+type DelayError struct {
+	After time.Duration
+}
+
+func (e *DelayError) Error() string {
+	return fmt.Sprintf("core: message delayed %s", e.After)
+}
+
+// Delay postpone the message, returned by interceptor
+func Delay(d time.Duration) error {
+	return &DelayError{After: d}
+}
+
+// ErrRejected common error interceptors can return to reject message
+var ErrRejected = errors.New("core: message rejected")
+
+// interceptorEntry inner struct of the registered interceptor
+type interceptorEntry struct {
+	id          InterceptorId
+	order       int
+	interceptor Interceptor
+	stages      map[Stage]bool
+	modules     map[int64]bool
+}
+
+// match whether the interceptor applies to the message at stage
+func (e *interceptorEntry) match(stage Stage, identifier int64) bool {
+	if len(e.stages) > 0 && !e.stages[stage] {
+		return false
+	}
+	if len(e.modules) > 0 && !e.modules[identifier] {
+		return false
+	}
+	return true
+}
+
+var (
+	// interceptors []*interceptorEntry ordered, copied on write
+	interceptors atomic.Value
+
+	interceptorLock sync.Mutex
+	interceptorSeq  int64
+)
+
+type interceptorOption func(*interceptorEntry)
+
+// WithOrder interceptor with smaller order runs first, same order runs by registration
+func WithOrder(order int) interceptorOption {
+	return func(e *interceptorEntry) {
+		e.order = order
+	}
+}
+
+// WithStages only applies the interceptor at stages, default all stages
+func WithStages(stages ...Stage) interceptorOption {
+	return func(e *interceptorEntry) {
+		if e.stages == nil {
+			e.stages = make(map[Stage]bool)
+		}
+		for _, s := range stages {
+			e.stages[s] = true
+		}
+	}
+}
+
+// WithModules only applies the interceptor to messages for modules, default all modules
+func WithModules(identifiers ...int64) interceptorOption {
+	return func(e *interceptorEntry) {
+		if e.modules == nil {
+			e.modules = make(map[int64]bool)
+		}
+		for _, id := range identifiers {
+			e.modules[id] = true
+		}
+	}
+}
+
+// UseInterceptor register interceptor into kernel
+func UseInterceptor(interceptor Interceptor, opts ...interceptorOption) InterceptorId {
+	e := &interceptorEntry{interceptor: interceptor}
+	for _, o := range opts {
+		o(e)
+	}
+
+	interceptorLock.Lock()
+	defer interceptorLock.Unlock()
+
+	interceptorSeq++
+	e.id = InterceptorId(interceptorSeq)
+
+	old, _ := interceptors.Load().([]*interceptorEntry)
+	list := make([]*interceptorEntry, 0, len(old)+1)
+	list = append(list, old...)
+	list = append(list, e)
+	sort.SliceStable(list, func(i, j int) bool {
+		return list[i].order < list[j].order
+	})
+	interceptors.Store(list)
+	return e.id
+}
+
+// RemoveInterceptor remove interceptor from kernel
+func RemoveInterceptor(id InterceptorId) bool {
+	interceptorLock.Lock()
+	defer interceptorLock.Unlock()
+
+	old, _ := interceptors.Load().([]*interceptorEntry)
+	list := make([]*interceptorEntry, 0, len(old))
+	for _, e := range old {
+		if e.id != id {
+			list = append(list, e)
+		}
+	}
+	interceptors.Store(list)
+	return len(list) != len(old)
+}
+
+// intercept run the message through the interceptors matched, final is called at the end
+func intercept(stage Stage, m *Message, final Invoker) error {
+	all, _ := interceptors.Load().([]*interceptorEntry)
+	if len(all) == 0 {
+		return final(m)
+	}
+
+	var chain []Interceptor
+	for _, e := range all {
+		if e.match(stage, m.Identifier) {
+			chain = append(chain, e.interceptor)
+		}
+	}
+
+	var next func(i int) Invoker
+	next = func(i int) Invoker {
+		if i == len(chain) {
+			return final
+		}
+		return func(m *Message) error {
+			return chain[i](stage, m, next(i+1))
+		}
+	}
+	return next(0)(m)
+}
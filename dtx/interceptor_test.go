@@ -0,0 +1,127 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestInterceptorChain(t *testing.T) {
+	tests := []struct {
+		name string
+		// opts of the interceptors a, b and c, registered in order
+		opts       [3][]interceptorOption
+		identifier int64
+		want       []string
+	}{
+		{"registration order", [3][]interceptorOption{}, 1,
+			[]string{"send a", "send b", "send c", "deliver a", "deliver b", "deliver c"}},
+		{"by order", [3][]interceptorOption{{WithOrder(2)}, {WithOrder(1)}, {WithOrder(-1)}}, 1,
+			[]string{"send c", "send b", "send a", "deliver c", "deliver b", "deliver a"}},
+		{"by stage", [3][]interceptorOption{{WithStages(StageSend)}, {WithStages(StageDeliver)}, {WithStages(StageSend, StageDeliver)}}, 1,
+			[]string{"send a", "send c", "deliver b", "deliver c"}},
+		{"by module", [3][]interceptorOption{{WithModules(2)}, {WithModules(1, 2)}, nil}, 1,
+			[]string{"send b", "send c", "deliver b", "deliver c"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			InstallModule(tt.identifier, make(chan Message, 1))
+			var got []string
+			for i, name := range []string{"a", "b", "c"} {
+				name := name
+				UseInterceptor(func(stage Stage, m *Message, next Invoker) error {
+					got = append(got, fmt.Sprintf("%s %s", stage, name))
+					return next(m)
+				}, tt.opts[i]...)
+			}
+			SendMessage(WithIdInt64(tt.identifier))
+			for Poll() {
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("chain %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInterceptorOutcome(t *testing.T) {
+	tests := []struct {
+		name        string
+		interceptor Interceptor
+		stage       Stage
+		sent        bool
+		// received data after the message routed and after a minute
+		now, later []string
+		reason     Reason
+	}{
+		{"changed", func(_ Stage, m *Message, next Invoker) error {
+			m.Data += "!"
+			return next(m)
+		}, StageSend, true, []string{"a!"}, nil, ""},
+		{"rejected at send", func(Stage, *Message, Invoker) error {
+			return ErrRejected
+		}, StageSend, false, nil, nil, ReasonRejected},
+		{"rejected at deliver", func(Stage, *Message, Invoker) error {
+			return ErrRejected
+		}, StageDeliver, true, nil, nil, ReasonRejected},
+		{"delayed", func(_ Stage, m *Message, next Invoker) error {
+			if m.Metadata["delayed"] == "" {
+				m.Metadata = map[string]string{"delayed": "1"}
+				return Delay(30 * time.Second)
+			}
+			return next(m)
+		}, StageDeliver, true, nil, []string{"a"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			c := newTestClock(t)
+			queue := make(chan Message, 1)
+			InstallModule(1, queue)
+			UseInterceptor(tt.interceptor, WithStages(tt.stage))
+
+			if sent := SendMessage(WithIdInt64(1), WithData("a")); sent != tt.sent {
+				t.Fatalf("SendMessage = %v, want %v", sent, tt.sent)
+			}
+			for Poll() {
+			}
+			if got := received(queue); !reflect.DeepEqual(got, tt.now) {
+				t.Errorf("received %v, want %v", got, tt.now)
+			}
+			c.advance(time.Minute)
+			Fire()
+			for Poll() {
+			}
+			if got := received(queue); !reflect.DeepEqual(got, tt.later) {
+				t.Errorf("received after a minute %v, want %v", got, tt.later)
+			}
+			letters, _ := DeadLetters(DeadLetterQuery{})
+			if (len(letters) == 1) != (tt.reason != "") || (len(letters) == 1 && letters[0].Reason != tt.reason) {
+				t.Errorf("dead letters %+v, want reason %q", letters, tt.reason)
+			}
+		})
+	}
+}
+
+func TestRemoveInterceptor(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	queue := make(chan Message, 1)
+	InstallModule(1, queue)
+	id := UseInterceptor(func(Stage, *Message, Invoker) error {
+		return ErrRejected
+	})
+	if !RemoveInterceptor(id) {
+		t.Fatal("interceptor not removed")
+	}
+	if RemoveInterceptor(id) {
+		t.Error("interceptor removed twice")
+	}
+	if !SendMessage(WithIdInt64(1), WithData("a")) {
+		t.Error("rejected by the removed interceptor")
+	}
+}
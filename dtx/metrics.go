@@ -0,0 +1,297 @@
+package core
+
+import (
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets upper bounds in seconds of the latency histograms
+var latencyBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// histogram lock-free latency histogram
+// count and sum are kept first for 64-bit alignment of atomic operations
+type histogram struct {
+	count  uint64
+	sum    int64
+	counts []uint64
+}
+
+func newHistogram() *histogram {
+	return &histogram{counts: make([]uint64, len(latencyBuckets))}
+}
+
+// observe record one duration
+func (h *histogram) observe(d time.Duration) {
+	s := d.Seconds()
+	for i, b := range latencyBuckets {
+		if s <= b {
+			atomic.AddUint64(&h.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// snapshot copy the histogram with cumulative bucket counts
+func (h *histogram) snapshot() Histogram {
+	r := Histogram{
+		Buckets: latencyBuckets,
+		Counts:  make([]uint64, len(latencyBuckets)),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)).Seconds(),
+	}
+	var total uint64
+	for i := range h.counts {
+		total += atomic.LoadUint64(&h.counts[i])
+		r.Counts[i] = total
+	}
+	return r
+}
+
+// Histogram latency distribution, Counts[i] is the number of observations <= Buckets[i] seconds
+type Histogram struct {
+	Buckets []float64 `json:"buckets"`
+	Counts  []uint64  `json:"counts"`
+	Count   uint64    `json:"count"`
+	Sum     float64   `json:"sum"`
+}
+
+// moduleMetrics counters of each module identifier
+type moduleMetrics struct {
+	delivered uint64
+	dropped   uint64
+	expired   uint64
+	throttled uint64
+	delayed   uint64
+	latency   *histogram
+}
+
+var (
+	// metrics counters keyed by module identifier, map[int64]*moduleMetrics
+	metrics sync.Map
+	// unknown counters of the identifiers never installed, kept in one bucket
+	// so that arbitrary identifiers do not grow the metrics
+	unknown = &moduleMetrics{latency: newHistogram()}
+)
+
+// metricsOf get the counters of the identifier, created when the module installed
+func metricsOf(id int64) *moduleMetrics {
+	if v, ok := metrics.Load(id); ok {
+		return v.(*moduleMetrics)
+	}
+	if _, ok := modules.Load(id); !ok {
+		return unknown
+	}
+	v, _ := metrics.LoadOrStore(id, &moduleMetrics{latency: newHistogram()})
+	return v.(*moduleMetrics)
+}
+
+// ModuleStats introspection of one module identifier
+type ModuleStats struct {
+	Id             int64     `json:"id"`
+	Installed      bool      `json:"installed"`
+	QueueDepth     int       `json:"queue_depth"`
+	QueueCapacity  int       `json:"queue_capacity"`
+	Delivered      uint64    `json:"delivered"`
+	Dropped        uint64    `json:"dropped"`
+	Expired        uint64    `json:"expired"`
+	Throttled      uint64    `json:"throttled"`
+	Delayed        uint64    `json:"delayed"`
+	HandlerLatency Histogram `json:"handler_latency"`
+}
+
+// TaskStats introspection of one scheduled task
+type TaskStats struct {
+	Id       TaskId    `json:"id"`
+	Name     string    `json:"name"`
+	Next     time.Time `json:"next"`
+	Duration Histogram `json:"duration"`
+}
+
+// KernelStats introspection of the whole kernel
+type KernelStats struct {
+	InboxDepth    int           `json:"inbox_depth"`
+	InboxCapacity int           `json:"inbox_capacity"`
+	Modules       []ModuleStats `json:"modules"`
+	// Unknown messages to the identifiers never installed
+	Unknown ModuleStats `json:"unknown"`
+	Tasks   []TaskStats `json:"tasks"`
+}
+
+// Stats collect the kernel introspection, modules and tasks ordered by id
+func Stats() KernelStats {
+	s := KernelStats{
+		InboxDepth:    inbox.len(),
+		InboxCapacity: inbox.cap(),
+		Unknown: ModuleStats{
+			Dropped: atomic.LoadUint64(&unknown.dropped),
+			Expired: atomic.LoadUint64(&unknown.expired),
+		},
+	}
+
+	seen := make(map[int64]bool)
+	modules.Range(func(key, value interface{}) bool {
+		id := key.(int64)
+		mod := value.(*module)
+		m := metricsOf(id)
+		seen[id] = true
+		s.Modules = append(s.Modules, ModuleStats{
+			Id:             id,
+			Installed:      true,
+			QueueDepth:     mod.depth(),
+			QueueCapacity:  mod.capacity(),
+			Delivered:      atomic.LoadUint64(&m.delivered),
+			Dropped:        atomic.LoadUint64(&m.dropped),
+			Expired:        atomic.LoadUint64(&m.expired),
+			Throttled:      atomic.LoadUint64(&m.throttled),
+			Delayed:        atomic.LoadUint64(&m.delayed),
+			HandlerLatency: m.latency.snapshot(),
+		})
+		return true
+	})
+	metrics.Range(func(key, value interface{}) bool {
+		id := key.(int64)
+		if seen[id] {
+			return true
+		}
+		m := value.(*moduleMetrics)
+		s.Modules = append(s.Modules, ModuleStats{
+			Id:             id,
+			Delivered:      atomic.LoadUint64(&m.delivered),
+			Dropped:        atomic.LoadUint64(&m.dropped),
+			Expired:        atomic.LoadUint64(&m.expired),
+			Throttled:      atomic.LoadUint64(&m.throttled),
+			Delayed:        atomic.LoadUint64(&m.delayed),
+			HandlerLatency: m.latency.snapshot(),
+		})
+		return true
+	})
+	sort.Slice(s.Modules, func(i, j int) bool {
+		return s.Modules[i].Id < s.Modules[j].Id
+	})
+
+	sched.m.Lock()
+	for _, t := range sched.tasks {
+		if t.internal {
+			continue
+		}
+		s.Tasks = append(s.Tasks, TaskStats{
+			Id:       t.id,
+			Name:     t.name,
+			Next:     t.next,
+			Duration: t.duration.snapshot(),
+		})
+	}
+	sched.m.Unlock()
+	sort.Slice(s.Tasks, func(i, j int) bool {
+		return s.Tasks[i].Id < s.Tasks[j].Id
+	})
+
+	return s
+}
+
+// MetricsHandler serve the kernel stats in Prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, Stats())
+	})
+}
+
+// WritePrometheus write the stats in Prometheus text format
+func WritePrometheus(w io.Writer, s KernelStats) {
+	gauge := func(name, help string) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+	}
+	counter := func(name, help string) {
+		_, _ = fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+
+	gauge("dtx_inbox_depth", "Messages waiting in the kernel inbox.")
+	_, _ = fmt.Fprintf(w, "dtx_inbox_depth %d\n", s.InboxDepth)
+	gauge("dtx_inbox_capacity", "Capacity of the kernel inbox.")
+	_, _ = fmt.Fprintf(w, "dtx_inbox_capacity %d\n", s.InboxCapacity)
+
+	gauge("dtx_module_queue_depth", "Messages waiting in the module queue.")
+	for _, m := range s.Modules {
+		if m.Installed {
+			_, _ = fmt.Fprintf(w, "dtx_module_queue_depth{module=\"%d\"} %d\n", m.Id, m.QueueDepth)
+		}
+	}
+	gauge("dtx_module_queue_capacity", "Capacity of the module queue.")
+	for _, m := range s.Modules {
+		if m.Installed {
+			_, _ = fmt.Fprintf(w, "dtx_module_queue_capacity{module=\"%d\"} %d\n", m.Id, m.QueueCapacity)
+		}
+	}
+	counter("dtx_module_messages_delivered_total", "Messages delivered into the module queue.")
+	for _, m := range s.Modules {
+		_, _ = fmt.Fprintf(w, "dtx_module_messages_delivered_total{module=\"%d\"} %d\n", m.Id, m.Delivered)
+	}
+	counter("dtx_module_messages_dropped_total", "Messages to the module discarded by kernel.")
+	for _, m := range s.Modules {
+		_, _ = fmt.Fprintf(w, "dtx_module_messages_dropped_total{module=\"%d\"} %d\n", m.Id, m.Dropped)
+	}
+	_, _ = fmt.Fprintf(w, "dtx_module_messages_dropped_total{module=\"unknown\"} %d\n", s.Unknown.Dropped)
+	counter("dtx_module_messages_expired_total", "Messages to the module discarded because the deadline passed.")
+	for _, m := range s.Modules {
+		_, _ = fmt.Fprintf(w, "dtx_module_messages_expired_total{module=\"%d\"} %d\n", m.Id, m.Expired)
+	}
+	_, _ = fmt.Fprintf(w, "dtx_module_messages_expired_total{module=\"unknown\"} %d\n", s.Unknown.Expired)
+	counter("dtx_module_messages_throttled_total", "Messages rejected by the rate limits of the module.")
+	for _, m := range s.Modules {
+		_, _ = fmt.Fprintf(w, "dtx_module_messages_throttled_total{module=\"%d\"} %d\n", m.Id, m.Throttled)
+	}
+	counter("dtx_module_messages_throttle_delayed_total", "Messages delayed by the rate limits of the module.")
+	for _, m := range s.Modules {
+		_, _ = fmt.Fprintf(w, "dtx_module_messages_throttle_delayed_total{module=\"%d\"} %d\n", m.Id, m.Delayed)
+	}
+
+	_, _ = fmt.Fprintf(w, "# HELP dtx_module_handler_latency_seconds Latency of the module handler.\n# TYPE dtx_module_handler_latency_seconds histogram\n")
+	for _, m := range s.Modules {
+		// modules installed with raw queue have no handler measured
+		if m.HandlerLatency.Count == 0 {
+			continue
+		}
+		writeHistogram(w, "dtx_module_handler_latency_seconds", fmt.Sprintf("module=\"%d\"", m.Id), m.HandlerLatency)
+	}
+	_, _ = fmt.Fprintf(w, "# HELP dtx_task_duration_seconds Duration of the scheduled task runs.\n# TYPE dtx_task_duration_seconds histogram\n")
+	for _, t := range s.Tasks {
+		writeHistogram(w, "dtx_task_duration_seconds", fmt.Sprintf("task=\"%s\",id=\"%d\"", escapeLabel(t.Name), t.Id), t.Duration)
+	}
+}
+
+// labelEscaper escape the label value of Prometheus text format, only backslash, quote and new line
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabel the label value escaped
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+// writeHistogram write the bucket, sum and count lines of histogram
+func writeHistogram(w io.Writer, name, labels string, h Histogram) {
+	for i, b := range h.Buckets {
+		_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"%s\"} %d\n", name, labels, strconv.FormatFloat(b, 'g', -1, 64), h.Counts[i])
+	}
+	_, _ = fmt.Fprintf(w, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.Count)
+	_, _ = fmt.Fprintf(w, "%s_sum{%s} %s\n", name, labels, strconv.FormatFloat(h.Sum, 'g', -1, 64))
+	_, _ = fmt.Fprintf(w, "%s_count{%s} %d\n", name, labels, h.Count)
+}
+
+// PublishExpvar export the kernel stats with expvar under the name
+// panics like expvar.Publish when the name already used
+func PublishExpvar(name string) {
+	expvar.Publish(name, expvar.Func(func() interface{} {
+		return Stats()
+	}))
+}
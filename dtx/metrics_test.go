@@ -0,0 +1,84 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEscapeLabel(t *testing.T) {
+	tests := []struct {
+		value, want string
+	}{
+		{"task", "task"},
+		{`say "hi"`, `say \"hi\"`},
+		{`C:\tmp`, `C:\\tmp`},
+		{"a\nb", `a\nb`},
+		// escaped by strconv.Quote, not by Prometheus
+		{"a\tb", "a\tb"},
+		{"héllo", "héllo"},
+	}
+	for _, tt := range tests {
+		if got := escapeLabel(tt.value); got != tt.want {
+			t.Errorf("escapeLabel(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	newTestClock(t)
+
+	InstallModule(1, make(chan Message, 10))
+	SendMessage(WithIdInt64(1))
+	SendMessage(WithIdInt64(1))
+	SendMessage(WithIdInt64(2))
+	for Poll() {
+	}
+	id := scheduleTest(t, Every(time.Hour), func(context.Context) {}, WithTaskName("say \"hi\"\tC:\\\n"))
+	sched.m.Lock()
+	task := sched.tasks[id]
+	sched.m.Unlock()
+	sched.execute(task)
+	waitFor(t, "task run", func() bool { return task.duration.snapshot().Count == 1 })
+
+	server := httptest.NewServer(MetricsHandler())
+	defer server.Close()
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", ct)
+	}
+
+	lines := strings.Split(string(body), "\n")
+	for _, want := range []string{
+		"# TYPE dtx_inbox_depth gauge",
+		"dtx_inbox_depth 0",
+		`dtx_module_queue_depth{module="1"} 2`,
+		`dtx_module_messages_delivered_total{module="1"} 2`,
+		`dtx_module_messages_dropped_total{module="unknown"} 1`,
+		"# TYPE dtx_task_duration_seconds histogram",
+		fmt.Sprintf(`dtx_task_duration_seconds_count{task="say \"hi\"`+"\t"+`C:\\\n",id="%d"} 1`, id),
+	} {
+		found := false
+		for _, l := range lines {
+			if l == want {
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("line %s missing in\n%s", want, body)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package core
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WithKey partition key of the message, messages of the same key sent to
+// a module running WithWorkers are dealt in order by the same worker
+func WithKey(key string) sendOption {
+	return func(o *option) {
+		o.Key = key
+	}
+}
+
+// WithWorkers deal with the messages of the module by n handlers concurrently,
+// each worker has its own handler created by the factory
+// messages sharing the key are dealt in order while different keys run in parallel,
+// messages without key are spread over the workers in no order
+func WithWorkers(n int) superviseOption {
+	return func(s *supervised) {
+		if n < 1 {
+			n = 1
+		}
+		s.workers = n
+	}
+}
+
+// partition index of the worker dealing with the message
+func partition(m *Message, n int) int {
+	if m.Key == "" {
+		return int(uint64(m.Id) % uint64(n))
+	}
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(m.Key))
+	return int(h.Sum32() % uint32(n))
+}
+
+// runPool dispatch the messages to the workers by key until exit signal received or module failed
+func (s *supervised) runPool() {
+	defer s.leaveGroup()
+
+	var w sync.WaitGroup
+	queues := make([]chan Message, s.workers)
+	for i := range queues {
+		queues[i] = make(chan Message, s.queueSize)
+		w.Add(1)
+		go s.work(queues[i], &w)
+	}
+	s.m.Lock()
+	s.queues = queues
+	s.m.Unlock()
+	defer func() {
+		s.m.Lock()
+		s.queues = nil
+		s.m.Unlock()
+		for _, q := range queues {
+			close(q)
+		}
+		w.Wait()
+	}()
+
+	for {
+		m, ok := s.next()
+		if !ok {
+			return
+		}
+		if atomic.LoadInt32(&s.failed) == 1 {
+			// Id 0 the exit signal waking the dispatcher up
+			if m.Id != 0 {
+				settle(m, ErrNotInstalled)
+			}
+			s.discard()
+			return
+		}
+		// restart requested by the other module of the group
+		if atomic.CompareAndSwapInt32(&s.restart, 1, 0) {
+			atomic.AddInt64(&s.generation, 1)
+			Logger.Info(fmt.Sprintf("Module[id: %d] restarted by group: %s", s.id, s.group))
+		}
+
+		queues[partition(&m, len(queues))] <- m
+		// IsExitSignal the module has been uninstalled by kernel
+		if IsExitSignal(&m) {
+			return
+		}
+	}
+}
+
+// work deal with the messages of one worker until its queue closed
+func (s *supervised) work(queue chan Message, w *sync.WaitGroup) {
+	defer w.Done()
+
+	handler := s.factory()
+	generation := atomic.LoadInt64(&s.generation)
+	backoff := s.minBackoff
+
+	for m := range queue {
+		// module failed, the remaining messages are discarded
+		if atomic.LoadInt32(&s.failed) == 1 {
+			settle(m, ErrNotInstalled)
+			continue
+		}
+		if g := atomic.LoadInt64(&s.generation); g != generation {
+			generation = g
+			handler = s.factory()
+		}
+
+		if s.invoke(handler, m) {
+			backoff = s.minBackoff
+			continue
+		}
+		if !s.allowRestart() {
+			s.fail()
+			continue
+		}
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+		handler = s.factory()
+		Logger.Info(fmt.Sprintf("Module[id: %d] worker restarted", s.id))
+		if s.strategy == OneForAll {
+			atomic.AddInt64(&s.generation, 1)
+			generation = atomic.LoadInt64(&s.generation)
+			s.restartGroup()
+		}
+	}
+}
+
+// fail mark the pooled module failed and uninstall it, the dispatcher is woken
+// by an exit signal so it stops waiting for the messages never coming
+func (s *supervised) fail() {
+	if !atomic.CompareAndSwapInt32(&s.failed, 0, 1) {
+		return
+	}
+	failedModules.Store(s.id, struct{}{})
+	s.uninstall()
+	Logger.Error(fmt.Sprintf("Module[id: %d] failed after %d restarts in %s", s.id, s.maxRestarts, s.window))
+	// lanes full means the dispatcher has messages to wake it up
+	s.lanes.offer(Message{Identifier: s.id, Signal: SignalKill})
+}
@@ -0,0 +1,106 @@
+package core
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestPartition(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Message
+		n    int
+		same bool
+	}{
+		{"same key", Message{Id: 1, Key: "user-1"}, Message{Id: 2, Key: "user-1"}, 8, true},
+		{"no key by id", Message{Id: 3}, Message{Id: 11}, 8, true},
+		{"no key other id", Message{Id: 3}, Message{Id: 4}, 8, false},
+		{"one worker", Message{Id: 1, Key: "a"}, Message{Id: 2, Key: "b"}, 1, true},
+	}
+	for _, tt := range tests {
+		pa, pb := partition(&tt.a, tt.n), partition(&tt.b, tt.n)
+		if pa < 0 || pa >= tt.n || pb < 0 || pb >= tt.n {
+			t.Errorf("%s: partitions %d %d out of %d workers", tt.name, pa, pb, tt.n)
+		}
+		if (pa == pb) != tt.same {
+			t.Errorf("%s: partitions %d and %d", tt.name, pa, pb)
+		}
+	}
+}
+
+func TestPoolKeyOrder(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	var (
+		m   sync.Mutex
+		got = map[string][]int{}
+	)
+	// the first message of k0 blocks its worker, the other keys go on
+	block := make(chan struct{})
+	InstallHandler(1, func(message Message) {
+		var i int
+		_, _ = fmt.Sscan(message.Data, &i)
+		if message.Key == "k0" && i == 0 {
+			<-block
+		}
+		m.Lock()
+		got[message.Key] = append(got[message.Key], i)
+		m.Unlock()
+	}, WithWorkers(4))
+
+	keys := []string{"k0", "k1", "k2", "k3", "k4"}
+	const n = 50
+	for i := 0; i < n; i++ {
+		for _, key := range keys {
+			SendMessage(WithIdInt64(1), WithKey(key), WithData(fmt.Sprint(i)))
+		}
+	}
+	for Poll() {
+	}
+
+	done := func(key string) bool {
+		m.Lock()
+		defer m.Unlock()
+		return len(got[key]) == n
+	}
+	// keys not sharing the worker of k0 are not blocked by it
+	k0 := partition(&Message{Key: "k0"}, 4)
+	for _, key := range keys[1:] {
+		if partition(&Message{Key: key}, 4) != k0 {
+			waitFor(t, key+" dealt", func() bool { return done(key) })
+		}
+	}
+	close(block)
+
+	want := make([]int, n)
+	for i := range want {
+		want[i] = i
+	}
+	for _, key := range keys {
+		waitFor(t, key+" dealt", func() bool { return done(key) })
+		m.Lock()
+		if !reflect.DeepEqual(got[key], want) {
+			t.Errorf("%s dealt in order %v", key, got[key])
+		}
+		m.Unlock()
+	}
+}
+
+func TestWithWorkers(t *testing.T) {
+	tests := []struct {
+		n, want int
+	}{
+		{4, 4},
+		{1, 1},
+		{0, 1},
+		{-2, 1},
+	}
+	for _, tt := range tests {
+		s := newSupervised(1, nil, []superviseOption{WithWorkers(tt.n)})
+		if s.workers != tt.want {
+			t.Errorf("WithWorkers(%d): %d workers, want %d", tt.n, s.workers, tt.want)
+		}
+	}
+}
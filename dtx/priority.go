@@ -0,0 +1,295 @@
+package core
+
+import "sync"
+
+const (
+	// PriorityLow bulk traffic, served after the others
+	PriorityLow = -1
+	// PriorityNormal default priority of messages
+	PriorityNormal = 0
+	// PriorityHigh served before normal and low traffic, signals always use it
+	PriorityHigh = 1
+
+	// priorityLevels number of the priority lanes
+	priorityLevels = PriorityHigh - PriorityLow + 1
+
+	// starvationLimit after so many messages served while lower lanes waiting,
+	// one message of the lowest waiting lane is served
+	starvationLimit = 32
+)
+
+// lane index of the priority lane the message goes to
+func lane(m *Message) int {
+	p := m.Priority
+	if m.Signal != NORMAL || p > PriorityHigh {
+		p = PriorityHigh
+	}
+	if p < PriorityLow {
+		p = PriorityLow
+	}
+	return p - PriorityLow
+}
+
+// WithPriority priority of the message, PriorityNormal by default
+func WithPriority(priority int) sendOption {
+	return func(o *option) {
+		o.Priority = priority
+	}
+}
+
+// priorityQueue one channel per priority, higher lanes are served first
+// many producers are allowed but only one consumer
+type priorityQueue struct {
+	lanes [priorityLevels]chan Message
+	// recv consumer view of lanes, closed lanes are set to nil
+	recv   [priorityLevels]chan Message
+	streak int
+}
+
+// newPriorityQueue queue with size messages each lane
+func newPriorityQueue(size int) *priorityQueue {
+	q := &priorityQueue{}
+	for i := range q.lanes {
+		q.lanes[i] = make(chan Message, size)
+		q.recv[i] = q.lanes[i]
+	}
+	return q
+}
+
+// put message into its lane, blocks when the lane is full
+// panics like channel when queue closed
+func (q *priorityQueue) put(m Message) {
+	q.lanes[lane(&m)] <- m
+}
+
+// offer put message into its lane without blocking, false when the lane is full
+func (q *priorityQueue) offer(m Message) bool {
+	select {
+	case q.lanes[lane(&m)] <- m:
+		return true
+	default:
+		return false
+	}
+}
+
+// drain take the messages of all lanes without blocking, higher lanes first
+// safe to call besides the consumer
+func (q *priorityQueue) drain() []Message {
+	var pending []Message
+	for i := priorityLevels - 1; i >= 0; i-- {
+		for n := len(q.lanes[i]); n > 0; n-- {
+			select {
+			case m, ok := <-q.lanes[i]:
+				if ok {
+					pending = append(pending, m)
+				}
+			default:
+			}
+		}
+	}
+	return pending
+}
+
+// len messages waiting in all lanes
+func (q *priorityQueue) len() int {
+	n := 0
+	for _, l := range q.lanes {
+		n += len(l)
+	}
+	return n
+}
+
+// cap capacity of all lanes
+func (q *priorityQueue) cap() int {
+	n := 0
+	for _, l := range q.lanes {
+		n += cap(l)
+	}
+	return n
+}
+
+// close all lanes, messages in lanes can still be got
+func (q *priorityQueue) close() {
+	for _, l := range q.lanes {
+		close(l)
+	}
+}
+
+// try receive from lane i without blocking
+func (q *priorityQueue) try(i int) (Message, bool) {
+	select {
+	case m, ok := <-q.recv[i]:
+		if !ok {
+			q.recv[i] = nil
+			return Message{}, false
+		}
+		return m, true
+	default:
+		return Message{}, false
+	}
+}
+
+// waiting whether lanes lower than i have messages
+func (q *priorityQueue) waiting(i int) bool {
+	for j := 0; j < i; j++ {
+		if len(q.lanes[j]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// poll the next message without blocking, false when all lanes empty
+func (q *priorityQueue) poll() (Message, bool) {
+	if q.streak >= starvationLimit {
+		q.streak = 0
+		for i := 0; i < priorityLevels; i++ {
+			if m, ok := q.try(i); ok {
+				return m, true
+			}
+		}
+	}
+
+	for i := priorityLevels - 1; i >= 0; i-- {
+		if m, ok := q.try(i); ok {
+			if q.waiting(i) {
+				q.streak++
+			} else {
+				q.streak = 0
+			}
+			return m, true
+		}
+	}
+	return Message{}, false
+}
+
+// get the next message, blocks until any message arrived
+// false when queue closed and all lanes drained
+func (q *priorityQueue) get() (Message, bool) {
+	for {
+		if m, ok := q.poll(); ok {
+			return m, true
+		}
+
+		if q.recv[0] == nil && q.recv[1] == nil && q.recv[2] == nil {
+			return Message{}, false
+		}
+
+		// nothing ready, wait for any lane
+		select {
+		case m, ok := <-q.recv[2]:
+			if !ok {
+				q.recv[2] = nil
+				continue
+			}
+			return m, true
+		case m, ok := <-q.recv[1]:
+			if !ok {
+				q.recv[1] = nil
+				continue
+			}
+			return m, true
+		case m, ok := <-q.recv[0]:
+			if !ok {
+				q.recv[0] = nil
+				continue
+			}
+			return m, true
+		}
+	}
+}
+
+// killTable exit signals held until the messages sent to the module before them are routed,
+// signals use the high lane and would overtake them otherwise
+// lanes are FIFO, so the messages sent before the signal are taken once each lane
+// has taken as many messages of the module as were posted before the signal
+type killTable struct {
+	m      sync.Mutex
+	counts map[int64]*laneCounts
+}
+
+// laneCounts messages of one module posted into and taken from the inbox lanes
+type laneCounts struct {
+	posted [priorityLevels]uint64
+	taken  [priorityLevels]uint64
+	// before posted counts at each exit signal in the inbox, in order
+	before [][priorityLevels]uint64
+	// held exit signals taken before the messages sent before them
+	held []heldKill
+}
+
+type heldKill struct {
+	message Message
+	before  [priorityLevels]uint64
+}
+
+// kills exit signals waiting in the inbox or held by the kernel
+var kills = killTable{counts: make(map[int64]*laneCounts)}
+
+// covered whether all messages posted before are taken
+func (c *laneCounts) covered(before [priorityLevels]uint64) bool {
+	for i := range before {
+		if c.taken[i] < before[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// post count the message put into the inbox
+func (k *killTable) post(m *Message) {
+	k.m.Lock()
+	defer k.m.Unlock()
+	c, ok := k.counts[m.Identifier]
+	if !ok {
+		c = &laneCounts{}
+		k.counts[m.Identifier] = c
+	}
+	if IsExitSignal(m) {
+		c.before = append(c.before, c.posted)
+	}
+	c.posted[lane(m)]++
+}
+
+// take count the message taken out of the inbox, return the messages to route in order:
+// the message itself unless it is an exit signal held, then the signals it released
+func (k *killTable) take(m Message) []Message {
+	k.m.Lock()
+	defer k.m.Unlock()
+	c, ok := k.counts[m.Identifier]
+	if !ok {
+		// posted before Reset
+		return []Message{m}
+	}
+	c.taken[lane(&m)]++
+
+	var out []Message
+	if IsExitSignal(&m) && len(c.before) > 0 {
+		before := c.before[0]
+		c.before = c.before[1:]
+		// behind the signals already held too
+		if len(c.held) > 0 || !c.covered(before) {
+			c.held = append(c.held, heldKill{message: m, before: before})
+		} else {
+			out = append(out, m)
+		}
+	} else {
+		out = append(out, m)
+	}
+
+	for len(c.held) > 0 && c.covered(c.held[0].before) {
+		out = append(out, c.held[0].message)
+		c.held = c.held[1:]
+	}
+	if c.posted == c.taken && len(c.held) == 0 {
+		delete(k.counts, m.Identifier)
+	}
+	return out
+}
+
+// reset forget all counts and the held signals
+func (k *killTable) reset() {
+	k.m.Lock()
+	k.counts = make(map[int64]*laneCounts)
+	k.m.Unlock()
+}
@@ -0,0 +1,117 @@
+package core
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestKillAfterQueued(t *testing.T) {
+	tests := []struct {
+		name string
+		// install return the function called once the messages routed
+		install func(t *testing.T, received func(m Message)) func()
+	}{
+		{"plain channel", func(t *testing.T, received func(m Message)) func() {
+			queue := make(chan Message, 10)
+			InstallModule(1, queue)
+			go func() {
+				for m := range queue {
+					received(m)
+					if IsExitSignal(&m) {
+						return
+					}
+				}
+			}()
+			return func() {}
+		}},
+		// the handler is busy while the signal overtakes the others in the lanes
+		{"supervised", func(t *testing.T, received func(m Message)) func() {
+			release := make(chan struct{})
+			InstallHandler(1, func(m Message) {
+				if m.Data == "a" {
+					<-release
+				}
+				received(m)
+			})
+			return func() { close(release) }
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			var (
+				m   sync.Mutex
+				got []string
+			)
+			routed := tt.install(t, func(message Message) {
+				m.Lock()
+				got = append(got, message.Data)
+				m.Unlock()
+			})
+
+			SendMessage(WithIdInt64(1), WithData("a"))
+			SendMessage(WithIdInt64(1), WithData("b"), WithPriority(PriorityLow))
+			SendMessage(WithIdInt64(1), WithData("kill"), WithSignal(SignalKill))
+			for Poll() {
+			}
+			routed()
+
+			want := []string{"a", "b", "kill"}
+			waitFor(t, "all received", func() bool {
+				m.Lock()
+				defer m.Unlock()
+				return len(got) == len(want)
+			})
+			m.Lock()
+			defer m.Unlock()
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("received %v, want %v", got, want)
+			}
+			if letters, _ := DeadLetters(DeadLetterQuery{}); len(letters) != 0 {
+				t.Errorf("dead letters %+v", letters)
+			}
+		})
+	}
+}
+
+func TestKillTable(t *testing.T) {
+	normal := Message{Identifier: 1}
+	low := Message{Identifier: 1, Priority: PriorityLow}
+	kill := Message{Identifier: 1, Signal: SignalKill}
+	other := Message{Identifier: 2}
+
+	tests := []struct {
+		name   string
+		posted []Message
+		// taken in the order of the lanes, routed the messages released
+		taken  []Message
+		routed [][]Message
+	}{
+		{"nothing before", []Message{kill}, []Message{kill}, [][]Message{{kill}}},
+		{"held for the lower lanes", []Message{normal, low, kill}, []Message{kill, normal, low},
+			[][]Message{nil, {normal}, {low, kill}}},
+		{"messages of other modules ignored", []Message{other, kill}, []Message{kill, other},
+			[][]Message{{kill}, {other}}},
+		// the second signal waits behind the first one
+		{"signals in order", []Message{low, kill, kill}, []Message{kill, kill, low},
+			[][]Message{nil, nil, {low, kill, kill}}},
+		{"sent after the signal", []Message{kill, normal}, []Message{kill, normal},
+			[][]Message{{kill}, {normal}}},
+	}
+	for _, tt := range tests {
+		k := killTable{counts: make(map[int64]*laneCounts)}
+		for i := range tt.posted {
+			k.post(&tt.posted[i])
+		}
+		for i, m := range tt.taken {
+			if got := k.take(m); !reflect.DeepEqual(got, tt.routed[i]) {
+				t.Errorf("%s: take %d routed %v, want %v", tt.name, i, got, tt.routed[i])
+			}
+		}
+		if len(k.counts) != 0 {
+			t.Errorf("%s: counts left %v", tt.name, k.counts)
+		}
+	}
+}
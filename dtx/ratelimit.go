@@ -0,0 +1,195 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrThrottled message rejected by the rate limit or quota of module
+var ErrThrottled = errors.New("core: message throttled")
+
+// ThrottlePolicy what to do with the messages over the limit
+type ThrottlePolicy int
+
+const (
+	// ThrottleReject excess messages are discarded with ErrThrottled
+	ThrottleReject ThrottlePolicy = iota
+	// ThrottleDelay excess messages are delayed until the limit allows them
+	ThrottleDelay
+)
+
+// Limit rate limit and quota of the messages, zero fields are not limited
+type Limit struct {
+	// Rate messages per second refilled into the token bucket
+	Rate float64
+	// Burst size of the token bucket, at least 1
+	Burst int
+	// Quota messages allowed in each rolling Window
+	Quota  int
+	Window time.Duration
+	Policy ThrottlePolicy
+}
+
+// WithSource the module sending the message, used by the send limit of that module
+func WithSource(id int64) sendOption {
+	return func(o *option) {
+		o.Source = id
+	}
+}
+
+// WithSendLimit limit the messages the module sends, messages are attributed WithSource
+func WithSendLimit(l Limit) installOption {
+	return func(m *module) {
+		m.sendLimit = newLimiter(l)
+	}
+}
+
+// WithReceiveLimit limit the messages sent to the module
+func WithReceiveLimit(l Limit) installOption {
+	return func(m *module) {
+		m.receiveLimit = newLimiter(l)
+	}
+}
+
+// limiter token bucket and rolling window quota of a module
+type limiter struct {
+	m      sync.Mutex
+	limit  Limit
+	tokens float64
+	last   time.Time
+	// sent times of the last Quota messages, oldest at head
+	sent []time.Time
+	head int
+}
+
+// newLimiter limiter with a full bucket
+func newLimiter(l Limit) *limiter {
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	return &limiter{limit: l, tokens: float64(l.Burst)}
+}
+
+// reserve the delay before sending one message at now or false when rejected,
+// the message is not charged until charge, lock held by caller
+func (l *limiter) reserve(now time.Time) (time.Duration, bool) {
+	delayed := l.limit.Policy == ThrottleDelay
+	var wait time.Duration
+
+	if l.limit.Rate > 0 {
+		if !l.last.IsZero() && now.After(l.last) {
+			l.tokens += now.Sub(l.last).Seconds() * l.limit.Rate
+			if burst := float64(l.limit.Burst); l.tokens > burst {
+				l.tokens = burst
+			}
+		}
+		if now.After(l.last) {
+			l.last = now
+		}
+		if l.tokens < 1 {
+			if !delayed {
+				return 0, false
+			}
+			wait = time.Duration((1 - l.tokens) / l.limit.Rate * float64(time.Second))
+		}
+	}
+
+	if l.limit.Quota > 0 && l.limit.Window > 0 && len(l.sent) == l.limit.Quota {
+		at := now.Add(wait)
+		if free := l.sent[l.head].Add(l.limit.Window); free.After(at) {
+			if !delayed {
+				return 0, false
+			}
+			wait = free.Sub(now)
+		}
+	}
+	return wait, true
+}
+
+// charge the message reserved at now and sent after wait,
+// delayed messages are charged at the time they will be sent, lock held by caller
+func (l *limiter) charge(now time.Time, wait time.Duration) {
+	if l.limit.Quota > 0 && l.limit.Window > 0 {
+		at := now.Add(wait)
+		if len(l.sent) < l.limit.Quota {
+			l.sent = append(l.sent, at)
+		} else {
+			l.sent[l.head] = at
+			l.head = (l.head + 1) % l.limit.Quota
+		}
+	}
+	if l.limit.Rate > 0 {
+		l.tokens--
+	}
+}
+
+// take one message at now, the delay before sending it or false when rejected
+func (l *limiter) take(now time.Time) (time.Duration, bool) {
+	l.m.Lock()
+	defer l.m.Unlock()
+
+	wait, ok := l.reserve(now)
+	if ok {
+		l.charge(now, wait)
+	}
+	return wait, ok
+}
+
+// throttle apply the send limit of the source and the receive limit of target to message,
+// the delay before sending it, ErrThrottled when rejected
+// both limits are checked before either is charged, so a message rejected by one costs nothing to the other
+// send limits are always locked before receive limits, the order keeps them from deadlocking
+func throttle(message *Message, target *module) (time.Duration, error) {
+	var send *limiter
+	if message.Source != 0 {
+		if v, ok := modules.Load(message.Source); ok {
+			send = v.(*module).sendLimit
+		}
+	}
+	receive := target.receiveLimit
+	if send == nil && receive == nil {
+		return 0, nil
+	}
+	if send != nil {
+		send.m.Lock()
+		defer send.m.Unlock()
+	}
+	if receive != nil {
+		receive.m.Lock()
+		defer receive.m.Unlock()
+	}
+
+	t := now()
+	var sendWait, receiveWait time.Duration
+	if send != nil {
+		var ok bool
+		if sendWait, ok = send.reserve(t); !ok {
+			atomic.AddUint64(&metricsOf(message.Source).throttled, 1)
+			return 0, fmt.Errorf("%w: send limit of module %d", ErrThrottled, message.Source)
+		}
+	}
+	if receive != nil {
+		var ok bool
+		if receiveWait, ok = receive.reserve(t.Add(sendWait)); !ok {
+			atomic.AddUint64(&metricsOf(target.id).throttled, 1)
+			return 0, fmt.Errorf("%w: receive limit of module %d", ErrThrottled, target.id)
+		}
+	}
+
+	if send != nil {
+		send.charge(t, sendWait)
+		if sendWait > 0 {
+			atomic.AddUint64(&metricsOf(message.Source).delayed, 1)
+		}
+	}
+	if receive != nil {
+		receive.charge(t.Add(sendWait), receiveWait)
+		if receiveWait > 0 {
+			atomic.AddUint64(&metricsOf(target.id).delayed, 1)
+		}
+	}
+	return sendWait + receiveWait, nil
+}
@@ -0,0 +1,83 @@
+package core
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLimiter(t *testing.T) {
+	type step struct {
+		at   time.Duration
+		wait time.Duration
+		ok   bool
+	}
+	tests := []struct {
+		name  string
+		limit Limit
+		steps []step
+	}{
+		{"rate rejected", Limit{Rate: 1, Burst: 2}, []step{
+			{0, 0, true}, {0, 0, true}, {0, 0, false},
+			{500 * time.Millisecond, 0, false}, {time.Second, 0, true}, {time.Second, 0, false},
+		}},
+		{"rate delayed", Limit{Rate: 2, Burst: 1, Policy: ThrottleDelay}, []step{
+			{0, 0, true}, {0, 500 * time.Millisecond, true}, {0, time.Second, true},
+			// the delayed messages are charged when sent
+			{time.Second, 500 * time.Millisecond, true},
+		}},
+		{"quota rejected", Limit{Quota: 2, Window: 10 * time.Second}, []step{
+			{0, 0, true}, {time.Second, 0, true}, {5 * time.Second, 0, false},
+			{10 * time.Second, 0, true}, {10 * time.Second, 0, false}, {11 * time.Second, 0, true},
+		}},
+		{"quota delayed", Limit{Quota: 2, Window: 10 * time.Second, Policy: ThrottleDelay}, []step{
+			{0, 0, true}, {time.Second, 0, true}, {5 * time.Second, 5 * time.Second, true},
+			{5 * time.Second, 6 * time.Second, true},
+		}},
+		{"rate and quota", Limit{Rate: 10, Burst: 10, Quota: 3, Window: time.Minute}, []step{
+			{0, 0, true}, {0, 0, true}, {0, 0, true}, {0, 0, false}, {time.Minute, 0, true},
+		}},
+	}
+	t0 := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, tt := range tests {
+		l := newLimiter(tt.limit)
+		for i, s := range tt.steps {
+			wait, ok := l.take(t0.Add(s.at))
+			if wait != s.wait || ok != s.ok {
+				t.Errorf("%s: step %d at %s = %s %v, want %s %v", tt.name, i, s.at, wait, ok, s.wait, s.ok)
+			}
+		}
+	}
+}
+
+func TestThrottleRejectedByTarget(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	newTestClock(t)
+
+	InstallModule(1, make(chan Message, 10), WithSendLimit(Limit{Rate: 1, Burst: 2}))
+	InstallModule(2, make(chan Message, 10), WithReceiveLimit(Limit{Rate: 1, Burst: 1}))
+	InstallModule(3, make(chan Message, 10))
+
+	tests := []struct {
+		target int64
+		ok     bool
+	}{
+		{2, true},
+		// rejected by the receive limit, the send token of 1 is kept
+		{2, false},
+		{3, true},
+		// the send limit used up
+		{3, false},
+	}
+	for i, tt := range tests {
+		if ok := SendMessage(WithSource(1), WithIdInt64(tt.target)); ok != tt.ok {
+			t.Errorf("message %d from 1 to %d sent %v, want %v", i, tt.target, ok, tt.ok)
+		}
+	}
+	for _, s := range Stats().Modules {
+		want := map[int64]uint64{1: 1, 2: 1, 3: 0}[s.Id]
+		if s.Throttled != want {
+			t.Errorf("module %d throttled %d, want %d", s.Id, s.Throttled, want)
+		}
+	}
+}
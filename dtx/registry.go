@@ -0,0 +1,180 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNameConflict another module installed with the same name
+var ErrNameConflict = errors.New("core: module name already installed")
+
+// registry names of the installed modules
+var registry = struct {
+	m     sync.Mutex
+	names map[string]int64
+}{names: make(map[string]int64)}
+
+// ModuleInfo description of the installed module
+type ModuleInfo struct {
+	Id      int64  `json:"id"`
+	Name    string `json:"name,omitempty"`
+	Version string `json:"version,omitempty"`
+	// Capabilities capability names and their versions
+	Capabilities map[string]string `json:"capabilities,omitempty"`
+	Remote       bool              `json:"remote"`
+}
+
+// WithName install the module under the name, unique in kernel
+// messages can be sent to it WithIdName
+func WithName(name string) installOption {
+	return func(m *module) {
+		m.name = name
+	}
+}
+
+// WithVersion version of the module
+func WithVersion(version string) installOption {
+	return func(m *module) {
+		m.version = version
+	}
+}
+
+// WithCapability declare a capability the module provides and its version
+func WithCapability(capability, version string) installOption {
+	return func(m *module) {
+		if m.capabilities == nil {
+			m.capabilities = make(map[string]string)
+		}
+		m.capabilities[capability] = version
+	}
+}
+
+// WithIdName send the message to the module installed with the name
+func WithIdName(name string) sendOption {
+	return func(o *option) {
+		o.Name = name
+	}
+}
+
+// info description of the module
+func (m *module) info() ModuleInfo {
+	i := ModuleInfo{
+		Id:      m.id,
+		Name:    m.name,
+		Version: m.version,
+		Remote:  m.remote != nil,
+	}
+	if len(m.capabilities) > 0 {
+		i.Capabilities = make(map[string]string, len(m.capabilities))
+		for c, v := range m.capabilities {
+			i.Capabilities[c] = v
+		}
+	}
+	return i
+}
+
+// claim the name of module, false when used by another module, lock held by caller
+func claim(m *module) bool {
+	if m.name == "" {
+		return true
+	}
+	if id, ok := registry.names[m.name]; ok && id != m.id {
+		return false
+	}
+	registry.names[m.name] = m.id
+	return true
+}
+
+// forget the name of the uninstalled module
+func forget(m *module) {
+	if m.name == "" {
+		return
+	}
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	if registry.names[m.name] == m.id {
+		delete(registry.names, m.name)
+	}
+}
+
+// Resolve the identifier of the module installed with the name
+func Resolve(name string) (int64, bool) {
+	registry.m.Lock()
+	defer registry.m.Unlock()
+
+	id, ok := registry.names[name]
+	return id, ok
+}
+
+// Lookup the module installed with the name
+func Lookup(name string) (ModuleInfo, bool) {
+	id, ok := Resolve(name)
+	if !ok {
+		return ModuleInfo{}, false
+	}
+	v, ok := modules.Load(id)
+	if !ok {
+		return ModuleInfo{}, false
+	}
+	return v.(*module).info(), true
+}
+
+// Discover the modules providing the capability whose version matches,
+// "" matches any version and "1.2" matches "1.2" and "1.2.x"
+func Discover(capability, version string) []ModuleInfo {
+	var found []ModuleInfo
+	for _, i := range Modules() {
+		v, ok := i.Capabilities[capability]
+		if ok && versionMatch(version, v) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// versionMatch whether version satisfies the constraint
+func versionMatch(constraint, version string) bool {
+	return constraint == "" || version == constraint || strings.HasPrefix(version, constraint+".")
+}
+
+// Modules description of all installed modules ordered by identifier
+func Modules() []ModuleInfo {
+	var list []ModuleInfo
+	modules.Range(func(key, value interface{}) bool {
+		list = append(list, value.(*module).info())
+		return true
+	})
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Id < list[j].Id
+	})
+	return list
+}
+
+// String one line description used when logging
+func (i ModuleInfo) String() string {
+	var b strings.Builder
+	_, _ = fmt.Fprintf(&b, "module[id: %d", i.Id)
+	if i.Name != "" {
+		_, _ = fmt.Fprintf(&b, ", name: %s", i.Name)
+	}
+	if i.Version != "" {
+		_, _ = fmt.Fprintf(&b, ", version: %s", i.Version)
+	}
+	if len(i.Capabilities) > 0 {
+		caps := make([]string, 0, len(i.Capabilities))
+		for c, v := range i.Capabilities {
+			caps = append(caps, c+"@"+v)
+		}
+		sort.Strings(caps)
+		_, _ = fmt.Fprintf(&b, ", capabilities: %s", strings.Join(caps, ","))
+	}
+	if i.Remote {
+		b.WriteString(", remote")
+	}
+	b.WriteString("]")
+	return b.String()
+}
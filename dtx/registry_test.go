@@ -0,0 +1,116 @@
+package core
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestVersionMatch(t *testing.T) {
+	tests := []struct {
+		constraint, version string
+		want                bool
+	}{
+		{"", "1.0", true},
+		{"1.2", "1.2", true},
+		{"1.2", "1.2.3", true},
+		{"1.2", "1.20", false},
+		{"1.2", "1.3", false},
+		{"1", "1.2.3", true},
+		{"1.2.3", "1.2", false},
+	}
+	for _, tt := range tests {
+		if got := versionMatch(tt.constraint, tt.version); got != tt.want {
+			t.Errorf("versionMatch(%q, %q) = %v, want %v", tt.constraint, tt.version, got, tt.want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	InstallModule(1, make(chan Message, 1), WithName("orders"), WithVersion("2.1.0"),
+		WithCapability("payment", "1.2.0"), WithCapability("refund", "1.0"))
+	InstallModule(2, make(chan Message, 1), WithName("billing"), WithCapability("payment", "2.0"))
+	InstallModule(3, make(chan Message, 1))
+
+	if InstallModule(4, make(chan Message, 1), WithName("orders")) {
+		t.Error("module installed with the name of another")
+	}
+	if id, ok := Resolve("orders"); !ok || id != 1 {
+		t.Errorf("Resolve(orders) = %d, %v", id, ok)
+	}
+	if i, ok := Lookup("orders"); !ok || i.Version != "2.1.0" || i.Capabilities["refund"] != "1.0" {
+		t.Errorf("Lookup(orders) = %+v, %v", i, ok)
+	}
+
+	tests := []struct {
+		capability, version string
+		want                []int64
+	}{
+		{"payment", "", []int64{1, 2}},
+		{"payment", "1", []int64{1}},
+		{"payment", "2.0", []int64{2}},
+		{"payment", "3", nil},
+		{"refund", "1.0", []int64{1}},
+		{"unknown", "", nil},
+	}
+	for _, tt := range tests {
+		var got []int64
+		for _, i := range Discover(tt.capability, tt.version) {
+			got = append(got, i.Id)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Discover(%q, %q) = %v, want %v", tt.capability, tt.version, got, tt.want)
+		}
+	}
+
+	// the name is released with the module
+	UninstallModule(1)
+	if _, ok := Resolve("orders"); ok {
+		t.Error("name kept after uninstalled")
+	}
+	if !InstallModule(4, make(chan Message, 1), WithName("orders")) {
+		t.Error("released name not reused")
+	}
+}
+
+func TestSendByName(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	queue := make(chan Message, 1)
+	InstallModule(7, queue, WithName("mailer"))
+
+	tests := []struct {
+		name string
+		sent bool
+	}{
+		{"mailer", true},
+		{"unknown", false},
+	}
+	for _, tt := range tests {
+		if sent := SendMessage(WithIdName(tt.name), WithData(tt.name)); sent != tt.sent {
+			t.Errorf("send to %s = %v, want %v", tt.name, sent, tt.sent)
+		}
+	}
+	for Poll() {
+	}
+	if m := <-queue; m.Identifier != 7 || m.Data != "mailer" {
+		t.Errorf("received %+v", m)
+	}
+}
+
+func TestModuleInfoString(t *testing.T) {
+	tests := []struct {
+		info ModuleInfo
+		want string
+	}{
+		{ModuleInfo{Id: 1}, "module[id: 1]"},
+		{ModuleInfo{Id: 2, Name: "a", Version: "1.0", Capabilities: map[string]string{"y": "2", "x": "1"}, Remote: true},
+			"module[id: 2, name: a, version: 1.0, capabilities: x@1,y@2, remote]"},
+	}
+	for _, tt := range tests {
+		if got := tt.info.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,367 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TaskId stable handle of a scheduled task, never reused in one process
+type TaskId int64
+
+// Schedule decides when a task runs next
+// Next returns the zero time when the task should not run anymore
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// everySchedule run the task at a fixed interval
+type everySchedule struct {
+	interval time.Duration
+}
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(s.interval)
+}
+
+// onceSchedule run the task one time at the given moment
+type onceSchedule struct {
+	at   time.Time
+	done bool
+}
+
+func (s *onceSchedule) Next(t time.Time) time.Time {
+	if s.done {
+		return time.Time{}
+	}
+	s.done = true
+	if s.at.Before(t) {
+		return t
+	}
+	return s.at
+}
+
+// Every run the task each interval, interval less than 1ms will be 1ms
+func Every(interval time.Duration) Schedule {
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return everySchedule{interval: interval}
+}
+
+// After run the task one time after the delay
+func After(delay time.Duration) Schedule {
+	return &onceSchedule{at: now().Add(delay)}
+}
+
+// At run the task one time at the given moment
+func At(t time.Time) Schedule {
+	return &onceSchedule{at: t}
+}
+
+// task inner struct of a scheduled task
+type task struct {
+	id       TaskId
+	name     string
+	schedule Schedule
+	fn       func(ctx context.Context)
+	jitter   time.Duration
+	timeout  time.Duration
+	// at scheduled time of the next run, next adds the jitter
+	at       time.Time
+	next     time.Time
+	running  int32
+	index    int
+	duration *histogram
+	// internal timers of the kernel, not listed in Stats
+	internal bool
+}
+
+// taskHeap tasks ordered by the next running time
+type taskHeap []*task
+
+func (h taskHeap) Len() int { return len(h) }
+
+func (h taskHeap) Less(i, j int) bool { return h[i].next.Before(h[j].next) }
+
+func (h taskHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *taskHeap) Push(x interface{}) {
+	t := x.(*task)
+	t.index = len(*h)
+	*h = append(*h, t)
+}
+
+func (h *taskHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	t := old[n-1]
+	old[n-1] = nil
+	t.index = -1
+	*h = old[:n-1]
+	return t
+}
+
+// scheduler run tasks outside the message routing goroutine
+type scheduler struct {
+	m     sync.Mutex
+	seq   int64
+	tasks map[TaskId]*task
+	queue taskHeap
+	wake  chan struct{}
+}
+
+// maxSkippedRuns runs missed skipped one by one to keep the phase of the schedule
+const maxSkippedRuns = 1000
+
+// sched kernel scheduler
+var sched = &scheduler{
+	tasks: make(map[TaskId]*task),
+	wake:  make(chan struct{}, 1),
+}
+
+type taskOption func(*task)
+
+// WithTaskName name used when logging the task
+func WithTaskName(name string) taskOption {
+	return func(t *task) {
+		t.name = name
+	}
+}
+
+// WithJitter delay each run by a random duration in [0, jitter)
+func WithJitter(jitter time.Duration) taskOption {
+	return func(t *task) {
+		t.jitter = jitter
+	}
+}
+
+// WithTimeout cancel the task context after timeout
+// the task should return when ctx.Done() is closed
+func WithTimeout(timeout time.Duration) taskOption {
+	return func(t *task) {
+		t.timeout = timeout
+	}
+}
+
+// internalTask timer of the kernel hidden from Stats
+func internalTask() taskOption {
+	return func(t *task) {
+		t.internal = true
+	}
+}
+
+// ScheduleTask add task to the kernel scheduler and return the task handle
+func ScheduleTask(s Schedule, fn func(ctx context.Context), opts ...taskOption) TaskId {
+	t := &task{
+		schedule: s,
+		fn:       fn,
+		index:    -1,
+		duration: newHistogram(),
+	}
+	for _, o := range opts {
+		o(t)
+	}
+
+	sched.m.Lock()
+	sched.seq++
+	t.id = TaskId(sched.seq)
+	if t.name == "" {
+		t.name = fmt.Sprintf("task-%d", t.id)
+	}
+	sched.tasks[t.id] = t
+	t0 := now()
+	sched.push(t, t0, t0)
+	sched.m.Unlock()
+
+	sched.notify()
+	return t.id
+}
+
+// ScheduleCron add task running with the cron expression
+func ScheduleCron(expr string, fn func(ctx context.Context), opts ...taskOption) (TaskId, error) {
+	s, e := Cron(expr)
+	if e != nil {
+		return 0, e
+	}
+	return ScheduleTask(s, fn, opts...), nil
+}
+
+// CancelTask remove task from the scheduler, running instance is not interrupted
+func CancelTask(id TaskId) bool {
+	sched.m.Lock()
+	defer sched.m.Unlock()
+
+	t, ok := sched.tasks[id]
+	if !ok {
+		return false
+	}
+	delete(sched.tasks, id)
+	if t.index >= 0 {
+		heap.Remove(&sched.queue, t.index)
+	}
+	return true
+}
+
+// push compute the next running time after the previous one and put the task into queue,
+// so that the runs do not drift, the runs missed before now are skipped
+// task without next running time will be removed
+func (s *scheduler) push(t *task, previous, now time.Time) {
+	at := t.schedule.Next(previous)
+	for i := 0; !at.IsZero() && at.Before(now); i++ {
+		if i == maxSkippedRuns {
+			at = t.schedule.Next(now)
+			break
+		}
+		at = t.schedule.Next(at)
+	}
+	if at.IsZero() {
+		delete(s.tasks, t.id)
+		return
+	}
+	t.at, t.next = at, at
+	if t.jitter > 0 {
+		t.next = at.Add(time.Duration(rand.Int63n(int64(t.jitter))))
+	}
+	heap.Push(&s.queue, t)
+}
+
+// notify wake up the scheduler loop to recompute the timer
+func (s *scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// due remove the tasks whose running time reached and schedule their next runs
+func (s *scheduler) due(now time.Time) []*task {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var due []*task
+	for len(s.queue) > 0 && !s.queue[0].next.After(now) {
+		t := heap.Pop(&s.queue).(*task)
+		due = append(due, t)
+		s.push(t, t.at, now)
+	}
+	return due
+}
+
+// next running time of the first task, false when no task
+func (s *scheduler) next() (time.Time, bool) {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	if len(s.queue) == 0 {
+		return time.Time{}, false
+	}
+	return s.queue[0].next, true
+}
+
+// reset cancel all tasks, the ids are not reused
+func (s *scheduler) reset() {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	s.tasks = make(map[TaskId]*task)
+	s.queue = nil
+}
+
+// runDue execute all tasks whose running time reached
+func (s *scheduler) runDue(now time.Time) {
+	for _, t := range s.due(now) {
+		s.execute(t)
+	}
+}
+
+// execute run task in its own goroutine, overlapped runs are skipped
+func (s *scheduler) execute(t *task) {
+	if !atomic.CompareAndSwapInt32(&t.running, 0, 1) {
+		Logger.Warn("Task still running, skipped", zap.String("task", t.name))
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&t.running, 0)
+		s.call(t)
+	}()
+}
+
+// call run task on the calling goroutine with its timeout, panics are recovered
+// call returns when the timeout passed even if the task ignores its context,
+// the next run is not blocked by the hung one
+func (s *scheduler) call(t *task) {
+	var (
+		ctx    context.Context
+		cancel context.CancelFunc
+	)
+	if t.timeout > 0 {
+		ctx, cancel = context.WithTimeout(context.Background(), t.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(context.Background())
+	}
+	defer cancel()
+
+	begin := time.Now()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer func() {
+			t.duration.observe(time.Since(begin))
+		}()
+		defer func() {
+			if r := recover(); r != nil {
+				Logger.Error("Task panic", zap.String("task", t.name), zap.Any("panic", r), zap.Stack("stack"))
+			}
+		}()
+		t.fn(ctx)
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		Logger.Warn("Task exceeded timeout, left running in background", zap.String("task", t.name), zap.Duration("timeout", t.timeout))
+	}
+}
+
+// run the scheduler loop until stop closed
+func (s *scheduler) run(stop <-chan struct{}) {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+
+	for {
+		s.m.Lock()
+		wait := time.Hour
+		if len(s.queue) > 0 {
+			wait = s.queue[0].next.Sub(now())
+		}
+		s.m.Unlock()
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+
+		select {
+		case <-stop:
+			return
+		case <-s.wake:
+		case <-timer.C:
+			s.runDue(now())
+		}
+	}
+}
@@ -0,0 +1,150 @@
+package core
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testClock virtual kernel clock of the tests, restored to the wall clock when the test ends
+type testClock struct {
+	m   sync.Mutex
+	now time.Time
+}
+
+func newTestClock(t *testing.T) *testClock {
+	c := &testClock{now: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	SetClock(c)
+	t.Cleanup(func() { SetClock(nil) })
+	return c
+}
+
+func (c *testClock) Now() time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.now
+}
+
+func (c *testClock) advance(d time.Duration) time.Time {
+	c.m.Lock()
+	defer c.m.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+func scheduleTest(t *testing.T, s Schedule, fn func(ctx context.Context), opts ...taskOption) TaskId {
+	id := ScheduleTask(s, fn, opts...)
+	t.Cleanup(func() { CancelTask(id) })
+	return id
+}
+
+func nextRun(t *testing.T, id TaskId) time.Time {
+	t.Helper()
+	sched.m.Lock()
+	defer sched.m.Unlock()
+	task, ok := sched.tasks[id]
+	if !ok {
+		t.Fatalf("task %d not scheduled", id)
+	}
+	return task.next
+}
+
+func TestScheduleNext(t *testing.T) {
+	c := newTestClock(t)
+	t0 := c.Now()
+	var runs int32
+	id := scheduleTest(t, Every(10*time.Second), func(context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+
+	tests := []struct {
+		advance time.Duration
+		runs    int32
+		next    time.Duration
+	}{
+		{5 * time.Second, 0, 10 * time.Second},
+		// fired late, the next run keeps the interval from the scheduled time
+		{8 * time.Second, 1, 20 * time.Second},
+		{7 * time.Second, 2, 30 * time.Second},
+		// runs missed are skipped, not fired in a burst
+		{45 * time.Second, 3, 70 * time.Second},
+	}
+	for _, tt := range tests {
+		c.advance(tt.advance)
+		Fire()
+		if got := atomic.LoadInt32(&runs); got != tt.runs {
+			t.Errorf("at %s: %d runs, want %d", c.Now().Sub(t0), got, tt.runs)
+		}
+		if got := nextRun(t, id); !got.Equal(t0.Add(tt.next)) {
+			t.Errorf("at %s: next run at %s, want %s", c.Now().Sub(t0), got.Sub(t0), tt.next)
+		}
+	}
+}
+
+func TestScheduleOnce(t *testing.T) {
+	c := newTestClock(t)
+	var runs int32
+	id := scheduleTest(t, After(time.Minute), func(context.Context) {
+		atomic.AddInt32(&runs, 1)
+	})
+	c.advance(2 * time.Minute)
+	Fire()
+	c.advance(2 * time.Minute)
+	Fire()
+	if runs != 1 {
+		t.Errorf("%d runs, want 1", runs)
+	}
+	if CancelTask(id) {
+		t.Error("task kept after its only run")
+	}
+}
+
+func TestScheduleTimeout(t *testing.T) {
+	c := newTestClock(t)
+	release := make(chan struct{})
+	defer close(release)
+
+	var runs int32
+	scheduleTest(t, Every(time.Second), func(context.Context) {
+		// ignores its context
+		atomic.AddInt32(&runs, 1)
+		<-release
+	}, WithTimeout(20*time.Millisecond))
+
+	for i := 0; i < 3; i++ {
+		c.advance(time.Second)
+		start := time.Now()
+		Fire()
+		if d := time.Since(start); d > time.Second {
+			t.Fatalf("Fire blocked %s by the hung task", d)
+		}
+	}
+	if got := atomic.LoadInt32(&runs); got != 3 {
+		t.Errorf("%d runs, want 3: the hung run blocked the next ones", got)
+	}
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	newTestClock(t)
+	release := make(chan struct{})
+	var runs int32
+	id := scheduleTest(t, Every(time.Hour), func(context.Context) {
+		atomic.AddInt32(&runs, 1)
+		<-release
+	})
+	sched.m.Lock()
+	task := sched.tasks[id]
+	sched.m.Unlock()
+
+	sched.execute(task)
+	waitFor(t, "first run", func() bool { return atomic.LoadInt32(&runs) == 1 })
+	// still running, skipped
+	sched.execute(task)
+	close(release)
+	waitFor(t, "first run done", func() bool { return atomic.LoadInt32(&task.running) == 0 })
+	if got := atomic.LoadInt32(&runs); got != 1 {
+		t.Errorf("%d runs, want 1", got)
+	}
+}
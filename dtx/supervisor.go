@@ -0,0 +1,359 @@
+package core
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Handler deal with the message delivered to the module
+// m.Context() carries the deadline and trace of the message
+type Handler func(m Message)
+
+// Strategy how the supervisor restarts modules after a handler panic
+type Strategy int
+
+const (
+	// OneForOne only the module that panicked is restarted
+	OneForOne Strategy = iota
+	// OneForAll all modules in the same group are restarted
+	OneForAll
+)
+
+// supervised inner struct of a module running under supervisor
+type supervised struct {
+	id          int64
+	group       string
+	strategy    Strategy
+	factory     func() Handler
+	queueSize   int
+	maxRestarts int
+	window      time.Duration
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+	// workers number of the handlers dealing with messages concurrently
+	workers int
+
+	installs []installOption
+
+	lanes *priorityQueue
+	// backlog messages taken out of lanes before the exit signal, dealt before it
+	backlog []Message
+	restart int32
+	// generation increased when the group asks the pool to restart
+	generation int64
+	// failed set when the module exhausted its restarts
+	failed int32
+
+	m        sync.Mutex
+	restarts []time.Time
+	// queues of the workers when running WithWorkers
+	queues []chan Message
+}
+
+var (
+	// groups supervised modules grouped by name, used by OneForAll
+	groups = struct {
+		m sync.Mutex
+		g map[string][]*supervised
+	}{g: make(map[string][]*supervised)}
+
+	// failedModules modules which exhausted their restarts
+	failedModules sync.Map
+)
+
+type superviseOption func(*supervised)
+
+// WithStrategy restart strategy, default OneForOne
+func WithStrategy(strategy Strategy) superviseOption {
+	return func(s *supervised) {
+		s.strategy = strategy
+	}
+}
+
+// WithGroup name of the group the module belongs to, used by OneForAll
+func WithGroup(group string) superviseOption {
+	return func(s *supervised) {
+		s.group = group
+	}
+}
+
+// WithMaxRestarts the module is marked as failed after more than maxRestarts restarts in window
+func WithMaxRestarts(maxRestarts int, window time.Duration) superviseOption {
+	return func(s *supervised) {
+		s.maxRestarts = maxRestarts
+		s.window = window
+	}
+}
+
+// WithBackoff wait before restart, doubled after each consecutive panic up to max
+func WithBackoff(min, max time.Duration) superviseOption {
+	return func(s *supervised) {
+		s.minBackoff = min
+		s.maxBackoff = max
+	}
+}
+
+// WithQueueSize size of each priority lane created for the module
+func WithQueueSize(size int) superviseOption {
+	return func(s *supervised) {
+		s.queueSize = size
+	}
+}
+
+// WithInstallOptions options used when installing the supervised module
+func WithInstallOptions(opts ...installOption) superviseOption {
+	return func(s *supervised) {
+		s.installs = append(s.installs, opts...)
+	}
+}
+
+// InstallHandler install module whose messages are dealt by handler under supervisor
+func InstallHandler(id int64, handler Handler, opts ...superviseOption) bool {
+	return Supervise(id, func() Handler {
+		return handler
+	}, opts...)
+}
+
+// Supervise install module under supervisor, factory is called to create
+// a fresh handler when the module starts and each time it restarts
+func Supervise(id int64, factory func() Handler, opts ...superviseOption) bool {
+	s := newSupervised(id, factory, opts)
+	if !InstallModule(id, nil, s.options()...) {
+		return false
+	}
+	s.start()
+	return true
+}
+
+// newSupervised supervisor of the module, not yet installed
+func newSupervised(id int64, factory func() Handler, opts []superviseOption) *supervised {
+	s := &supervised{
+		id:          id,
+		strategy:    OneForOne,
+		factory:     factory,
+		queueSize:   1000,
+		maxRestarts: 5,
+		window:      time.Minute,
+		minBackoff:  100 * time.Millisecond,
+		maxBackoff:  10 * time.Second,
+		workers:     1,
+	}
+	for _, o := range opts {
+		o(s)
+	}
+	s.lanes = newPriorityQueue(s.queueSize)
+	return s
+}
+
+// options install options of the supervised module
+func (s *supervised) options() []installOption {
+	return append(s.installs, withLanes(s.lanes), withDispatched(s.dispatched))
+}
+
+// dispatched take the messages waiting in the queues of the workers
+func (s *supervised) dispatched() []Message {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	var pending []Message
+	for _, q := range s.queues {
+		for n := len(q); n > 0; n-- {
+			select {
+			case m, ok := <-q:
+				if ok {
+					pending = append(pending, m)
+				}
+			default:
+			}
+		}
+	}
+	return pending
+}
+
+// start run the installed module under supervisor
+func (s *supervised) start() {
+	failedModules.Delete(s.id)
+
+	if s.group != "" {
+		groups.m.Lock()
+		groups.g[s.group] = append(groups.g[s.group], s)
+		groups.m.Unlock()
+	}
+
+	if s.workers > 1 {
+		go s.runPool()
+	} else {
+		go s.run()
+	}
+}
+
+// ModuleFailed whether the module exhausted its restarts and was uninstalled
+func ModuleFailed(id int64) bool {
+	_, ok := failedModules.Load(id)
+	return ok
+}
+
+// run deal with the messages until exit signal received or module failed
+func (s *supervised) run() {
+	defer s.leaveGroup()
+
+	handler := s.factory()
+	backoff := s.minBackoff
+
+	for {
+		m, ok := s.next()
+		if !ok {
+			return
+		}
+		// restart requested by the other module of the group
+		if atomic.CompareAndSwapInt32(&s.restart, 1, 0) {
+			handler = s.factory()
+			Logger.Info(fmt.Sprintf("Module[id: %d] restarted by group: %s", s.id, s.group))
+		}
+
+		if s.invoke(handler, m) {
+			backoff = s.minBackoff
+		} else {
+			if !s.allowRestart() {
+				failedModules.Store(s.id, struct{}{})
+				s.uninstall()
+				Logger.Error(fmt.Sprintf("Module[id: %d] failed after %d restarts in %s", s.id, s.maxRestarts, s.window))
+				s.discard()
+				return
+			}
+			time.Sleep(backoff)
+			if backoff *= 2; backoff > s.maxBackoff {
+				backoff = s.maxBackoff
+			}
+			handler = s.factory()
+			Logger.Info(fmt.Sprintf("Module[id: %d] restarted", s.id))
+			if s.strategy == OneForAll {
+				s.restartGroup()
+			}
+		}
+		// IsExitSignal the module has been uninstalled by kernel
+		if IsExitSignal(&m) {
+			return
+		}
+	}
+}
+
+// next the message to deal with, blocks until any message arrived
+// the exit signal uses the high lane, the messages left in the lower lanes were
+// routed before it and are dealt first
+func (s *supervised) next() (Message, bool) {
+	if len(s.backlog) == 0 {
+		m, ok := s.lanes.get()
+		if !ok || !IsExitSignal(&m) {
+			return m, ok
+		}
+		s.backlog = append(s.lanes.drain(), m)
+	}
+	m := s.backlog[0]
+	s.backlog = s.backlog[1:]
+	return m, true
+}
+
+// discard the messages left in the lanes of the failed module
+func (s *supervised) discard() {
+	left := append(s.backlog, s.lanes.drain()...)
+	s.backlog = nil
+	for _, m := range left {
+		// Id 0 the exit signal
+		if m.Id != 0 && !IsExitSignal(&m) {
+			settle(m, ErrNotInstalled)
+		}
+	}
+}
+
+// invoke call handler with recovery, false when handler panicked
+func (s *supervised) invoke(handler Handler, m Message) (ok bool) {
+	ctx, cancel := m.newContext()
+	m.ctx = ctx
+	defer cancel()
+
+	begin := time.Now()
+	defer func() {
+		metricsOf(s.id).latency.observe(time.Since(begin))
+		if r := recover(); r != nil {
+			Logger.Error("Module handler panic",
+				zap.Int64("module", s.id),
+				zap.Any("message", m),
+				zap.Any("panic", r),
+				zap.Stack("stack"),
+			)
+			bury(m, fmt.Errorf("%w: %v", ErrHandlerPanic, r))
+			ok = false
+		}
+	}()
+	handler(m)
+	return true
+}
+
+// uninstall the module unless it has been replaced by SwapHandler
+func (s *supervised) uninstall() {
+	swapping.Lock()
+	defer swapping.Unlock()
+
+	if v, ok := modules.Load(s.id); ok && v.(*module).lanes == s.lanes {
+		modules.Delete(s.id)
+		forget(v.(*module))
+	}
+}
+
+// allowRestart record the restart, false when restarts in window exceeded
+func (s *supervised) allowRestart() bool {
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	var kept []time.Time
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.window {
+			kept = append(kept, t)
+		}
+	}
+	s.restarts = append(kept, now)
+	return len(s.restarts) <= s.maxRestarts
+}
+
+// restartGroup ask the other modules in group to recreate their handlers
+// before dealing with their next message
+func (s *supervised) restartGroup() {
+	if s.group == "" {
+		return
+	}
+	groups.m.Lock()
+	defer groups.m.Unlock()
+
+	for _, o := range groups.g[s.group] {
+		if o == s {
+			continue
+		}
+		atomic.StoreInt32(&o.restart, 1)
+	}
+}
+
+// leaveGroup remove the module from its group when exited
+func (s *supervised) leaveGroup() {
+	if s.group == "" {
+		return
+	}
+	groups.m.Lock()
+	defer groups.m.Unlock()
+
+	members := groups.g[s.group]
+	for i, o := range members {
+		if o == s {
+			groups.g[s.group] = append(members[:i:i], members[i+1:]...)
+			break
+		}
+	}
+	if len(groups.g[s.group]) == 0 {
+		delete(groups.g, s.group)
+	}
+}
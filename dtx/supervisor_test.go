@@ -0,0 +1,136 @@
+package core
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// reasons count of the dead letters by reason
+func reasons() map[Reason]int {
+	letters, _ := DeadLetters(DeadLetterQuery{})
+	n := make(map[Reason]int)
+	for _, d := range letters {
+		n[d.Reason]++
+	}
+	return n
+}
+
+func TestSuperviseRestart(t *testing.T) {
+	tests := []struct {
+		name        string
+		maxRestarts int
+		panics      int
+		failed      bool
+		// factories handlers created, dealt messages dealt without panic
+		factories int32
+		dealt     int32
+		letters   map[Reason]int
+	}{
+		{"no panic", 1, 0, false, 1, 1, map[Reason]int{}},
+		{"recovered", 3, 2, false, 3, 1, map[Reason]int{ReasonFailed: 2}},
+		// the message after the last panic is left in the lanes of the failed module
+		{"failed", 1, 2, true, 2, 0, map[Reason]int{ReasonFailed: 2, ReasonNotInstalled: 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			var factories, dealt int32
+			Supervise(1, func() Handler {
+				atomic.AddInt32(&factories, 1)
+				return func(m Message) {
+					if m.Data == "panic" {
+						panic("boom")
+					}
+					atomic.AddInt32(&dealt, 1)
+				}
+			}, WithMaxRestarts(tt.maxRestarts, time.Minute), WithBackoff(time.Millisecond, time.Millisecond))
+
+			for i := 0; i < tt.panics; i++ {
+				SendMessage(WithIdInt64(1), WithData("panic"))
+			}
+			SendMessage(WithIdInt64(1), WithData("ok"))
+			for Poll() {
+			}
+
+			total := 0
+			for _, n := range tt.letters {
+				total += n
+			}
+			waitFor(t, "messages dealt", func() bool {
+				letters, _ := DeadLetters(DeadLetterQuery{})
+				return atomic.LoadInt32(&dealt) == tt.dealt && len(letters) == total
+			})
+			if got := reasons(); len(got) != len(tt.letters) || got[ReasonFailed] != tt.letters[ReasonFailed] ||
+				got[ReasonNotInstalled] != tt.letters[ReasonNotInstalled] {
+				t.Errorf("dead letters %v, want %v", got, tt.letters)
+			}
+			if got := atomic.LoadInt32(&factories); got != tt.factories {
+				t.Errorf("%d handlers created, want %d", got, tt.factories)
+			}
+			if ModuleFailed(1) != tt.failed {
+				t.Errorf("ModuleFailed = %v", !tt.failed)
+			}
+			if _, installed := modules.Load(int64(1)); installed == tt.failed {
+				t.Errorf("installed = %v", installed)
+			}
+		})
+	}
+}
+
+func TestSuperviseGroup(t *testing.T) {
+	tests := []struct {
+		name     string
+		strategy Strategy
+		// factories handlers of the other module created
+		factories int32
+	}{
+		{"one for one", OneForOne, 1},
+		{"one for all", OneForAll, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			opts := []superviseOption{WithGroup("g"), WithStrategy(tt.strategy), WithBackoff(time.Millisecond, time.Millisecond)}
+			InstallHandler(1, func(m Message) {
+				panic("boom")
+			}, opts...)
+			var factories, dealt int32
+			Supervise(2, func() Handler {
+				atomic.AddInt32(&factories, 1)
+				return func(m Message) {
+					atomic.AddInt32(&dealt, 1)
+				}
+			}, opts...)
+
+			SendMessage(WithIdInt64(1))
+			for Poll() {
+			}
+			waitFor(t, "panic", func() bool { return reasons()[ReasonFailed] == 1 })
+			SendMessage(WithIdInt64(2))
+			for Poll() {
+			}
+			waitFor(t, "message dealt", func() bool { return atomic.LoadInt32(&dealt) == 1 })
+			if got := atomic.LoadInt32(&factories); got != tt.factories {
+				t.Errorf("%d handlers of the other module created, want %d", got, tt.factories)
+			}
+		})
+	}
+}
+
+func TestSendMessagePanic(t *testing.T) {
+	Reset()
+	t.Cleanup(Reset)
+	InstallModule(1, make(chan Message, 1))
+	UseInterceptor(func(_ Stage, m *Message, next Invoker) error {
+		panic("interceptor")
+	})
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("panic of the interceptor swallowed")
+		}
+	}()
+	SendMessage(WithIdInt64(1))
+}
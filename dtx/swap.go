@@ -0,0 +1,289 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrNotPaused module must be paused before swapped
+var ErrNotPaused = errors.New("core: module not paused")
+
+var (
+	// pauses messages held for the paused modules
+	pauses = pauseTable{
+		paused:   make(map[int64]bool),
+		flushing: make(map[int64]bool),
+		buffers:  make(map[int64][]Message),
+	}
+
+	// routing messages put into inbox and not yet routed by the kernel goroutine
+	routing int64
+
+	// swapping serialize replacing and uninstalling the supervised modules
+	swapping sync.Mutex
+
+	// SnapshotWait max time TakeSnapshot waits for the message being routed
+	SnapshotWait = 100 * time.Millisecond
+)
+
+// pauseTable inner struct of the paused modules and their buffered messages
+type pauseTable struct {
+	m sync.Mutex
+	// all whole kernel paused by Snapshot
+	all    bool
+	paused map[int64]bool
+	// flushing modules whose buffered messages are being delivered,
+	// new messages are still buffered behind them
+	flushing map[int64]bool
+	buffers  map[int64][]Message
+}
+
+// hold buffer the message when its module paused, lock held by caller
+func (t *pauseTable) hold(m *Message) bool {
+	if !t.all && !t.paused[m.Identifier] && !t.flushing[m.Identifier] {
+		return false
+	}
+	t.buffers[m.Identifier] = append(t.buffers[m.Identifier], *m)
+	return true
+}
+
+// flush deliver the buffered messages of id in order until none left or paused again,
+// lock held by caller, released while delivering since putting into the module may block
+func (t *pauseTable) flush(id int64) int {
+	if t.flushing[id] {
+		// delivered by the other flush
+		return 0
+	}
+	t.flushing[id] = true
+	defer delete(t.flushing, id)
+
+	n := 0
+	for !t.all && !t.paused[id] {
+		buffered := t.buffers[id]
+		delete(t.buffers, id)
+		if len(buffered) == 0 {
+			break
+		}
+		n += len(buffered)
+
+		t.m.Unlock()
+		for i := range buffered {
+			settle(buffered[i], route(&buffered[i]))
+		}
+		t.m.Lock()
+	}
+	return n
+}
+
+// reset resume all modules and discard the buffered messages
+func (t *pauseTable) reset() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.all = false
+	t.paused = make(map[int64]bool)
+	t.flushing = make(map[int64]bool)
+	t.buffers = make(map[int64][]Message)
+}
+
+// Pause stop delivering messages to the module, messages are buffered until Resume
+// false when already paused
+func Pause(id int64) bool {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	if pauses.paused[id] {
+		return false
+	}
+	pauses.paused[id] = true
+	return true
+}
+
+// Resume deliver the buffered messages in order and continue delivering to the module
+// return the number of messages buffered, false when not paused
+func Resume(id int64) (int, bool) {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	if !pauses.paused[id] {
+		return 0, false
+	}
+	delete(pauses.paused, id)
+	if pauses.all {
+		return len(pauses.buffers[id]), true
+	}
+	return pauses.flush(id), true
+}
+
+// ResumeAll resume all paused modules and the kernel paused by Snapshot
+func ResumeAll() int {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	pauses.all = false
+	pauses.paused = make(map[int64]bool)
+	ids := make([]int64, 0, len(pauses.buffers))
+	for id := range pauses.buffers {
+		ids = append(ids, id)
+	}
+	n := 0
+	for _, id := range ids {
+		n += pauses.flush(id)
+	}
+	return n
+}
+
+// Paused whether the module is paused and the number of messages buffered
+func Paused(id int64) (int, bool) {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	return len(pauses.buffers[id]), pauses.all || pauses.paused[id]
+}
+
+// Swap replace the paused module with a new queue, messages still waiting in the queue
+// of the old module are moved before the buffered ones, Resume delivers them to the new queue
+// the lanes of old supervised module are closed so that it exits,
+// the old channel queue is left to its owner
+func Swap(id int64, queue chan Message, opts ...installOption) error {
+	return swap(newModule(id, queue, opts))
+}
+
+// SwapHandler replace the paused module with a new supervised handler, see Swap
+func SwapHandler(id int64, factory func() Handler, opts ...superviseOption) error {
+	s := newSupervised(id, factory, opts)
+	if e := swap(newModule(id, nil, s.options())); e != nil {
+		return e
+	}
+	s.start()
+	return nil
+}
+
+// swap install mod in place of the paused module
+func swap(mod *module) error {
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	if !pauses.all && !pauses.paused[mod.id] {
+		return ErrNotPaused
+	}
+
+	swapping.Lock()
+	defer swapping.Unlock()
+
+	v, ok := modules.Load(mod.id)
+	if !ok {
+		return ErrNotInstalled
+	}
+	old := v.(*module)
+
+	registry.m.Lock()
+	if registry.names[old.name] == old.id {
+		delete(registry.names, old.name)
+	}
+	if !claim(mod) {
+		claim(old)
+		registry.m.Unlock()
+		return ErrNameConflict
+	}
+	registry.m.Unlock()
+
+	if old.remote == nil {
+		pending := old.drain()
+		if len(pending) > 0 {
+			pauses.buffers[mod.id] = append(pending, pauses.buffers[mod.id]...)
+		}
+	}
+	modules.Store(mod.id, mod)
+	if old.lanes != nil {
+		old.lanes.close()
+	}
+	Logger.Info(fmt.Sprintf("Module[id: %d] swapped, %d messages buffered", mod.id, len(pauses.buffers[mod.id])))
+	return nil
+}
+
+// DelayedMessage message waiting for its delivery time in snapshot
+type DelayedMessage struct {
+	At      time.Time `json:"at"`
+	Message Message   `json:"message"`
+}
+
+// Snapshot pending messages taken out of kernel, can be encoded as JSON
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	// Pending messages not yet dealt by modules, in order for each module
+	Pending []Message        `json:"pending"`
+	Delayed []DelayedMessage `json:"delayed"`
+}
+
+// TakeSnapshot pause the whole kernel and take out the messages of inbox, the delay queue,
+// the queues of local modules, the worker queues of modules running WithWorkers included,
+// and the paused buffers, only the messages handlers already began are left to them
+// the kernel stays paused and the messages taken out are kept by the snapshot only:
+// Restore it and call ResumeAll to continue in the process, e.g. after Swap,
+// or Restore the snapshot into the kernel of a new process
+// messages sent while taking snapshot may stay buffered in kernel
+func TakeSnapshot() *Snapshot {
+	pauses.m.Lock()
+	pauses.all = true
+	pauses.m.Unlock()
+
+	// messages not yet routed are taken out of inbox,
+	// then wait a while for the one being routed by the kernel goroutine, if any
+	var inboxed []Message
+	deadline := time.Now().Add(SnapshotWait)
+	for {
+		drained := inbox.drain()
+		atomic.AddInt64(&routing, -int64(len(drained)))
+		for _, m := range drained {
+			inboxed = append(inboxed, kills.take(m)...)
+		}
+		if atomic.LoadInt64(&routing) <= 0 || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	pauses.m.Lock()
+	defer pauses.m.Unlock()
+
+	s := &Snapshot{Time: now()}
+	modules.Range(func(k, v interface{}) bool {
+		mod := v.(*module)
+		if mod.remote == nil {
+			s.Pending = append(s.Pending, mod.drain()...)
+		}
+		s.Pending = append(s.Pending, pauses.buffers[mod.id]...)
+		delete(pauses.buffers, mod.id)
+		return true
+	})
+	// buffered for modules not installed
+	for id, buffered := range pauses.buffers {
+		s.Pending = append(s.Pending, buffered...)
+		delete(pauses.buffers, id)
+	}
+	s.Pending = append(s.Pending, inboxed...)
+	for _, d := range delays.drain() {
+		s.Delayed = append(s.Delayed, DelayedMessage{At: d.at, Message: d.message})
+	}
+	return s
+}
+
+// Restore put the messages of snapshot into kernel, modules should be installed before
+// pending messages are delivered in order, delayed ones at their delivery time
+func Restore(s *Snapshot) error {
+	for _, m := range s.Pending {
+		if !postMessage(m) {
+			return ErrShutdown
+		}
+	}
+	for _, d := range s.Delayed {
+		if !delays.add(d.At, d.Message) {
+			return ErrShutdown
+		}
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package core
+
+import (
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSnapshotSwap(t *testing.T) {
+	tests := []struct {
+		name string
+		// install the old module, began returns the messages its handlers began,
+		// release lets them finish
+		install func(t *testing.T) (began func() []string, release func())
+	}{
+		{"plain channel", func(t *testing.T) (func() []string, func()) {
+			InstallModule(1, make(chan Message, 10))
+			return func() []string { return nil }, func() {}
+		}},
+		// the dispatcher moves the messages into the worker queues at once
+		{"workers", func(t *testing.T) (func() []string, func()) {
+			var (
+				m     sync.Mutex
+				began []string
+			)
+			gate := make(chan struct{})
+			InstallHandler(1, func(message Message) {
+				m.Lock()
+				began = append(began, message.Data)
+				m.Unlock()
+				<-gate
+			}, WithWorkers(2))
+			snapshot := func() []string {
+				m.Lock()
+				defer m.Unlock()
+				return append([]string(nil), began...)
+			}
+			return snapshot, func() {
+				close(gate)
+				waitFor(t, "old handlers done", func() bool {
+					return atomic.LoadUint64(&metricsOf(1).latency.count) == uint64(len(snapshot()))
+				})
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Reset()
+			t.Cleanup(Reset)
+			began, release := tt.install(t)
+			defer release()
+
+			sent := []string{"a", "b", "c", "d"}
+			for _, data := range sent {
+				SendMessage(WithIdInt64(1), WithData(data))
+			}
+			for Poll() {
+			}
+			v, _ := modules.Load(int64(1))
+			if lanes := v.(*module).lanes; lanes != nil {
+				waitFor(t, "messages dispatched", func() bool { return lanes.len() == 0 && len(began()) > 0 })
+			}
+
+			s := TakeSnapshot()
+			queue := make(chan Message, 10)
+			if err := Swap(1, queue); err != nil {
+				t.Fatal(err)
+			}
+			if err := Restore(s); err != nil {
+				t.Fatal(err)
+			}
+			ResumeAll()
+			for Poll() {
+			}
+			close(queue)
+
+			// each message dealt once, by the old handler or the new module
+			got := began()
+			for m := range queue {
+				got = append(got, m.Data)
+			}
+			sort.Strings(got)
+			if !reflect.DeepEqual(got, sent) {
+				t.Errorf("dealt %v, want %v once each", got, sent)
+			}
+			if n := len(s.Pending) + len(began()); n != len(sent) {
+				t.Errorf("%d messages in snapshot and %d began, want %d", len(s.Pending), len(began()), len(sent))
+			}
+		})
+	}
+}
@@ -0,0 +1,67 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// Transport carry the messages to modules installed in other processes
+// Send is called on the routing goroutine and should not block
+type Transport interface {
+	Send(m Message) error
+}
+
+// ErrRoutingLoop message received from other process targets a remote module
+var ErrRoutingLoop = errors.New("core: message for remote module received from remote")
+
+// withRemote forward the messages of the module by transport
+func withRemote(t Transport) installOption {
+	return func(m *module) {
+		m.remote = t
+	}
+}
+
+// InstallRemote install module whose messages are forwarded by transport,
+// SendMessage to the identifier reaches the module in the other process
+func InstallRemote(id int64, t Transport, opts ...installOption) bool {
+	return InstallModule(id, nil, append(opts, withRemote(t))...)
+}
+
+// IsRemote whether the module installed with the identifier is remote
+func IsRemote(id int64) bool {
+	v, ok := modules.Load(id)
+	return ok && v.(*module).remote != nil
+}
+
+// Inject put the message received from the other process into kernel
+// messages for remote modules are discarded to avoid routing loops
+func Inject(m Message) error {
+	v, ok := modules.Load(m.Identifier)
+	if !ok {
+		e := ErrNotInstalled
+		Logger.Info(fmt.Sprintf("Message from: %d value:[%s] discarded: %v", m.Identifier, m.Data, e))
+		atomic.AddUint64(&metricsOf(m.Identifier).dropped, 1)
+		bury(m, e)
+		return e
+	}
+	if v.(*module).remote != nil {
+		e := ErrRoutingLoop
+		Logger.Info(fmt.Sprintf("Message from: %d value:[%s] discarded: %v", m.Identifier, m.Data, e))
+		atomic.AddUint64(&metricsOf(m.Identifier).dropped, 1)
+		bury(m, e)
+		return e
+	}
+	if !postMessage(m) {
+		atomic.AddUint64(&metricsOf(m.Identifier).dropped, 1)
+		bury(m, ErrShutdown)
+		return ErrShutdown
+	}
+	return nil
+}
+
+// Undelivered report the message the transport failed to deliver after Send returned,
+// the message is counted as dropped and kept as dead letter
+func Undelivered(m Message, e error) {
+	settle(m, e)
+}
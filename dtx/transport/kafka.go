@@ -0,0 +1,248 @@
+package transport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	core "devtools/dtx"
+	"devtools/kafka/consumer"
+	"devtools/kafka/producer"
+)
+
+// headers set on each Kafka message, the body carries the whole core.Message
+const (
+	HeaderIdentifier  = "dtx-identifier"
+	HeaderMessageId   = "dtx-message-id"
+	HeaderContentType = "dtx-content-type"
+	HeaderPriority    = "dtx-priority"
+	// HeaderTraceparent W3C trace context, lets Kafka tooling follow the trace
+	HeaderTraceparent = core.MetadataTraceparent
+)
+
+// Broker Kafka operations used by the transport
+// NewBroker wraps the kafka/producer and kafka/consumer packages,
+// tests can use an in-process fake
+type Broker interface {
+	// Produce publish the message, should not wait for the delivery
+	// failed is called with the delivery error when the message is not delivered after Produce returned
+	Produce(m *kafka.Message, failed func(e error)) error
+	// Consume subscribe the topics and call handle for each message until failed fatally,
+	// the message offset is committed only when handle returns true,
+	// consuming stops without committing when handle returns false
+	Consume(topics []string, handle func(m *kafka.Message) bool) error
+}
+
+// Route the topic and partition the messages of a module identifier go to
+// kafka.PartitionAny partitions by the message key, messages without key are spread by the partitioner
+type Route struct {
+	Topic     string
+	Partition int32
+}
+
+// Kafka forward the messages of the routed module identifiers through Kafka
+// messages survive the process restarts and are load-balanced by the consumer group
+type Kafka struct {
+	broker Broker
+
+	m      sync.RWMutex
+	routes map[int64]Route
+}
+
+// NewKafka transport over the broker
+func NewKafka(broker Broker) *Kafka {
+	return &Kafka{
+		broker: broker,
+		routes: make(map[int64]Route),
+	}
+}
+
+// Route forward the messages of identifier to topic, partition by message key
+func (k *Kafka) Route(identifier int64, topic string) bool {
+	return k.RoutePartition(identifier, topic, kafka.PartitionAny)
+}
+
+// RoutePartition forward the messages of identifier to the partition of topic
+// the identifier is installed into kernel as remote module
+func (k *Kafka) RoutePartition(identifier int64, topic string, partition int32) bool {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if !core.InstallRemote(identifier, k) {
+		return false
+	}
+	k.routes[identifier] = Route{Topic: topic, Partition: partition}
+	return true
+}
+
+// Unroute stop forwarding the messages of identifier
+func (k *Kafka) Unroute(identifier int64) bool {
+	k.m.Lock()
+	defer k.m.Unlock()
+
+	if _, ok := k.routes[identifier]; !ok {
+		return false
+	}
+	delete(k.routes, identifier)
+	return core.UninstallModule(identifier)
+}
+
+// Routes copy of the routing table
+func (k *Kafka) Routes() map[int64]Route {
+	k.m.RLock()
+	defer k.m.RUnlock()
+
+	r := make(map[int64]Route, len(k.routes))
+	for id, route := range k.routes {
+		r[id] = route
+	}
+	return r
+}
+
+// Send publish the message to the topic of its identifier, implements core.Transport
+// messages failed to be delivered later are kept as dead letters
+func (k *Kafka) Send(m core.Message) error {
+	k.m.RLock()
+	route, ok := k.routes[m.Identifier]
+	k.m.RUnlock()
+	if !ok {
+		return fmt.Errorf("transport: no kafka route for identifier: %d", m.Identifier)
+	}
+
+	msg, e := Encode(m, route)
+	if e != nil {
+		return e
+	}
+	return k.broker.Produce(msg, func(e error) {
+		core.Undelivered(m, e)
+	})
+}
+
+// Serve consume the topics and inject the messages into the local kernel,
+// blocks until the broker failed or the kernel shut down
+// messages which can not be decoded are logged and committed,
+// messages rejected by the kernel are dead-lettered by Inject and committed
+func (k *Kafka) Serve(topics ...string) error {
+	return k.broker.Consume(topics, func(msg *kafka.Message) bool {
+		m, e := Decode(msg)
+		if e != nil {
+			core.Logger.Error(fmt.Sprintf("Kafka message %s discarded: %v", msg.TopicPartition, e))
+			return true
+		}
+		// stop consuming on shutdown, the message is consumed again after restart
+		return !errors.Is(core.Inject(m), core.ErrShutdown)
+	})
+}
+
+// Encode convert the message into Kafka message of the route
+func Encode(m core.Message, route Route) (*kafka.Message, error) {
+	body, e := json.Marshal(m)
+	if e != nil {
+		return nil, e
+	}
+	topic := route.Topic
+	var key []byte
+	if m.Key != "" {
+		key = []byte(m.Key)
+	}
+	headers := []kafka.Header{
+		{Key: HeaderIdentifier, Value: []byte(strconv.FormatInt(m.Identifier, 10))},
+		{Key: HeaderMessageId, Value: []byte(strconv.FormatInt(m.Id, 10))},
+		{Key: HeaderContentType, Value: []byte(m.ContentType)},
+		{Key: HeaderPriority, Value: []byte(strconv.Itoa(m.Priority))},
+	}
+	if tp, ok := m.Metadata[core.MetadataTraceparent]; ok {
+		headers = append(headers, kafka.Header{Key: HeaderTraceparent, Value: []byte(tp)})
+	}
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: route.Partition},
+		Key:            key,
+		Value:          body,
+		Headers:        headers,
+	}, nil
+}
+
+// Decode convert the Kafka message back into the message
+func Decode(msg *kafka.Message) (core.Message, error) {
+	var m core.Message
+	e := json.Unmarshal(msg.Value, &m)
+	return m, e
+}
+
+// kafkaBroker Broker implemented by the kafka/producer and kafka/consumer packages
+type kafkaBroker struct {
+	p *kafka.Producer
+	c *kafka.Consumer
+}
+
+// NewBroker broker over the producer and consumer,
+// either of them can be nil when the process only sends or receives
+// the consumer should be created by NewConsumer, so that only the offsets of
+// the messages accepted by the kernel are committed
+func NewBroker(p *kafka.Producer, c *kafka.Consumer) Broker {
+	return &kafkaBroker{p: p, c: c}
+}
+
+// NewConsumer consumer of Serve, offsets are committed only after the kernel accepted the message
+// and the messages not accepted before the process stopped are consumed again after restart
+func NewConsumer(opts ...consumer.NewOptions) *kafka.Consumer {
+	return consumer.NewConsumer(append(opts, consumer.WithManualCommit())...)
+}
+
+func (b *kafkaBroker) Produce(m *kafka.Message, failed func(e error)) error {
+	if b.p == nil {
+		return errors.New("transport: no kafka producer")
+	}
+	// the errors reported before PushAsync returned, producing failed or a fast delivery report,
+	// are returned, the later ones go to failed, so each error is reported once
+	var (
+		lock     sync.Mutex
+		returned bool
+		err      error
+	)
+	producer.PushAsync(
+		producer.WithProducer(b.p),
+		producer.WithTopic(*m.TopicPartition.Topic),
+		producer.WithPartition(m.TopicPartition.Partition),
+		producer.WithKey(string(m.Key)),
+		producer.WithBuffer(m.Value),
+		producer.WithHeaders(m.Headers...),
+		producer.WithDeliveryCallback(func(_ *kafka.Message, e error) {
+			if e == nil {
+				return
+			}
+			lock.Lock()
+			if !returned {
+				err = e
+				lock.Unlock()
+				return
+			}
+			lock.Unlock()
+			if failed != nil {
+				failed(e)
+			}
+		}),
+	)
+	lock.Lock()
+	defer lock.Unlock()
+	returned = true
+	return err
+}
+
+func (b *kafkaBroker) Consume(topics []string, handle func(m *kafka.Message) bool) error {
+	if b.c == nil {
+		return errors.New("transport: no kafka consumer")
+	}
+	return consumer.Consume(
+		consumer.WithConsumer(b.c),
+		consumer.WithTopics(topics),
+		consumer.WithHandler(handle),
+		consumer.WithErrorCallback(func(e error) {
+			core.Logger.Warn(fmt.Sprintf("Kafka consuming goes on after: %v", e))
+		}),
+	)
+}
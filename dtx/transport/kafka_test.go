@@ -0,0 +1,236 @@
+package transport
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	core "devtools/dtx"
+	"devtools/dtx/coretest"
+)
+
+// fakeBroker in-process Broker keeping the produced messages in memory
+type fakeBroker struct {
+	m sync.Mutex
+	// err returned by Produce
+	err       error
+	produced  []*kafka.Message
+	failed    []func(e error)
+	committed []*kafka.Message
+}
+
+func (b *fakeBroker) Produce(m *kafka.Message, failed func(e error)) error {
+	b.m.Lock()
+	defer b.m.Unlock()
+	if b.err != nil {
+		return b.err
+	}
+	b.produced = append(b.produced, m)
+	b.failed = append(b.failed, failed)
+	return nil
+}
+
+func (b *fakeBroker) Consume(topics []string, handle func(m *kafka.Message) bool) error {
+	b.m.Lock()
+	messages := append([]*kafka.Message(nil), b.produced...)
+	b.m.Unlock()
+
+	for _, m := range messages {
+		for _, topic := range topics {
+			if *m.TopicPartition.Topic != topic {
+				continue
+			}
+			if !handle(m) {
+				return nil
+			}
+			b.m.Lock()
+			b.committed = append(b.committed, m)
+			b.m.Unlock()
+		}
+	}
+	return nil
+}
+
+// produce put the kafka message into the broker as if produced by the other process
+func (b *fakeBroker) produce(topic string, value string) {
+	b.m.Lock()
+	defer b.m.Unlock()
+	b.produced = append(b.produced, &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0},
+		Value:          []byte(value),
+	})
+}
+
+func header(m *kafka.Message, key string) string {
+	for _, h := range m.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
+
+func TestKafkaRoundTrip(t *testing.T) {
+	h := coretest.New(t)
+	b := &fakeBroker{}
+	k := NewKafka(b)
+	if !k.RoutePartition(7, "events", 2) {
+		t.Fatal("route failed")
+	}
+	id, ok := core.SendMessageId(core.WithIdInt64(7), core.WithData("hello"), core.WithKey("user-1"),
+		core.WithContentType(core.ContentTypeJSON), core.WithPriority(core.PriorityHigh), core.WithTrace())
+	if !ok {
+		t.Fatal("send failed")
+	}
+	h.Run()
+
+	if len(b.produced) != 1 {
+		t.Fatalf("%d messages produced, want 1", len(b.produced))
+	}
+	msg := b.produced[0]
+	if *msg.TopicPartition.Topic != "events" || msg.TopicPartition.Partition != 2 || string(msg.Key) != "user-1" {
+		t.Errorf("produced to %s key %q", msg.TopicPartition, msg.Key)
+	}
+	if header(msg, HeaderIdentifier) != "7" || header(msg, HeaderContentType) != core.ContentTypeJSON ||
+		header(msg, HeaderPriority) != "1" || header(msg, HeaderTraceparent) == "" {
+		t.Errorf("headers %v", msg.Headers)
+	}
+
+	// the other process receiving the module messages
+	k.Unroute(7)
+	c := h.Capture(7)
+	if e := k.Serve("events"); e != nil {
+		t.Fatal(e)
+	}
+	h.Run()
+	got, ok := c.Last()
+	if !ok {
+		t.Fatal("message not injected")
+	}
+	want, _ := Decode(msg)
+	if got.Id != id || got.Data != "hello" || got.Key != "user-1" || got.Priority != core.PriorityHigh ||
+		!reflect.DeepEqual(got.Metadata, want.Metadata) {
+		t.Errorf("injected %+v, want %+v", got, want)
+	}
+	if len(b.committed) != 1 {
+		t.Errorf("%d messages committed, want 1", len(b.committed))
+	}
+}
+
+func TestKafkaUndelivered(t *testing.T) {
+	broken := errors.New("broker down")
+	tests := []struct {
+		name string
+		// sync error returned by Produce, async error reported after it returned
+		sync, async error
+		delivered   uint64
+	}{
+		{"delivered", nil, nil, 1},
+		{"produce failed", broken, nil, 0},
+		{"delivery failed", nil, broken, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := coretest.New(t)
+			b := &fakeBroker{err: tt.sync}
+			NewKafka(b).Route(7, "events")
+			core.SendMessage(core.WithIdInt64(7), core.WithData("hello"))
+			h.Run()
+			if tt.async != nil {
+				b.failed[0](tt.async)
+			}
+
+			s := core.Stats().Modules[0]
+			lost := tt.sync != nil || tt.async != nil
+			if s.Delivered != tt.delivered || (s.Dropped == 1) != lost {
+				t.Errorf("delivered %d dropped %d", s.Delivered, s.Dropped)
+			}
+			letters, _ := core.DeadLetters(core.DeadLetterQuery{})
+			if (len(letters) == 1) != lost {
+				t.Fatalf("dead letters %+v", letters)
+			}
+			if lost && (letters[0].Reason != core.ReasonUndeliverable || letters[0].Message.Data != "hello") {
+				t.Errorf("dead letter %+v", letters[0])
+			}
+		})
+	}
+}
+
+func TestKafkaServe(t *testing.T) {
+	h := coretest.New(t)
+	b := &fakeBroker{}
+	c := h.Capture(7)
+	b.produce("events", "not json")
+	b.produce("events", `{"Identifier": 8, "Data": "not installed"}`)
+	b.produce("events", `{"Identifier": 7, "Data": "a"}`)
+	b.produce("other", `{"Identifier": 7, "Data": "other topic"}`)
+
+	k := NewKafka(b)
+	if e := k.Serve("events"); e != nil {
+		t.Fatal(e)
+	}
+	h.Run()
+	c.AssertData(t, "a")
+	// malformed and dead-lettered messages are committed too
+	if len(b.committed) != 3 {
+		t.Errorf("%d messages committed, want 3", len(b.committed))
+	}
+
+	// consumed again after restart
+	core.Shutdown()
+	b.committed = nil
+	b.produced = b.produced[2:3]
+	if e := k.Serve("events"); e != nil {
+		t.Fatal(e)
+	}
+	if len(b.committed) != 0 {
+		t.Errorf("%d messages committed after shutdown", len(b.committed))
+	}
+}
+
+func TestBrokerProduce(t *testing.T) {
+	p, e := kafka.NewProducer(&kafka.ConfigMap{
+		"test.mock.num.brokers": 1,
+		"message.max.bytes":     10000,
+	})
+	if e != nil {
+		t.Skipf("mock cluster: %v", e)
+	}
+	defer p.Close()
+	b := NewBroker(p, nil)
+
+	var (
+		lock   sync.Mutex
+		failed []error
+	)
+	onFailed := func(e error) {
+		lock.Lock()
+		failed = append(failed, e)
+		lock.Unlock()
+	}
+	topic := "events"
+	produce := func(size int) error {
+		return b.Produce(&kafka.Message{
+			TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+			Value:          make([]byte, size),
+		}, onFailed)
+	}
+
+	if e := produce(100); e != nil {
+		t.Errorf("Produce: %v", e)
+	}
+	// refused by the client, returned instead of reported as delivered
+	if e := produce(20000); e == nil {
+		t.Error("Produce of too large message succeeded")
+	}
+	p.Flush(int((5 * time.Second).Milliseconds()))
+	lock.Lock()
+	defer lock.Unlock()
+	if len(failed) != 0 {
+		t.Errorf("failed called with %v", failed)
+	}
+}
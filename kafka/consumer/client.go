@@ -1,6 +1,7 @@
 package consumer
 
 import (
+	"errors"
 	"strings"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
@@ -10,6 +11,8 @@ type newOption struct {
 	bootstrapServer          []string
 	groupId, autoOffsetReset string
 	otherOptions             map[string]interface{}
+	transactional            bool
+	manualCommit             bool
 }
 
 type NewOptions func(*newOption)
@@ -38,6 +41,23 @@ func WithAutoOffsetReset(autoOffsetReset string) NewOptions {
 	}
 }
 
+// WithTransactional consumer of the consume-transform-produce pipelines:
+// offsets are committed by the producer transaction and only committed messages are read
+func WithTransactional() NewOptions {
+	return func(o *newOption) {
+		o.transactional = true
+	}
+}
+
+// WithManualCommit offsets are neither stored nor committed by the client,
+// only CommitMessage of the messages handled commits them, see Consume
+// messages read but not handled before the process stopped are read again
+func WithManualCommit() NewOptions {
+	return func(o *newOption) {
+		o.manualCommit = true
+	}
+}
+
 func NewConsumer(opts ...NewOptions) *kafka.Consumer {
 	opt := &newOption{
 		bootstrapServer: []string{"localhost"},
@@ -54,6 +74,14 @@ func NewConsumer(opts ...NewOptions) *kafka.Consumer {
 		"group.id":          opt.groupId,
 		"auto.offset.reset": opt.autoOffsetReset,
 	}
+	if opt.transactional {
+		(*configMap)["enable.auto.commit"] = false
+		(*configMap)["isolation.level"] = "read_committed"
+	}
+	if opt.manualCommit {
+		(*configMap)["enable.auto.commit"] = false
+		(*configMap)["enable.auto.offset.store"] = false
+	}
 
 	if opt.otherOptions != nil && len(opt.otherOptions) > 0 {
 		for key, value := range opt.otherOptions {
@@ -76,6 +104,8 @@ type getOption struct {
 	topics         []string
 	reBalance      kafka.RebalanceCb
 	callback       func(*kafka.Message, chan int) bool
+	handler        func(*kafka.Message) bool
+	onError        func(error)
 	goroutineCount int
 }
 type GetOptions func(*getOption)
@@ -104,6 +134,22 @@ func WithCallback(cb func(*kafka.Message, chan int) bool) GetOptions {
 	}
 }
 
+// WithHandler called by Consume for each message, the offset is committed when it returns true
+// and consuming stops without committing when it returns false
+func WithHandler(handler func(*kafka.Message) bool) GetOptions {
+	return func(o *getOption) {
+		o.handler = handler
+	}
+}
+
+// WithErrorCallback called by Consume with the errors it goes on after,
+// such as the brokers down or a failed commit
+func WithErrorCallback(cb func(err error)) GetOptions {
+	return func(o *getOption) {
+		o.onError = cb
+	}
+}
+
 func WithGoroutineCount(c int) GetOptions {
 	return func(o *getOption) {
 		o.goroutineCount = c
@@ -149,3 +195,59 @@ func GetMessages(opts ...GetOptions) {
 		}
 	}
 }
+
+// Consume subscribe the topics and call the handler for each message until it returns false
+// or the consumer failed fatally, the error is returned instead of panicking like GetMessages
+// the offset is committed after the handler returned true, use a consumer WithManualCommit
+// so that the messages not handled are not committed by the client
+func Consume(opts ...GetOptions) error {
+	opt := &getOption{
+		topics: []string{"*"},
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	if opt.consumer == nil {
+		return errors.New("consumer: kafka consumer should be initialised")
+	}
+	if opt.handler == nil {
+		return errors.New("consumer: messages should be handled")
+	}
+
+	c := opt.consumer
+	if err := c.SubscribeTopics(opt.topics, opt.reBalance); err != nil {
+		return err
+	}
+	for {
+		message, err := c.ReadMessage(-1)
+		if err != nil {
+			if fatal(err) {
+				return err
+			}
+			opt.report(err)
+			continue
+		}
+		if !opt.handler(message) {
+			return nil
+		}
+		if _, err = c.CommitMessage(message); err != nil {
+			if fatal(err) {
+				return err
+			}
+			opt.report(err)
+		}
+	}
+}
+
+// report pass the error consuming goes on after to the error callback
+func (o *getOption) report(err error) {
+	if o.onError != nil {
+		o.onError(err)
+	}
+}
+
+// fatal whether the consumer is no longer usable
+func fatal(err error) bool {
+	var e kafka.Error
+	return errors.As(err, &e) && e.IsFatal()
+}
@@ -0,0 +1,33 @@
+package consumer
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Headers the headers of message as map, the last value wins for repeated keys
+func Headers(m *kafka.Message) map[string]string {
+	headers := make(map[string]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = string(h.Value)
+	}
+	return headers
+}
+
+// HeaderValues all values of each header key in order
+func HeaderValues(m *kafka.Message) map[string][]string {
+	headers := make(map[string][]string, len(m.Headers))
+	for _, h := range m.Headers {
+		headers[h.Key] = append(headers[h.Key], string(h.Value))
+	}
+	return headers
+}
+
+// Header the last value of the header key, false when missing
+func Header(m *kafka.Message, key string) (string, bool) {
+	for i := len(m.Headers) - 1; i >= 0; i-- {
+		if m.Headers[i].Key == key {
+			return string(m.Headers[i].Value), true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,24 @@
+package consumer
+
+import (
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"devtools/kafka/serde"
+)
+
+// Decode the value of the message into v with the deserializer
+func Decode(m *kafka.Message, d serde.Deserializer, v interface{}) error {
+	return d.Deserialize(topicOf(m), m.Value, v)
+}
+
+// DecodeKey the key of the message into v with the deserializer
+func DecodeKey(m *kafka.Message, d serde.Deserializer, v interface{}) error {
+	return d.Deserialize(topicOf(m), m.Key, v)
+}
+
+func topicOf(m *kafka.Message) string {
+	if m.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *m.TopicPartition.Topic
+}
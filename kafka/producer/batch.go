@@ -0,0 +1,316 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ErrPublisherClosed message published after Close
+var ErrPublisherClosed = errors.New("producer: publisher closed")
+
+type batchOption struct {
+	messages    int
+	bytes       int
+	linger      time.Duration
+	maxBuffered int
+}
+type BatchOptions func(*batchOption)
+
+// WithBatchMessages flush when the batch has n messages, 1000 by default
+func WithBatchMessages(n int) BatchOptions {
+	return func(o *batchOption) {
+		o.messages = n
+	}
+}
+
+// WithBatchBytes flush when the batch has n bytes of keys, values and headers, 1MiB by default
+func WithBatchBytes(n int) BatchOptions {
+	return func(o *batchOption) {
+		o.bytes = n
+	}
+}
+
+// WithLinger max time a message waits in the batch, 50ms by default
+func WithLinger(d time.Duration) BatchOptions {
+	return func(o *batchOption) {
+		o.linger = d
+	}
+}
+
+// WithMaxBufferedBytes limit of the bytes batched and waiting for delivery, 64MiB by default
+// Publish blocks while the limit is reached
+func WithMaxBufferedBytes(n int) BatchOptions {
+	return func(o *batchOption) {
+		o.maxBuffered = n
+	}
+}
+
+// Undelivered message failed to deliver and the reason
+type Undelivered struct {
+	Message *kafka.Message
+	Err     error
+}
+
+// Publisher batch the messages of a producer, flushing them when the batch is full
+// or the linger time passed, instead of calling Flush by hand
+// the producer is owned by the caller and closed after the publisher
+type Publisher struct {
+	p   *kafka.Producer
+	opt batchOption
+
+	m          sync.Mutex
+	batch      []*kafka.Message
+	batchBytes int
+	force      bool
+	// buffered bytes batched and in flight
+	buffered int
+	inflight map[*kafka.Message]struct{}
+	failed   []Undelivered
+	closed   bool
+	// changed closed and replaced when buffered bytes released
+	changed chan struct{}
+
+	kick   chan struct{}
+	stop   chan struct{}
+	abort  chan struct{}
+	exited chan struct{}
+}
+
+// NewPublisher start a publisher of the producer
+func NewPublisher(p *kafka.Producer, opts ...BatchOptions) *Publisher {
+	opt := batchOption{
+		messages:    1000,
+		bytes:       1 << 20,
+		linger:      50 * time.Millisecond,
+		maxBuffered: 64 << 20,
+	}
+	for _, o := range opts {
+		o(&opt)
+	}
+
+	pub := &Publisher{
+		p:        p,
+		opt:      opt,
+		inflight: make(map[*kafka.Message]struct{}),
+		changed:  make(chan struct{}),
+		kick:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		abort:    make(chan struct{}),
+		exited:   make(chan struct{}),
+	}
+	go pub.run()
+	return pub
+}
+
+// Publish add the message to the batch, the producer of the publisher is used
+// blocks until ctx done while the buffered bytes reach the limit
+// delivery failures are reported by Close
+func (p *Publisher) Publish(ctx context.Context, opts ...PushOptions) error {
+	opt, err := newPushOption(append([]PushOptions{WithProducer(p.p)}, opts...))
+	if err != nil {
+		return err
+	}
+	message := opt.message()
+	size := messageSize(message)
+
+	p.m.Lock()
+	for !p.closed && p.buffered > 0 && p.buffered+size > p.opt.maxBuffered {
+		changed := p.changed
+		p.m.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		p.m.Lock()
+	}
+	if p.closed {
+		p.m.Unlock()
+		return ErrPublisherClosed
+	}
+	p.batch = append(p.batch, message)
+	p.batchBytes += size
+	p.buffered += size
+	wake := len(p.batch) == 1 || p.full()
+	p.m.Unlock()
+
+	if wake {
+		p.wake()
+	}
+	return nil
+}
+
+// Flush produce the batch now and wait until the buffered messages are reported
+func (p *Publisher) Flush(ctx context.Context) error {
+	p.m.Lock()
+	p.force = true
+	p.m.Unlock()
+	p.wake()
+	return p.drained(ctx)
+}
+
+// Close flush the batch, wait for the delivery reports until ctx done
+// and return the messages failed or not reported in time
+func (p *Publisher) Close(ctx context.Context) ([]Undelivered, error) {
+	p.m.Lock()
+	if p.closed {
+		p.m.Unlock()
+		return nil, ErrPublisherClosed
+	}
+	p.closed = true
+	p.release(0)
+	p.m.Unlock()
+
+	close(p.stop)
+	err := p.drained(ctx)
+	close(p.abort)
+	<-p.exited
+
+	p.m.Lock()
+	defer p.m.Unlock()
+	undelivered := p.failed
+	p.failed = nil
+	for _, m := range p.batch {
+		undelivered = append(undelivered, Undelivered{Message: m, Err: err})
+	}
+	for m := range p.inflight {
+		undelivered = append(undelivered, Undelivered{Message: m, Err: err})
+	}
+	p.batch, p.inflight = nil, nil
+	return undelivered, err
+}
+
+// drained wait until the batched and in flight messages reported
+func (p *Publisher) drained(ctx context.Context) error {
+	for {
+		p.m.Lock()
+		idle := len(p.batch) == 0 && len(p.inflight) == 0
+		changed := p.changed
+		p.m.Unlock()
+		if idle {
+			return nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// run produce the batches when full, forced or lingered, until stopped
+func (p *Publisher) run() {
+	defer close(p.exited)
+
+	var linger <-chan time.Time
+	for {
+		select {
+		case <-p.kick:
+			p.m.Lock()
+			full, empty := p.full(), len(p.batch) == 0
+			p.m.Unlock()
+			if !full {
+				if linger == nil && !empty {
+					linger = time.After(p.opt.linger)
+				}
+				continue
+			}
+		case <-linger:
+		case <-p.stop:
+			p.produce(p.take())
+			return
+		}
+		linger = nil
+		p.produce(p.take())
+	}
+}
+
+// wake the flusher, dropped when already woken
+func (p *Publisher) wake() {
+	select {
+	case p.kick <- struct{}{}:
+	default:
+	}
+}
+
+// full whether the batch should be produced, lock held by caller
+func (p *Publisher) full() bool {
+	return p.force || len(p.batch) >= p.opt.messages || p.batchBytes >= p.opt.bytes
+}
+
+// take the batch, the messages become in flight
+func (p *Publisher) take() []*kafka.Message {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	batch := p.batch
+	p.batch, p.batchBytes, p.force = nil, 0, false
+	for _, m := range batch {
+		p.inflight[m] = struct{}{}
+	}
+	return batch
+}
+
+// produce the batch, waiting for room while the producer queue is full until aborted
+func (p *Publisher) produce(batch []*kafka.Message) {
+	for _, m := range batch {
+		m, size := m, messageSize(m)
+		report := func(_ *kafka.Message, err error) {
+			p.settle(m, size, err)
+		}
+		for {
+			_, err := produceAsync(p.p, m, report)
+			if err == nil {
+				break
+			}
+			var e *Error
+			if errors.As(err, &e) && e.Code == kafka.ErrQueueFull {
+				select {
+				case <-p.abort:
+				default:
+					p.p.Flush(100)
+					continue
+				}
+			}
+			Dispatch(p.p).fail(m, err)
+			p.settle(m, size, err)
+			break
+		}
+	}
+}
+
+// settle release the reported message, recording it when failed
+func (p *Publisher) settle(m *kafka.Message, size int, err error) {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if _, ok := p.inflight[m]; !ok {
+		// reported after Close returned
+		return
+	}
+	delete(p.inflight, m)
+	if err != nil {
+		p.failed = append(p.failed, Undelivered{Message: m, Err: err})
+	}
+	p.release(size)
+}
+
+// release size buffered bytes and wake the waiters, lock held by caller
+func (p *Publisher) release(size int) {
+	p.buffered -= size
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// messageSize bytes of the key, value and headers
+func messageSize(m *kafka.Message) int {
+	size := len(m.Key) + len(m.Value)
+	for _, h := range m.Headers {
+		size += len(h.Key) + len(h.Value)
+	}
+	return size
+}
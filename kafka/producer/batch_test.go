@@ -0,0 +1,137 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestMessageSize(t *testing.T) {
+	tests := []struct {
+		m    kafka.Message
+		want int
+	}{
+		{kafka.Message{}, 0},
+		{kafka.Message{Key: []byte("k"), Value: []byte("value")}, 6},
+		{kafka.Message{Value: []byte("v"), Headers: []kafka.Header{{Key: "ab", Value: []byte("cd")}, {Key: "e"}}}, 6},
+	}
+	for _, tt := range tests {
+		if got := messageSize(&tt.m); got != tt.want {
+			t.Errorf("messageSize(%v) = %d, want %d", tt.m, got, tt.want)
+		}
+	}
+}
+
+func TestPublisherFlush(t *testing.T) {
+	tests := []struct {
+		name string
+		opts []BatchOptions
+		// published messages of size bytes, flushed whether delivered before the linger of 1 hour
+		published, size int
+		flushed         bool
+	}{
+		{"batch full", []BatchOptions{WithBatchMessages(3)}, 3, 10, true},
+		{"batch bytes", []BatchOptions{WithBatchMessages(100), WithBatchBytes(25)}, 3, 10, true},
+		{"lingered", []BatchOptions{WithBatchMessages(100), WithLinger(20 * time.Millisecond)}, 2, 10, true},
+		{"waiting", []BatchOptions{WithBatchMessages(100)}, 2, 10, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProducer(t)
+			pub := NewPublisher(p, append([]BatchOptions{WithLinger(time.Hour)}, tt.opts...)...)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			for i := 0; i < tt.published; i++ {
+				if err := pub.Publish(ctx, WithTopic("events"), WithBuffer(make([]byte, tt.size))); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			deadline := time.Now().Add(time.Second)
+			for Dispatch(p).Stats().Delivered < uint64(tt.published) && time.Now().Before(deadline) {
+				time.Sleep(10 * time.Millisecond)
+			}
+			if delivered := Dispatch(p).Stats().Delivered; (delivered == uint64(tt.published)) != tt.flushed {
+				t.Errorf("%d of %d delivered", delivered, tt.published)
+			}
+
+			// Close flushes the rest
+			undelivered, err := pub.Close(ctx)
+			if err != nil || len(undelivered) != 0 {
+				t.Errorf("Close = %v, %v", undelivered, err)
+			}
+			if delivered := Dispatch(p).Stats().Delivered; delivered != uint64(tt.published) {
+				t.Errorf("%d of %d delivered after Close", delivered, tt.published)
+			}
+		})
+	}
+}
+
+func TestPublisherClose(t *testing.T) {
+	p := newMockProducer(t)
+	pub := NewPublisher(p)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := pub.Publish(ctx, WithTopic("events"), WithBuffer([]byte("a"))); err != nil {
+		t.Fatal(err)
+	}
+	// refused by the producer, reported by Close
+	if err := pub.Publish(ctx, WithTopic("events"), WithBuffer(make([]byte, 20000))); err != nil {
+		t.Fatal(err)
+	}
+	if err := pub.Publish(ctx); !errors.Is(err, ErrEmptyTopic) {
+		t.Errorf("Publish without topic: %v", err)
+	}
+
+	undelivered, err := pub.Close(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(undelivered) != 1 || len(undelivered[0].Message.Value) != 20000 || undelivered[0].Err == nil {
+		t.Errorf("undelivered %+v", undelivered)
+	}
+	if err = pub.Publish(ctx, WithTopic("events")); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("Publish after Close: %v", err)
+	}
+	if _, err = pub.Close(ctx); !errors.Is(err, ErrPublisherClosed) {
+		t.Errorf("Close twice: %v", err)
+	}
+}
+
+func TestPublisherMaxBuffered(t *testing.T) {
+	// no broker answers, the buffered bytes are never released
+	p, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Close()
+	pub := NewPublisher(p, WithMaxBufferedBytes(15), WithLinger(time.Millisecond))
+
+	tests := []struct {
+		size int
+		err  error
+	}{
+		{10, nil},
+		// over the limit, blocks until ctx done
+		{10, context.DeadlineExceeded},
+		{5, nil},
+	}
+	for i, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		if err := pub.Publish(ctx, WithTopic("events"), WithBuffer(make([]byte, tt.size))); !errors.Is(err, tt.err) {
+			t.Errorf("Publish %d: error %v, want %v", i, err, tt.err)
+		}
+		cancel()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	undelivered, err := pub.Close(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) || len(undelivered) != 2 {
+		t.Errorf("Close = %d undelivered, %v", len(undelivered), err)
+	}
+}
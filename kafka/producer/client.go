@@ -1,10 +1,14 @@
 package producer
 
 import (
+	"context"
+	"errors"
 	"strings"
 	"time"
 
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+
+	"devtools/kafka/serde"
 )
 
 type pushOption struct {
@@ -15,7 +19,19 @@ type pushOption struct {
 	offset     kafka.Offset
 	metadata   *string
 	key        []byte
+	headers    []kafka.Header
+	callback   func(*kafka.Message, error)
+	timeout    time.Duration
+	maxHeader  int
 	waitResult bool
+	value      serialized
+	keyValue   serialized
+}
+
+// serialized value encoded by the serializer once the topic is known
+type serialized struct {
+	s serde.Serializer
+	v interface{}
 }
 type PushOptions func(option *pushOption)
 
@@ -23,6 +39,9 @@ type newOption struct {
 	bootstrapServers                                             []string
 	messageMaxBytes, messageCopyMaxBytes, receiveMessageMaxBytes int
 	otherOptions                                                 map[string]interface{}
+	idempotence                                                  bool
+	transactionalId                                              string
+	partitioner                                                  Partitioner
 }
 type NewOptions func(*newOption)
 
@@ -57,6 +76,15 @@ func WithOtherOptions(otherOptions map[string]interface{}) NewOptions {
 }
 
 func NewProducer(options ...NewOptions) *kafka.Producer {
+	pp, err := NewProducerE(options...)
+	if err != nil {
+		panic(err)
+	}
+	return pp
+}
+
+// NewProducerE like NewProducer but return the config and creation errors
+func NewProducerE(options ...NewOptions) (*kafka.Producer, error) {
 
 	opt := &newOption{
 		bootstrapServers:    []string{"localhost"},
@@ -81,16 +109,23 @@ func NewProducer(options ...NewOptions) *kafka.Producer {
 		for key, value := range opt.otherOptions {
 			err := configMap.SetKey(key, value)
 			if err != nil {
-				panic(err)
+				return nil, wrap(OpConfig, err)
 			}
 		}
 	}
 
+	if err := configureIdempotence(configMap, opt); err != nil {
+		return nil, err
+	}
+
 	pp, err := kafka.NewProducer(configMap)
 	if err != nil {
-		panic(err)
+		return nil, wrap(OpNew, err)
 	}
-	return pp
+	if opt.partitioner != nil {
+		SetPartitioner(pp, opt.partitioner)
+	}
+	return pp, nil
 }
 
 func WithProducer(p *kafka.Producer) PushOptions {
@@ -133,13 +168,126 @@ func WithKey(key string) PushOptions {
 	}
 }
 
+// WithSerializedValue the value of the message encoded by the serializer, replacing WithBuffer
+func WithSerializedValue(s serde.Serializer, v interface{}) PushOptions {
+	return func(o *pushOption) {
+		o.value = serialized{s: s, v: v}
+	}
+}
+
+// WithSerializedKey the key of the message encoded by the serializer created serde.ForKey
+func WithSerializedKey(s serde.Serializer, v interface{}) PushOptions {
+	return func(o *pushOption) {
+		o.keyValue = serialized{s: s, v: v}
+	}
+}
+
+// WithHeaders append the headers to the message
+func WithHeaders(headers ...kafka.Header) PushOptions {
+	return func(o *pushOption) {
+		o.headers = append(o.headers, headers...)
+	}
+}
+
+// WithHeader append the header to the message
+func WithHeader(key, value string) PushOptions {
+	return func(o *pushOption) {
+		o.headers = append(o.headers, kafka.Header{Key: key, Value: []byte(value)})
+	}
+}
+
+// WithMaxHeaderBytes limit of the total size of header keys and values, DefaultMaxHeaderBytes by default
+func WithMaxHeaderBytes(n int) PushOptions {
+	return func(o *pushOption) {
+		o.maxHeader = n
+	}
+}
+
+// WithTimeout limit the time Send waits for the delivery
+func WithTimeout(timeout time.Duration) PushOptions {
+	return func(o *pushOption) {
+		o.timeout = timeout
+	}
+}
+
 func WithWaitResult(wait bool) PushOptions {
 	return func(o *pushOption) {
 		o.waitResult = wait
 	}
 }
 
+// PushMessage push the message, panics when the message can not be produced
+// false when waiting for the result and the delivery failed
 func PushMessage(opts ...PushOptions) bool {
+	err := Push(context.Background(), opts...)
+	if err == nil {
+		return true
+	}
+	var e *Error
+	if errors.As(err, &e) && e.Op == OpDelivery {
+		return false
+	}
+	panic(err)
+}
+
+// Push push the message, waiting for the delivery report WithWaitResult until ctx done
+// without waiting the report is handled by the Dispatcher of the producer
+// errors are *Error except ErrEmptyTopic, ErrNilProducer, the header, serializer and ctx errors
+func Push(ctx context.Context, opts ...PushOptions) error {
+	opt, err := newPushOption(opts)
+	if err != nil {
+		return err
+	}
+	if opt.waitResult {
+		_, err = send(ctx, opt)
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	d := Dispatch(opt.p)
+	m := opt.message()
+	if err := opt.p.Produce(m, nil); err != nil {
+		err = wrap(OpProduce, err)
+		d.fail(m, err)
+		return err
+	}
+	return nil
+}
+
+// Send push the message and wait for its delivery until ctx done or WithTimeout passed,
+// return the partition and offset assigned by the broker
+// the delivery error is *Error carrying the kafka error code
+func Send(ctx context.Context, opts ...PushOptions) (kafka.TopicPartition, error) {
+	opt, err := newPushOption(opts)
+	if err != nil {
+		return kafka.TopicPartition{}, err
+	}
+	return send(ctx, opt)
+}
+
+// send push the message of the parsed options and wait for its delivery
+func send(ctx context.Context, opt *pushOption) (kafka.TopicPartition, error) {
+	if opt.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opt.timeout)
+		defer cancel()
+	}
+	if err := ctx.Err(); err != nil {
+		return kafka.TopicPartition{}, err
+	}
+
+	m, err := pushAsync(opt).Wait(ctx)
+	if m == nil {
+		return kafka.TopicPartition{}, err
+	}
+	return m.TopicPartition, err
+}
+
+// newPushOption apply and validate the push options,
+// the options applied are returned with the error so that its callback can be called
+func newPushOption(opts []PushOptions) (*pushOption, error) {
 	opt := &pushOption{
 		p:          nil,
 		topic:      "",
@@ -148,49 +296,51 @@ func PushMessage(opts ...PushOptions) bool {
 		offset:     0,
 		metadata:   nil,
 		key:        nil,
+		maxHeader:  DefaultMaxHeaderBytes,
 		waitResult: false,
 	}
 	for _, o := range opts {
 		o(opt)
 	}
 	if len(opt.topic) <= 0 {
-		panic("topic empty")
+		return opt, ErrEmptyTopic
+	}
+	if err := validateHeaders(opt.headers, opt.maxHeader); err != nil {
+		return opt, err
 	}
 	if opt.p == nil {
-		panic("kafka producer client should be initialised")
+		return opt, ErrNilProducer
+	}
+	if opt.value.s != nil {
+		data, err := opt.value.s.Serialize(opt.topic, opt.value.v)
+		if err != nil {
+			return opt, err
+		}
+		opt.data = data
+	}
+	if opt.keyValue.s != nil {
+		key, err := opt.keyValue.s.Serialize(opt.topic, opt.keyValue.v)
+		if err != nil {
+			return opt, err
+		}
+		opt.key = key
 	}
+	return opt, nil
+}
 
-	message := &kafka.Message{
-		TopicPartition: kafka.TopicPartition{Topic: &opt.topic, Partition: opt.partition, Offset: opt.offset, Metadata: opt.metadata},
+// message the kafka message of the options, the partitioner of the producer
+// chooses the partition when none given
+func (opt *pushOption) message() *kafka.Message {
+	partition := opt.partition
+	if partition == kafka.PartitionAny {
+		partition = partitionOf(opt.p, opt.topic, opt.key)
+	}
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &opt.topic, Partition: partition, Offset: opt.offset, Metadata: opt.metadata},
 		Value:          opt.data,
 		Key:            opt.key,
 		Timestamp:      time.Now(),
 		TimestampType:  kafka.TimestampCreateTime,
-		Opaque:         nil, // not support
-		Headers:        nil, // not support
-	}
-	var delivery chan kafka.Event
-	if opt.waitResult {
-		delivery = make(chan kafka.Event)
-	}
-	err := opt.p.Produce(message, delivery)
-	if err != nil {
-		panic(err)
-	}
-
-	if !opt.waitResult {
-		return true
-	}
-
-	for e := range delivery {
-		switch ev := e.(type) {
-		case *kafka.Message:
-			if ev.TopicPartition.Error != nil {
-				return false
-			} else {
-				return true
-			}
-		}
+		Headers:        opt.headers,
 	}
-	return false
 }
@@ -0,0 +1,65 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestSend(t *testing.T) {
+	mock := newMockProducer(t)
+	// no broker answers
+	unreachable, err := kafka.NewProducer(&kafka.ConfigMap{"bootstrap.servers": "127.0.0.1:1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unreachable.Close()
+
+	tests := []struct {
+		name string
+		p    *kafka.Producer
+		size int
+		// timeout of Send, err and op of the error returned
+		timeout time.Duration
+		err     error
+		op      string
+	}{
+		{"delivered", mock, 100, 5 * time.Second, nil, ""},
+		{"refused by producer", mock, 20000, 5 * time.Second, nil, OpProduce},
+		{"timed out", unreachable, 100, 50 * time.Millisecond, context.DeadlineExceeded, ""},
+	}
+	for _, tt := range tests {
+		start := time.Now()
+		tp, err := Send(context.Background(), WithProducer(tt.p), WithTopic("events"),
+			WithBuffer(make([]byte, tt.size)), WithTimeout(tt.timeout))
+
+		var e *Error
+		switch {
+		case tt.op != "":
+			if !errors.As(err, &e) || e.Op != tt.op {
+				t.Errorf("%s: error %v, want %s error", tt.name, err, tt.op)
+			}
+		case !errors.Is(err, tt.err):
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+		if err == nil && (tp.Topic == nil || *tp.Topic != "events" || tp.Offset < 0) {
+			t.Errorf("%s: delivered to %v", tt.name, tp)
+		}
+		if d := time.Since(start); d > tt.timeout+time.Second {
+			t.Errorf("%s: returned after %s, timeout %s", tt.name, d, tt.timeout)
+		}
+	}
+}
+
+func TestPushMessageWait(t *testing.T) {
+	p := newMockProducer(t)
+	if !PushMessage(WithProducer(p), WithTopic("events"), WithBuffer([]byte("a")), WithWaitResult(true)) {
+		t.Error("message not delivered")
+	}
+	if s := Dispatch(p).Stats(); s.Delivered != 1 || s.Pending != 0 {
+		t.Errorf("stats %+v", s)
+	}
+}
@@ -0,0 +1,232 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ErrProducerClosed producer closed before the delivery report arrived
+var ErrProducerClosed = errors.New("producer: producer closed")
+
+// Result handle of the message pushed by PushAsync
+type Result struct {
+	done     chan struct{}
+	message  *kafka.Message
+	err      error
+	callback func(*kafka.Message, error)
+}
+
+// Done closed when the delivery report arrived and the callback returned
+func (r *Result) Done() <-chan struct{} {
+	return r.done
+}
+
+// Wait the delivered message with its partition and offset, or the delivery error
+func (r *Result) Wait(ctx context.Context) (*kafka.Message, error) {
+	select {
+	case <-r.done:
+		return r.message, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// complete record the report and call the callback, Done is closed after the callback returned
+func (r *Result) complete(m *kafka.Message, err error) {
+	r.message, r.err = m, err
+	if r.callback != nil {
+		r.callback(m, err)
+	}
+	close(r.done)
+}
+
+// DeliveryStats counters of the delivery reports
+type DeliveryStats struct {
+	Delivered uint64 `json:"delivered"`
+	// Failed messages failed to deliver or refused by the producer
+	Failed uint64 `json:"failed"`
+	// Pending messages pushed by PushAsync waiting for their reports
+	Pending int64 `json:"pending"`
+	// Errors producer level errors, such as all brokers down
+	Errors uint64 `json:"errors"`
+}
+
+// Dispatcher drain the Events channel of a producer in background,
+// completing the results of PushAsync and counting the delivery reports
+// one dispatcher per producer, the Events channel should not be read by others
+type Dispatcher struct {
+	p *kafka.Producer
+
+	delivered uint64
+	failed    uint64
+	errors    uint64
+	pending   int64
+
+	m         sync.RWMutex
+	onFailure func(*kafka.Message, error)
+	onError   func(kafka.Error)
+
+	// results pushed and not yet reported, failed when the producer closed
+	results sync.Map
+	closed  chan struct{}
+}
+
+// dispatchers dispatcher of each producer
+var dispatchers sync.Map
+
+// Dispatch the dispatcher of the producer, started at the first call
+func Dispatch(p *kafka.Producer) *Dispatcher {
+	if v, ok := dispatchers.Load(p); ok {
+		return v.(*Dispatcher)
+	}
+	d := &Dispatcher{p: p, closed: make(chan struct{})}
+	if v, loaded := dispatchers.LoadOrStore(p, d); loaded {
+		return v.(*Dispatcher)
+	}
+	go d.run()
+	return d
+}
+
+// OnFailure hook called for each message failed to deliver, used for logging or dead-lettering
+func (d *Dispatcher) OnFailure(hook func(m *kafka.Message, err error)) {
+	d.m.Lock()
+	d.onFailure = hook
+	d.m.Unlock()
+}
+
+// OnError hook called for the producer level errors
+func (d *Dispatcher) OnError(hook func(err kafka.Error)) {
+	d.m.Lock()
+	d.onError = hook
+	d.m.Unlock()
+}
+
+// Stats counters of the delivery reports
+func (d *Dispatcher) Stats() DeliveryStats {
+	return DeliveryStats{
+		Delivered: atomic.LoadUint64(&d.delivered),
+		Failed:    atomic.LoadUint64(&d.failed),
+		Pending:   atomic.LoadInt64(&d.pending),
+		Errors:    atomic.LoadUint64(&d.errors),
+	}
+}
+
+// Closed closed when the producer closed and the dispatcher exited
+func (d *Dispatcher) Closed() <-chan struct{} {
+	return d.closed
+}
+
+// run handle the events until the producer closed
+func (d *Dispatcher) run() {
+	defer func() {
+		d.results.Range(func(key, _ interface{}) bool {
+			d.results.Delete(key)
+			atomic.AddInt64(&d.pending, -1)
+			key.(*Result).complete(nil, ErrProducerClosed)
+			return true
+		})
+		dispatchers.Delete(d.p)
+		partitioners.Delete(d.p)
+		close(d.closed)
+	}()
+
+	for e := range d.p.Events() {
+		switch ev := e.(type) {
+		case *kafka.Message:
+			d.report(ev)
+		case kafka.Error:
+			atomic.AddUint64(&d.errors, 1)
+			d.m.RLock()
+			hook := d.onError
+			d.m.RUnlock()
+			if hook != nil {
+				hook(ev)
+			}
+		}
+	}
+}
+
+// report count the delivery report and complete its result
+func (d *Dispatcher) report(m *kafka.Message) {
+	err := wrap(OpDelivery, m.TopicPartition.Error)
+	if err == nil {
+		atomic.AddUint64(&d.delivered, 1)
+	} else {
+		d.fail(m, err)
+	}
+
+	if r, ok := m.Opaque.(*Result); ok {
+		if _, pending := d.results.LoadAndDelete(r); pending {
+			atomic.AddInt64(&d.pending, -1)
+			r.complete(m, err)
+		}
+	}
+}
+
+// fail count the message failed to deliver or refused by the producer and call the failure hook
+func (d *Dispatcher) fail(m *kafka.Message, err error) {
+	atomic.AddUint64(&d.failed, 1)
+	d.m.RLock()
+	hook := d.onFailure
+	d.m.RUnlock()
+	if hook != nil {
+		hook(m, err)
+	}
+}
+
+// WithDeliveryCallback called once with the outcome of PushAsync: the delivery report,
+// or the error before the message reached the producer queue, on the calling goroutine then
+func WithDeliveryCallback(cb func(m *kafka.Message, err error)) PushOptions {
+	return func(o *pushOption) {
+		o.callback = cb
+	}
+}
+
+// PushAsync push the message without waiting, the delivery report completes the result
+// errors before producing, such as ErrEmptyTopic or a full queue, complete the result immediately,
+// in both cases the callback WithDeliveryCallback is called once with the outcome
+// the messages refused by the producer are counted as failed by the Dispatcher, the invalid options are not
+func PushAsync(opts ...PushOptions) *Result {
+	opt, err := newPushOption(opts)
+	if err != nil {
+		r := &Result{done: make(chan struct{}), callback: opt.callback}
+		r.complete(nil, err)
+		return r
+	}
+	return pushAsync(opt)
+}
+
+// pushAsync push the message of the parsed options, see PushAsync
+func pushAsync(opt *pushOption) *Result {
+	message := opt.message()
+	r, err := produceAsync(opt.p, message, opt.callback)
+	if err != nil {
+		Dispatch(opt.p).fail(message, err)
+		r = &Result{done: make(chan struct{}), callback: opt.callback}
+		r.complete(message, err)
+	}
+	return r
+}
+
+// produceAsync produce message whose delivery report completes the result and calls callback,
+// when producing failed the error is returned instead, neither counted nor passed to callback,
+// so that the caller can try again before reporting it
+func produceAsync(p *kafka.Producer, message *kafka.Message, callback func(*kafka.Message, error)) (*Result, error) {
+	r := &Result{done: make(chan struct{}), callback: callback}
+	d := Dispatch(p)
+	message.Opaque = r
+
+	d.results.Store(r, struct{}{})
+	atomic.AddInt64(&d.pending, 1)
+	if err := p.Produce(message, nil); err != nil {
+		d.results.Delete(r)
+		atomic.AddInt64(&d.pending, -1)
+		message.Opaque = nil
+		return nil, wrap(OpProduce, err)
+	}
+	return r, nil
+}
@@ -0,0 +1,151 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// newMockProducer producer of an in-process mock cluster, messages over 10000 bytes are refused
+func newMockProducer(t *testing.T) *kafka.Producer {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"test.mock.num.brokers": 1,
+		"message.max.bytes":     10000,
+	})
+	if err != nil {
+		t.Skipf("mock cluster: %v", err)
+	}
+	t.Cleanup(p.Close)
+	return p
+}
+
+// reports outcomes passed to the delivery callback and the failure hook
+type reports struct {
+	m        sync.Mutex
+	callback []error
+	failures []error
+}
+
+func (r *reports) onCallback(_ *kafka.Message, err error) {
+	r.m.Lock()
+	r.callback = append(r.callback, err)
+	r.m.Unlock()
+}
+
+func (r *reports) onFailure(_ *kafka.Message, err error) {
+	r.m.Lock()
+	r.failures = append(r.failures, err)
+	r.m.Unlock()
+}
+
+func TestPushAsync(t *testing.T) {
+	tests := []struct {
+		name  string
+		topic string
+		size  int
+		// err the result, zero Op when not an *Error
+		err       error
+		op        string
+		delivered uint64
+		failed    uint64
+	}{
+		{"delivered", "events", 100, nil, "", 1, 0},
+		{"refused by producer", "events", 20000, nil, OpProduce, 0, 1},
+		// invalid options, not counted
+		{"empty topic", "", 100, ErrEmptyTopic, "", 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newMockProducer(t)
+			d := Dispatch(p)
+			var got reports
+			d.OnFailure(got.onFailure)
+
+			r := PushAsync(WithProducer(p), WithTopic(tt.topic), WithBuffer(make([]byte, tt.size)),
+				WithDeliveryCallback(got.onCallback))
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			m, err := r.Wait(ctx)
+
+			var e *Error
+			switch {
+			case tt.op != "":
+				if !errors.As(err, &e) || e.Op != tt.op {
+					t.Errorf("Wait() error %v, want %s error", err, tt.op)
+				}
+			case err != tt.err:
+				t.Errorf("Wait() error %v, want %v", err, tt.err)
+			}
+			if err == nil && (m == nil || m.TopicPartition.Offset < 0) {
+				t.Errorf("delivered message %v", m)
+			}
+
+			got.m.Lock()
+			defer got.m.Unlock()
+			if len(got.callback) != 1 || got.callback[0] != err {
+				t.Errorf("callback called with %v, want once with %v", got.callback, err)
+			}
+			if uint64(len(got.failures)) != tt.failed {
+				t.Errorf("failure hook called with %v, want %d times", got.failures, tt.failed)
+			}
+			s := d.Stats()
+			if s.Delivered != tt.delivered || s.Failed != tt.failed || s.Pending != 0 {
+				t.Errorf("stats %+v", s)
+			}
+		})
+	}
+}
+
+func TestPushRefused(t *testing.T) {
+	p := newMockProducer(t)
+	var got reports
+	Dispatch(p).OnFailure(got.onFailure)
+
+	err := Push(context.Background(), WithProducer(p), WithTopic("events"), WithBuffer(make([]byte, 20000)))
+	if err == nil {
+		t.Fatal("too large message pushed")
+	}
+	if s := Dispatch(p).Stats(); s.Failed != 1 || len(got.failures) != 1 {
+		t.Errorf("stats %+v, failure hook called %d times", s, len(got.failures))
+	}
+}
+
+func TestDispatcherClosed(t *testing.T) {
+	// no broker answers, the message waits in the queue until the producer closed
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"bootstrap.servers":  "127.0.0.1:1",
+		"message.timeout.ms": 60000,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := Dispatch(p)
+	var got reports
+	r := PushAsync(WithProducer(p), WithTopic("events"), WithBuffer([]byte("a")),
+		WithDeliveryCallback(got.onCallback))
+	if s := d.Stats(); s.Pending != 1 {
+		t.Errorf("stats before close %+v", s)
+	}
+
+	p.Close()
+	select {
+	case <-d.Closed():
+	case <-time.After(5 * time.Second):
+		t.Fatal("dispatcher not closed")
+	}
+	if _, err := r.Wait(context.Background()); err == nil {
+		t.Error("message undelivered before close reported as delivered")
+	}
+	if s := d.Stats(); s.Pending != 0 || s.Delivered != 0 {
+		t.Errorf("stats after close %+v", s)
+	}
+	got.m.Lock()
+	defer got.m.Unlock()
+	if len(got.callback) != 1 {
+		t.Errorf("callback called %d times, want 1", len(got.callback))
+	}
+}
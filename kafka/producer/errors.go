@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var (
+	ErrEmptyTopic  = errors.New("producer: topic empty")
+	ErrNilProducer = errors.New("producer: kafka producer client should be initialised")
+)
+
+// operations failed, carried by Error
+const (
+	OpConfig   = "config"
+	OpNew      = "new"
+	OpProduce  = "produce"
+	OpDelivery = "delivery"
+	// OpTransaction transactional operations, see Transactional
+	OpTransaction = "transaction"
+)
+
+// Error failure of the producer, Retriable tells whether trying again may succeed,
+// Fatal that the producer instance is no longer usable
+// and Abortable that the current transaction must be aborted
+type Error struct {
+	Op        string
+	Code      kafka.ErrorCode
+	Retriable bool
+	Fatal     bool
+	Abortable bool
+	Err       error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("producer: %s: %v", e.Op, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// retriableCodes broker and local errors which go away by themselves
+var retriableCodes = map[kafka.ErrorCode]bool{
+	kafka.ErrQueueFull:                    true,
+	kafka.ErrTimedOut:                     true,
+	kafka.ErrMsgTimedOut:                  true,
+	kafka.ErrTransport:                    true,
+	kafka.ErrAllBrokersDown:               true,
+	kafka.ErrLeaderNotAvailable:           true,
+	kafka.ErrNotLeaderForPartition:        true,
+	kafka.ErrRequestTimedOut:              true,
+	kafka.ErrNetworkException:             true,
+	kafka.ErrNotEnoughReplicas:            true,
+	kafka.ErrNotEnoughReplicasAfterAppend: true,
+	kafka.ErrKafkaStorageError:            true,
+	kafka.ErrNotCoordinator:               true,
+	kafka.ErrCoordinatorLoadInProgress:    true,
+	kafka.ErrUnknownTopicOrPart:           true,
+}
+
+// wrap classify err of the operation, nil stays nil
+func wrap(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	e := &Error{Op: op, Err: err}
+	var ke kafka.Error
+	if errors.As(err, &ke) {
+		e.Code = ke.Code()
+		e.Fatal = ke.IsFatal()
+		e.Retriable = !e.Fatal && (ke.IsRetriable() || retriableCodes[e.Code])
+		e.Abortable = ke.TxnRequiresAbort()
+	}
+	return e
+}
+
+// IsRetriable whether the failed operation may succeed when tried again
+func IsRetriable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Retriable
+}
+
+// IsAbortable whether the current transaction must be aborted before going on
+func IsAbortable(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Abortable
+}
+
+// IsFatal whether the producer is no longer usable and should be recreated
+func IsFatal(err error) bool {
+	var e *Error
+	return errors.As(err, &e) && e.Fatal
+}
@@ -0,0 +1,96 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestWrap(t *testing.T) {
+	broken := errors.New("broken")
+	tests := []struct {
+		name                        string
+		err                         error
+		retriable, fatal, abortable bool
+		code                        kafka.ErrorCode
+	}{
+		{"queue full", kafka.NewError(kafka.ErrQueueFull, "full", false), true, false, false, kafka.ErrQueueFull},
+		{"message too large", kafka.NewError(kafka.ErrMsgSizeTooLarge, "large", false), false, false, false, kafka.ErrMsgSizeTooLarge},
+		// fatal errors are never retried
+		{"fatal", kafka.NewError(kafka.ErrTimedOut, "fenced", true), false, true, false, kafka.ErrTimedOut},
+		{"not kafka", broken, false, false, false, kafka.ErrNoError},
+	}
+	for _, tt := range tests {
+		err := fmt.Errorf("context: %w", wrap(OpProduce, tt.err))
+		var e *Error
+		if !errors.As(err, &e) || e.Op != OpProduce || e.Code != tt.code || !errors.Is(err, tt.err) {
+			t.Errorf("%s: wrapped %#v", tt.name, e)
+		}
+		if IsRetriable(err) != tt.retriable || IsFatal(err) != tt.fatal || IsAbortable(err) != tt.abortable {
+			t.Errorf("%s: retriable %v fatal %v abortable %v", tt.name, IsRetriable(err), IsFatal(err), IsAbortable(err))
+		}
+	}
+	if wrap(OpProduce, nil) != nil {
+		t.Error("nil wrapped")
+	}
+}
+
+func TestNewProducerE(t *testing.T) {
+	tests := []struct {
+		name    string
+		options map[string]interface{}
+		op      string
+	}{
+		{"mock cluster", map[string]interface{}{"test.mock.num.brokers": 1}, ""},
+		{"unknown property", map[string]interface{}{"no.such.property": 1}, OpNew},
+		{"idempotence without acks all", map[string]interface{}{"enable.idempotence": true, "acks": 1}, OpConfig},
+	}
+	for _, tt := range tests {
+		p, err := NewProducerE(WithOtherOptions(tt.options))
+		var e *Error
+		switch {
+		case tt.op == "" && err != nil:
+			t.Errorf("%s: %v", tt.name, err)
+		case tt.op != "" && (!errors.As(err, &e) || e.Op != tt.op):
+			t.Errorf("%s: error %v, want %s error", tt.name, err, tt.op)
+		}
+		if p != nil {
+			p.Close()
+		}
+	}
+}
+
+func TestPushInvalid(t *testing.T) {
+	p := newMockProducer(t)
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		opts []PushOptions
+		err  error
+	}{
+		{"empty topic", context.Background(), []PushOptions{WithProducer(p)}, ErrEmptyTopic},
+		{"nil producer", context.Background(), []PushOptions{WithTopic("events")}, ErrNilProducer},
+		{"cancelled", cancelled, []PushOptions{WithProducer(p), WithTopic("events")}, context.Canceled},
+		{"cancelled waiting", cancelled, []PushOptions{WithProducer(p), WithTopic("events"), WithWaitResult(true)}, context.Canceled},
+	}
+	for _, tt := range tests {
+		if err := Push(tt.ctx, tt.opts...); !errors.Is(err, tt.err) {
+			t.Errorf("%s: Push error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPushMessagePanics(t *testing.T) {
+	defer func() {
+		if r := recover(); r != ErrEmptyTopic {
+			t.Errorf("recovered %v, want %v", r, ErrEmptyTopic)
+		}
+	}()
+	PushMessage(WithProducer(newMockProducer(t)))
+}
@@ -0,0 +1,31 @@
+package producer
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// DefaultMaxHeaderBytes default limit of the total size of the headers of one message
+const DefaultMaxHeaderBytes = 64 * 1024
+
+var (
+	ErrEmptyHeaderKey = errors.New("producer: header key empty")
+	ErrHeaderTooLarge = errors.New("producer: headers too large")
+)
+
+// validateHeaders check the keys and the total size of headers
+func validateHeaders(headers []kafka.Header, max int) error {
+	size := 0
+	for _, h := range headers {
+		if h.Key == "" {
+			return ErrEmptyHeaderKey
+		}
+		size += len(h.Key) + len(h.Value)
+	}
+	if max > 0 && size > max {
+		return fmt.Errorf("%w: %d bytes, limit %d", ErrHeaderTooLarge, size, max)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package producer
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestValidateHeaders(t *testing.T) {
+	tests := []struct {
+		name    string
+		headers []kafka.Header
+		max     int
+		err     error
+	}{
+		{"none", nil, 10, nil},
+		{"within limit", []kafka.Header{{Key: "ab", Value: []byte("cd")}, {Key: "e", Value: nil}}, 5, nil},
+		{"at limit", []kafka.Header{{Key: "ab", Value: []byte("cde")}}, 5, nil},
+		{"over limit", []kafka.Header{{Key: "ab", Value: []byte("cdef")}}, 5, ErrHeaderTooLarge},
+		{"no limit", []kafka.Header{{Key: "k", Value: make([]byte, 1<<20)}}, 0, nil},
+		{"empty key", []kafka.Header{{Key: "", Value: []byte("v")}}, 10, ErrEmptyHeaderKey},
+	}
+	for _, tt := range tests {
+		if err := validateHeaders(tt.headers, tt.max); !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+func TestPushHeaders(t *testing.T) {
+	p := newMockProducer(t)
+	large := strings.Repeat("x", DefaultMaxHeaderBytes)
+
+	tests := []struct {
+		name string
+		opts []PushOptions
+		want []kafka.Header
+		err  error
+	}{
+		{"in order", []PushOptions{
+			WithHeader("trace", "t1"),
+			WithHeaders(kafka.Header{Key: "type", Value: []byte("json")}, kafka.Header{Key: "trace", Value: []byte("t2")}),
+		}, []kafka.Header{
+			{Key: "trace", Value: []byte("t1")}, {Key: "type", Value: []byte("json")}, {Key: "trace", Value: []byte("t2")},
+		}, nil},
+		{"default limit", []PushOptions{WithHeader("k", large)}, nil, ErrHeaderTooLarge},
+		{"raised limit", []PushOptions{WithHeader("k", large), WithMaxHeaderBytes(2 * DefaultMaxHeaderBytes)},
+			[]kafka.Header{{Key: "k", Value: []byte(large)}}, nil},
+	}
+	for _, tt := range tests {
+		opt, err := newPushOption(append([]PushOptions{WithProducer(p), WithTopic("events")}, tt.opts...))
+		if !errors.Is(err, tt.err) {
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := opt.message().Headers; !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: headers %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
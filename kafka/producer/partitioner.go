@@ -0,0 +1,311 @@
+package producer
+
+import (
+	"math/rand"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// Partitioner choose the partition of the message among the partitions of topic,
+// a result out of [0, partitions) leaves the choice to librdkafka
+type Partitioner interface {
+	Partition(topic string, key []byte, partitions int32) int32
+}
+
+// PartitionerFunc custom partitioner
+type PartitionerFunc func(topic string, key []byte, partitions int32) int32
+
+func (f PartitionerFunc) Partition(topic string, key []byte, partitions int32) int32 {
+	return f(topic, key, partitions)
+}
+
+// Murmur2 hash of the Java client, used by its default partitioner
+func Murmur2(data []byte) int32 {
+	const (
+		seed uint32 = 0x9747b28c
+		m    uint32 = 0x5bd1e995
+		r           = 24
+	)
+	length := len(data)
+	h := seed ^ uint32(length)
+	for i := 0; i+4 <= length; i += 4 {
+		k := uint32(data[i]) | uint32(data[i+1])<<8 | uint32(data[i+2])<<16 | uint32(data[i+3])<<24
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	tail := length &^ 3
+	switch length % 4 {
+	case 3:
+		h ^= uint32(data[tail+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[tail+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[tail])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return int32(h)
+}
+
+// murmur2Partition partition of the Java client for key
+func murmur2Partition(key []byte, partitions int32) int32 {
+	return (Murmur2(key) & 0x7fffffff) % partitions
+}
+
+type murmur2Partitioner struct {
+	keyless Partitioner
+}
+
+// Murmur2Partitioner partition the keys like the Java client,
+// keyless messages are spread round-robin
+func Murmur2Partitioner() Partitioner {
+	return &murmur2Partitioner{keyless: RoundRobinPartitioner()}
+}
+
+func (p *murmur2Partitioner) Partition(topic string, key []byte, partitions int32) int32 {
+	if key == nil {
+		return p.keyless.Partition(topic, key, partitions)
+	}
+	return murmur2Partition(key, partitions)
+}
+
+type roundRobinPartitioner struct {
+	next sync.Map
+}
+
+// RoundRobinPartitioner spread the messages over the partitions in turn, keys are ignored
+func RoundRobinPartitioner() Partitioner {
+	return &roundRobinPartitioner{}
+}
+
+func (p *roundRobinPartitioner) Partition(topic string, _ []byte, partitions int32) int32 {
+	v, _ := p.next.LoadOrStore(topic, new(uint32))
+	return int32((atomic.AddUint32(v.(*uint32), 1) - 1) % uint32(partitions))
+}
+
+type sticky struct {
+	partition int32
+	left      int
+}
+
+type stickyPartitioner struct {
+	batch int
+
+	m      sync.Mutex
+	rand   *rand.Rand
+	topics map[string]*sticky
+}
+
+// StickyPartitioner send batch keyless messages to one partition before switching to
+// another one at random, filling larger batches, keys are hashed like Murmur2Partitioner
+func StickyPartitioner(batch int) Partitioner {
+	if batch < 1 {
+		batch = 1
+	}
+	return &stickyPartitioner{
+		batch:  batch,
+		rand:   rand.New(rand.NewSource(time.Now().UnixNano())),
+		topics: make(map[string]*sticky),
+	}
+}
+
+func (p *stickyPartitioner) Partition(topic string, key []byte, partitions int32) int32 {
+	if key != nil {
+		return murmur2Partition(key, partitions)
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	s, ok := p.topics[topic]
+	if !ok {
+		s = &sticky{partition: -1}
+		p.topics[topic] = s
+	}
+	if s.left <= 0 || s.partition >= partitions {
+		next := p.rand.Int31n(partitions)
+		if next == s.partition && partitions > 1 {
+			next = (next + 1) % partitions
+		}
+		s.partition, s.left = next, p.batch
+	}
+	s.left--
+	return s.partition
+}
+
+type consistentPartitioner struct {
+	replicas int
+	keyless  Partitioner
+	// rings hash ring of each partition count
+	rings sync.Map
+}
+
+type ringPoint struct {
+	hash      uint32
+	partition int32
+}
+
+// ConsistentPartitioner hash the keys onto a ring of replicas virtual nodes per partition,
+// adding partitions moves about 1/n of the keys, keyless messages are spread round-robin
+func ConsistentPartitioner(replicas int) Partitioner {
+	if replicas < 1 {
+		replicas = 100
+	}
+	return &consistentPartitioner{replicas: replicas, keyless: RoundRobinPartitioner()}
+}
+
+func (p *consistentPartitioner) Partition(topic string, key []byte, partitions int32) int32 {
+	if key == nil {
+		return p.keyless.Partition(topic, key, partitions)
+	}
+	ring := p.ring(partitions)
+	h := uint32(Murmur2(key))
+	i := sort.Search(len(ring), func(i int) bool {
+		return ring[i].hash >= h
+	})
+	if i == len(ring) {
+		i = 0
+	}
+	return ring[i].partition
+}
+
+// ring the hash ring of the partition count, built at the first use
+func (p *consistentPartitioner) ring(partitions int32) []ringPoint {
+	if v, ok := p.rings.Load(partitions); ok {
+		return v.([]ringPoint)
+	}
+	ring := make([]ringPoint, 0, int(partitions)*p.replicas)
+	for partition := int32(0); partition < partitions; partition++ {
+		for r := 0; r < p.replicas; r++ {
+			node := strconv.Itoa(int(partition)) + "-" + strconv.Itoa(r)
+			ring = append(ring, ringPoint{hash: uint32(Murmur2([]byte(node))), partition: partition})
+		}
+	}
+	sort.Slice(ring, func(i, j int) bool {
+		return ring[i].hash < ring[j].hash
+	})
+	p.rings.Store(partitions, ring)
+	return ring
+}
+
+var (
+	// MetadataTTL refresh interval of the cached partition counts
+	MetadataTTL = 5 * time.Minute
+	// MetadataRetry interval before retrying the failed metadata requests
+	MetadataRetry = 10 * time.Second
+	// MetadataTimeout timeout of one metadata request
+	MetadataTimeout = time.Second
+)
+
+type partitionCount struct {
+	n       int32
+	expires time.Time
+	// fetched closed when the first metadata request of the topic completed
+	fetched chan struct{}
+	// refreshing a metadata request of the topic is running
+	refreshing bool
+}
+
+// partitioning partitioner of a producer and the partition counts of its topics
+type partitioning struct {
+	partitioner Partitioner
+
+	m      sync.Mutex
+	counts map[string]*partitionCount
+}
+
+// partitioners partitioning of each producer
+var partitioners sync.Map
+
+// WithPartitioner partitioner of the producer, used when no partition is given
+func WithPartitioner(partitioner Partitioner) NewOptions {
+	return func(o *newOption) {
+		o.partitioner = partitioner
+	}
+}
+
+// SetPartitioner set the partitioner of the producer, nil leaves the choice to librdkafka
+func SetPartitioner(p *kafka.Producer, partitioner Partitioner) {
+	if partitioner == nil {
+		partitioners.Delete(p)
+		return
+	}
+	partitioners.Store(p, &partitioning{partitioner: partitioner, counts: make(map[string]*partitionCount)})
+}
+
+// partitionOf the partition chosen by the partitioner of p, kafka.PartitionAny without one
+// or when the partition count of topic is unknown
+func partitionOf(p *kafka.Producer, topic string, key []byte) int32 {
+	v, ok := partitioners.Load(p)
+	if !ok {
+		return kafka.PartitionAny
+	}
+	pt := v.(*partitioning)
+	n := pt.partitions(p, topic)
+	if n <= 0 {
+		return kafka.PartitionAny
+	}
+	if partition := pt.partitioner.Partition(topic, key, n); partition >= 0 && partition < n {
+		return partition
+	}
+	return kafka.PartitionAny
+}
+
+// partitions the cached partition count of topic, 0 when the metadata failed
+// failures are cached as well so that a missing topic does not block every message
+// one caller requests the metadata of a topic at a time, without holding the lock,
+// the others wait for the first request of the topic or go on with the stale count
+func (pt *partitioning) partitions(p *kafka.Producer, topic string) int32 {
+	pt.m.Lock()
+	c, ok := pt.counts[topic]
+	if !ok {
+		c = &partitionCount{fetched: make(chan struct{}), refreshing: true}
+		pt.counts[topic] = c
+		pt.m.Unlock()
+		return pt.refresh(p, topic, c)
+	}
+	if !c.refreshing && !time.Now().Before(c.expires) {
+		c.refreshing = true
+		pt.m.Unlock()
+		return pt.refresh(p, topic, c)
+	}
+	fetched := c.fetched
+	pt.m.Unlock()
+
+	<-fetched
+	pt.m.Lock()
+	defer pt.m.Unlock()
+	return c.n
+}
+
+// refresh request the partition count of topic into c
+func (pt *partitioning) refresh(p *kafka.Producer, topic string, c *partitionCount) int32 {
+	n, ttl := int32(0), MetadataRetry
+	metadata, err := p.GetMetadata(&topic, false, int(MetadataTimeout/time.Millisecond))
+	if err == nil {
+		if tm, ok := metadata.Topics[topic]; ok && tm.Error.Code() == kafka.ErrNoError {
+			n, ttl = int32(len(tm.Partitions)), MetadataTTL
+		}
+	}
+
+	pt.m.Lock()
+	defer pt.m.Unlock()
+	c.n, c.expires, c.refreshing = n, time.Now().Add(ttl), false
+	select {
+	case <-c.fetched:
+	default:
+		close(c.fetched)
+	}
+	return n
+}
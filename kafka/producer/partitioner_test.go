@@ -0,0 +1,36 @@
+package producer
+
+import "testing"
+
+// vectors of org.apache.kafka.common.utils.UtilsTest#testMurmur2
+func TestMurmur2(t *testing.T) {
+	tests := []struct {
+		data string
+		want int32
+	}{
+		{"21", -973932308},
+		{"foobar", -790332482},
+		{"a-little-bit-long-string", -985981536},
+		{"a-little-bit-longer-string", -1486304829},
+		{"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8", -58897971},
+		{"abc", 479470107},
+		{"", 275646681},
+	}
+	for _, tt := range tests {
+		if got := Murmur2([]byte(tt.data)); got != tt.want {
+			t.Errorf("Murmur2(%q) = %d, want %d", tt.data, got, tt.want)
+		}
+	}
+}
+
+func TestMurmur2Partitioner(t *testing.T) {
+	p := Murmur2Partitioner()
+	for _, key := range []string{"21", "foobar", "abc", ""} {
+		want := (Murmur2([]byte(key)) & 0x7fffffff) % 12
+		for i := 0; i < 3; i++ {
+			if got := p.Partition("topic", []byte(key), 12); got != want {
+				t.Errorf("Partition(%q) = %d, want %d", key, got, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,348 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// ErrInvalidConfig settings conflicting with idempotence or transactions
+var ErrInvalidConfig = errors.New("producer: invalid config")
+
+// WithIdempotence enable.idempotence, the dependent settings are validated
+// and set to the required values when not given
+func WithIdempotence(enable bool) NewOptions {
+	return func(o *newOption) {
+		o.idempotence = enable
+	}
+}
+
+// WithTransactionalId transactional.id of the producer, implies idempotence
+func WithTransactionalId(id string) NewOptions {
+	return func(o *newOption) {
+		o.transactionalId = id
+	}
+}
+
+// configureIdempotence set and validate the settings idempotence depends on
+func configureIdempotence(c *kafka.ConfigMap, opt *newOption) error {
+	if opt.transactionalId != "" {
+		if v, ok := (*c)["enable.idempotence"]; ok && !truthy(v) {
+			return invalid("transactional.id requires enable.idempotence")
+		}
+		opt.idempotence = true
+		(*c)["transactional.id"] = opt.transactionalId
+	}
+	if v, ok := (*c)["enable.idempotence"]; ok && truthy(v) {
+		opt.idempotence = true
+	}
+	if !opt.idempotence {
+		return nil
+	}
+	(*c)["enable.idempotence"] = true
+
+	if v, ok := (*c)["acks"]; ok {
+		if s := fmt.Sprint(v); s != "all" && s != "-1" {
+			return invalid("enable.idempotence requires acks=all, got " + s)
+		}
+	} else {
+		(*c)["acks"] = "all"
+	}
+	if v, ok := (*c)["max.in.flight.requests.per.connection"]; ok {
+		if n, err := strconv.Atoi(fmt.Sprint(v)); err != nil || n < 1 || n > 5 {
+			return invalid(fmt.Sprintf("enable.idempotence requires max.in.flight.requests.per.connection in [1, 5], got %v", v))
+		}
+	} else {
+		(*c)["max.in.flight.requests.per.connection"] = 5
+	}
+	for _, key := range []string{"retries", "message.send.max.retries"} {
+		if v, ok := (*c)[key]; ok {
+			if n, err := strconv.Atoi(fmt.Sprint(v)); err != nil || n < 1 {
+				return invalid(fmt.Sprintf("enable.idempotence requires %s > 0, got %v", key, v))
+			}
+		}
+	}
+	return nil
+}
+
+func truthy(v kafka.ConfigValue) bool {
+	b, err := strconv.ParseBool(fmt.Sprint(v))
+	return err == nil && b
+}
+
+func invalid(reason string) error {
+	return &Error{Op: OpConfig, Err: fmt.Errorf("%w: %s", ErrInvalidConfig, reason)}
+}
+
+// Transactional transactional API of a producer created WithTransactionalId
+// InitTransactions once, then Begin, produce, SendOffsetsToTransaction and Commit or Abort
+type Transactional struct {
+	p *kafka.Producer
+}
+
+// NewTransactional wrap the producer created WithTransactionalId
+func NewTransactional(p *kafka.Producer) *Transactional {
+	return &Transactional{p: p}
+}
+
+// Producer the wrapped producer, used with Push and Send inside transactions
+func (t *Transactional) Producer() *kafka.Producer {
+	return t.p
+}
+
+// InitTransactions register the transactional id and fence the older producers using it
+func (t *Transactional) InitTransactions(ctx context.Context) error {
+	return wrap(OpTransaction, t.p.InitTransactions(ctx))
+}
+
+// Begin start a transaction
+func (t *Transactional) Begin() error {
+	return wrap(OpTransaction, t.p.BeginTransaction())
+}
+
+// SendOffsetsToTransaction commit the consumer offsets with the transaction,
+// offsets are the next offsets to consume
+func (t *Transactional) SendOffsetsToTransaction(ctx context.Context, offsets []kafka.TopicPartition, c *kafka.Consumer) error {
+	metadata, err := c.GetConsumerGroupMetadata()
+	if err != nil {
+		return wrap(OpTransaction, err)
+	}
+	return wrap(OpTransaction, t.p.SendOffsetsToTransaction(ctx, offsets, metadata))
+}
+
+// Commit flush the messages and commit the transaction
+func (t *Transactional) Commit(ctx context.Context) error {
+	return wrap(OpTransaction, t.p.CommitTransaction(ctx))
+}
+
+// Abort the transaction, the messages produced in it are discarded
+func (t *Transactional) Abort(ctx context.Context) error {
+	return wrap(OpTransaction, t.p.AbortTransaction(ctx))
+}
+
+// TransformFunc the messages to produce for the consumed message, topic partitions are required
+type TransformFunc func(m *kafka.Message) ([]*kafka.Message, error)
+
+type transformOption struct {
+	batchSize     int
+	batchInterval time.Duration
+	backoff       time.Duration
+	maxBackoff    time.Duration
+	maxFailures   int
+}
+type TransformOptions func(*transformOption)
+
+// WithBatchSize max messages consumed in one transaction, 100 by default
+func WithBatchSize(n int) TransformOptions {
+	return func(o *transformOption) {
+		o.batchSize = n
+	}
+}
+
+// WithBatchInterval max time collecting one batch, 100ms by default
+func WithBatchInterval(d time.Duration) TransformOptions {
+	return func(o *transformOption) {
+		o.batchInterval = d
+	}
+}
+
+// WithRetryBackoff wait between the failed batches, doubled after each failure up to max,
+// 100ms and 5s by default
+func WithRetryBackoff(backoff, max time.Duration) TransformOptions {
+	return func(o *transformOption) {
+		o.backoff, o.maxBackoff = backoff, max
+	}
+}
+
+// WithMaxFailures failed batches in a row before giving up with the last error, 10 by default
+func WithMaxFailures(n int) TransformOptions {
+	return func(o *transformOption) {
+		o.maxFailures = n
+	}
+}
+
+// ConsumeTransformProduce consume topics, produce the transformed messages and commit
+// the consumed offsets in the same transaction until ctx done, exactly once
+// the consumer should be created with consumer.WithTransactional
+// aborted batches are consumed again after the backoff, error of fn aborts the batch and is returned
+// as well as the last error after WithMaxFailures batches failed in a row
+func ConsumeTransformProduce(ctx context.Context, t *Transactional, c *kafka.Consumer, topics []string, fn TransformFunc, opts ...TransformOptions) error {
+	opt := &transformOption{
+		batchSize:     100,
+		batchInterval: 100 * time.Millisecond,
+		backoff:       100 * time.Millisecond,
+		maxBackoff:    5 * time.Second,
+		maxFailures:   10,
+	}
+	for _, o := range opts {
+		o(opt)
+	}
+	if opt.backoff <= 0 {
+		opt.backoff = 100 * time.Millisecond
+	}
+	if opt.maxBackoff < opt.backoff {
+		opt.maxBackoff = opt.backoff
+	}
+
+	if err := c.SubscribeTopics(topics, nil); err != nil {
+		return err
+	}
+	Dispatch(t.p)
+
+	failures := 0
+	backoff := opt.backoff
+	for ctx.Err() == nil {
+		batch, err := readBatch(c, opt)
+		if err != nil {
+			return err
+		}
+		if len(batch) == 0 {
+			continue
+		}
+
+		err = transformBatch(ctx, t, c, batch, fn)
+		if err == nil {
+			failures, backoff = 0, opt.backoff
+			continue
+		}
+		if IsFatal(err) {
+			return err
+		}
+		abortErr := t.Abort(ctx)
+		if abortErr != nil && IsFatal(abortErr) {
+			return abortErr
+		}
+		if rewindErr := rewind(c, batch); rewindErr != nil {
+			return rewindErr
+		}
+		var e *Error
+		if !errors.As(err, &e) {
+			// error of fn
+			return err
+		}
+		if abortErr != nil {
+			err = abortErr
+		}
+
+		failures++
+		if opt.maxFailures > 0 && failures >= opt.maxFailures {
+			return fmt.Errorf("producer: %d transactions failed in a row: %w", failures, err)
+		}
+		if !sleep(ctx, backoff) {
+			break
+		}
+		if backoff *= 2; backoff > opt.maxBackoff {
+			backoff = opt.maxBackoff
+		}
+	}
+	return ctx.Err()
+}
+
+// sleep wait d, false when ctx done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// readBatch read messages until the batch full or interval passed, only fatal consumer errors are returned
+// the others, such as timeouts, the brokers down or the errors of a partition, go away by themselves
+func readBatch(c *kafka.Consumer, opt *transformOption) ([]*kafka.Message, error) {
+	var batch []*kafka.Message
+	deadline := time.Now().Add(opt.batchInterval)
+	for len(batch) < opt.batchSize {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		m, err := c.ReadMessage(wait)
+		if err != nil {
+			var ke kafka.Error
+			if errors.As(err, &ke) && ke.IsFatal() {
+				return nil, err
+			}
+			continue
+		}
+		batch = append(batch, m)
+	}
+	return batch, nil
+}
+
+// transformBatch produce the transformed batch and commit it with the consumed offsets
+func transformBatch(ctx context.Context, t *Transactional, c *kafka.Consumer, batch []*kafka.Message, fn TransformFunc) error {
+	if err := t.Begin(); err != nil {
+		return err
+	}
+	for _, m := range batch {
+		out, err := fn(m)
+		if err != nil {
+			return err
+		}
+		for _, o := range out {
+			if err = t.p.Produce(o, nil); err != nil {
+				return wrap(OpProduce, err)
+			}
+		}
+	}
+	if err := t.SendOffsetsToTransaction(ctx, nextOffsets(batch), c); err != nil {
+		return err
+	}
+	return t.Commit(ctx)
+}
+
+// nextOffsets the offsets after the last consumed message of each partition
+func nextOffsets(batch []*kafka.Message) []kafka.TopicPartition {
+	type key struct {
+		topic     string
+		partition int32
+	}
+	next := make(map[key]kafka.Offset)
+	var order []key
+	for _, m := range batch {
+		k := key{*m.TopicPartition.Topic, m.TopicPartition.Partition}
+		if _, ok := next[k]; !ok {
+			order = append(order, k)
+		}
+		if o := m.TopicPartition.Offset + 1; o > next[k] {
+			next[k] = o
+		}
+	}
+	offsets := make([]kafka.TopicPartition, 0, len(order))
+	for _, k := range order {
+		topic := k.topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: k.partition, Offset: next[k]})
+	}
+	return offsets
+}
+
+// rewind seek the partitions of batch back to the first consumed offsets
+func rewind(c *kafka.Consumer, batch []*kafka.Message) error {
+	first := make(map[string]map[int32]kafka.Offset)
+	for _, m := range batch {
+		topic := *m.TopicPartition.Topic
+		if first[topic] == nil {
+			first[topic] = make(map[int32]kafka.Offset)
+		}
+		if o, ok := first[topic][m.TopicPartition.Partition]; !ok || m.TopicPartition.Offset < o {
+			first[topic][m.TopicPartition.Partition] = m.TopicPartition.Offset
+		}
+	}
+	for topic, partitions := range first {
+		for partition, offset := range partitions {
+			topic := topic
+			if err := c.Seek(kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset}, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
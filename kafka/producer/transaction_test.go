@@ -0,0 +1,147 @@
+package producer
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+// newMockTransaction transactional producer of a mock cluster and a consumer of its own mock cluster,
+// only its group metadata is used
+func newMockTransaction(t *testing.T) (*Transactional, *kafka.Consumer) {
+	p, err := kafka.NewProducer(&kafka.ConfigMap{
+		"test.mock.num.brokers": 1,
+		"transactional.id":      "test",
+		"message.max.bytes":     10000,
+	})
+	if err != nil {
+		t.Skipf("mock cluster: %v", err)
+	}
+	t.Cleanup(p.Close)
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"test.mock.num.brokers": 1,
+		"group.id":              "test",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// closing waits 10s for the group coordinator of the mock cluster
+	t.Cleanup(func() { go c.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	tx := NewTransactional(p)
+	if err = tx.InitTransactions(ctx); err != nil {
+		t.Fatal(err)
+	}
+	return tx, c
+}
+
+func consumed(topic string, partition int32, offset kafka.Offset) *kafka.Message {
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: offset},
+		Value:          []byte("a"),
+	}
+}
+
+func TestTransformBatch(t *testing.T) {
+	tx, c := newMockTransaction(t)
+	d := Dispatch(tx.Producer())
+	broken := errors.New("broken")
+
+	tests := []struct {
+		name string
+		size int
+		err  error
+		// op of the *Error returned
+		op        string
+		delivered uint64
+	}{
+		{"committed", 100, nil, "", 1},
+		{"transform failed", 100, broken, "", 1},
+		{"refused by producer", 20000, nil, OpProduce, 1},
+		{"committed after aborted", 100, nil, "", 2},
+	}
+	for _, tt := range tests {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		batch := []*kafka.Message{consumed("in", 0, 5)}
+		err := transformBatch(ctx, tx, c, batch, func(m *kafka.Message) ([]*kafka.Message, error) {
+			if tt.err != nil {
+				return nil, tt.err
+			}
+			topic := "out"
+			return []*kafka.Message{{
+				TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+				Value:          make([]byte, tt.size),
+			}}, nil
+		})
+
+		var e *Error
+		switch {
+		case tt.op != "":
+			if !errors.As(err, &e) || e.Op != tt.op {
+				t.Errorf("%s: error %v, want %s error", tt.name, err, tt.op)
+			}
+		case err != tt.err:
+			t.Errorf("%s: error %v, want %v", tt.name, err, tt.err)
+		}
+		if err != nil {
+			if err = tx.Abort(ctx); err != nil {
+				t.Fatalf("%s: abort: %v", tt.name, err)
+			}
+		}
+
+		// delivery reports of the committed messages arrive after Commit returned
+		for deadline := time.Now().Add(5 * time.Second); d.Stats().Delivered < tt.delivered && time.Now().Before(deadline); {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if s := d.Stats(); s.Delivered != tt.delivered {
+			t.Errorf("%s: stats %+v, want %d delivered", tt.name, s, tt.delivered)
+		}
+		cancel()
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	c, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"test.mock.num.brokers": 1,
+		"group.id":              "test",
+	})
+	if err != nil {
+		t.Skipf("mock cluster: %v", err)
+	}
+	t.Cleanup(func() { go c.Close() })
+
+	// nothing subscribed, only timeouts are read
+	start := time.Now()
+	batch, err := readBatch(c, &transformOption{batchSize: 10, batchInterval: 50 * time.Millisecond})
+	if err != nil || len(batch) != 0 {
+		t.Errorf("readBatch = %v, %v", batch, err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("readBatch returned after %s, want the batch interval", d)
+	}
+}
+
+func TestNextOffsets(t *testing.T) {
+	batch := []*kafka.Message{
+		consumed("a", 0, 5),
+		consumed("a", 1, 7),
+		consumed("a", 0, 6),
+		consumed("b", 0, 1),
+		consumed("a", 1, 3),
+	}
+	a, b := "a", "b"
+	want := []kafka.TopicPartition{
+		{Topic: &a, Partition: 0, Offset: 7},
+		{Topic: &a, Partition: 1, Offset: 8},
+		{Topic: &b, Partition: 0, Offset: 2},
+	}
+	if got := nextOffsets(batch); !reflect.DeepEqual(got, want) {
+		t.Errorf("nextOffsets = %v, want %v", got, want)
+	}
+}
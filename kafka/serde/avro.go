@@ -0,0 +1,795 @@
+package serde
+
+import (
+	"encoding"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// ErrAvro value not matching the avro schema or malformed avro data
+var ErrAvro = errors.New("serde: avro")
+
+// AvroMaxItems max items of one decoded array or map, guards against malformed block counts
+var AvroMaxItems = 1 << 20
+
+// avroType parsed avro schema, named types are shared by their references
+type avroType struct {
+	kind string
+	name string
+	// aliases full names the named type was renamed from
+	aliases  []string
+	fields   []avroField
+	symbols  []string
+	items    *avroType
+	values   *avroType
+	branches []*avroType
+	size     int
+}
+
+type avroField struct {
+	name       string
+	typ        *avroType
+	def        interface{}
+	hasDefault bool
+}
+
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvro parse the avro schema
+func parseAvro(schema string) (*avroType, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return nil, fmt.Errorf("%w: schema: %v", ErrAvro, err)
+	}
+	return (&avroParser{named: make(map[string]*avroType)}).parse(v, "")
+}
+
+type avroParser struct {
+	named map[string]*avroType
+}
+
+func (p *avroParser) parse(v interface{}, namespace string) (*avroType, error) {
+	switch s := v.(type) {
+	case string:
+		if avroPrimitives[s] {
+			return &avroType{kind: s}, nil
+		}
+		if t, ok := p.named[fullName(s, namespace)]; ok {
+			return t, nil
+		}
+		if t, ok := p.named[s]; ok {
+			return t, nil
+		}
+		return nil, fmt.Errorf("%w: schema: unknown type %q", ErrAvro, s)
+	case []interface{}:
+		t := &avroType{kind: "union"}
+		for _, b := range s {
+			bt, err := p.parse(b, namespace)
+			if err != nil {
+				return nil, err
+			}
+			if bt.kind == "union" {
+				return nil, fmt.Errorf("%w: schema: nested union", ErrAvro)
+			}
+			t.branches = append(t.branches, bt)
+		}
+		return t, nil
+	case map[string]interface{}:
+		return p.parseComplex(s, namespace)
+	}
+	return nil, fmt.Errorf("%w: schema: invalid type %v", ErrAvro, v)
+}
+
+func (p *avroParser) parseComplex(s map[string]interface{}, namespace string) (*avroType, error) {
+	kind, _ := s["type"].(string)
+	if ns, ok := s["namespace"].(string); ok {
+		namespace = ns
+	}
+	name, _ := s["name"].(string)
+	// a dotted name carries its namespace, used by the types nested in it too
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		namespace = name[:i]
+	}
+	var aliases []string
+	if list, ok := s["aliases"].([]interface{}); ok {
+		for _, a := range list {
+			if str, ok := a.(string); ok {
+				aliases = append(aliases, fullName(str, namespace))
+			}
+		}
+	}
+
+	switch kind {
+	case "record", "error":
+		t := &avroType{kind: "record", name: fullName(name, namespace), aliases: aliases}
+		p.named[t.name] = t
+		fields, _ := s["fields"].([]interface{})
+		for _, f := range fields {
+			fm, ok := f.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%w: schema: invalid field of %s", ErrAvro, t.name)
+			}
+			ft, err := p.parse(fm["type"], namespace)
+			if err != nil {
+				return nil, err
+			}
+			field := avroField{typ: ft}
+			field.name, _ = fm["name"].(string)
+			field.def, field.hasDefault = fm["default"]
+			t.fields = append(t.fields, field)
+		}
+		return t, nil
+	case "enum":
+		t := &avroType{kind: "enum", name: fullName(name, namespace), aliases: aliases}
+		symbols, _ := s["symbols"].([]interface{})
+		for _, sym := range symbols {
+			str, _ := sym.(string)
+			t.symbols = append(t.symbols, str)
+		}
+		p.named[t.name] = t
+		return t, nil
+	case "fixed":
+		t := &avroType{kind: "fixed", name: fullName(name, namespace), aliases: aliases}
+		size, _ := s["size"].(float64)
+		t.size = int(size)
+		p.named[t.name] = t
+		return t, nil
+	case "array":
+		items, err := p.parse(s["items"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: "array", items: items}, nil
+	case "map":
+		values, err := p.parse(s["values"], namespace)
+		if err != nil {
+			return nil, err
+		}
+		return &avroType{kind: "map", values: values}, nil
+	}
+	// primitive with attributes such as logicalType
+	return p.parse(s["type"], namespace)
+}
+
+// fullName name qualified by the namespace unless already dotted
+func fullName(name, namespace string) string {
+	if namespace == "" || strings.Contains(name, ".") {
+		return name
+	}
+	return namespace + "." + name
+}
+
+// encode append the avro binary encoding of v
+func (t *avroType) encode(buf []byte, v interface{}) ([]byte, error) {
+	switch t.kind {
+	case "null":
+		if v != nil {
+			return nil, mismatch(t, v)
+		}
+		return buf, nil
+	case "boolean":
+		b, ok := v.(bool)
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		if b {
+			return append(buf, 1), nil
+		}
+		return append(buf, 0), nil
+	case "int", "long":
+		n, ok := toInt64(v)
+		if !ok || (t.kind == "int" && (n < math.MinInt32 || n > math.MaxInt32)) {
+			return nil, mismatch(t, v)
+		}
+		return appendVarint(buf, n), nil
+	case "float":
+		f, ok := toFloat64(v)
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		return appendUint32(buf, math.Float32bits(float32(f))), nil
+	case "double":
+		f, ok := toFloat64(v)
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		return appendUint64(buf, math.Float64bits(f)), nil
+	case "bytes", "string":
+		var b []byte
+		switch s := v.(type) {
+		case string:
+			b = []byte(s)
+		case []byte:
+			b = s
+		default:
+			return nil, mismatch(t, v)
+		}
+		buf = appendVarint(buf, int64(len(b)))
+		return append(buf, b...), nil
+	case "fixed":
+		b, ok := v.([]byte)
+		if !ok || len(b) != t.size {
+			return nil, mismatch(t, v)
+		}
+		return append(buf, b...), nil
+	case "enum":
+		s, _ := v.(string)
+		for i, sym := range t.symbols {
+			if sym == s {
+				return appendVarint(buf, int64(i)), nil
+			}
+		}
+		return nil, mismatch(t, v)
+	case "array":
+		items, ok := v.([]interface{})
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		if len(items) > 0 {
+			buf = appendVarint(buf, int64(len(items)))
+			for _, item := range items {
+				var err error
+				if buf, err = t.items.encode(buf, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+	case "map":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		if len(m) > 0 {
+			buf = appendVarint(buf, int64(len(m)))
+			for k, value := range m {
+				buf = appendVarint(buf, int64(len(k)))
+				buf = append(buf, k...)
+				var err error
+				if buf, err = t.values.encode(buf, value); err != nil {
+					return nil, err
+				}
+			}
+		}
+		return append(buf, 0), nil
+	case "record":
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, mismatch(t, v)
+		}
+		for _, f := range t.fields {
+			value, ok := m[f.name]
+			if !ok {
+				if !f.hasDefault {
+					return nil, fmt.Errorf("%w: field %s.%s missing", ErrAvro, t.name, f.name)
+				}
+				value = f.def
+				if f.typ.kind == "union" && len(f.typ.branches) > 0 {
+					// default of a union is of its first branch
+					var err error
+					if buf, err = appendBranch(buf, f.typ, 0, value); err != nil {
+						return nil, err
+					}
+					continue
+				}
+			}
+			var err error
+			if buf, err = f.typ.encode(buf, value); err != nil {
+				return nil, fmt.Errorf("field %s.%s: %w", t.name, f.name, err)
+			}
+		}
+		return buf, nil
+	case "union":
+		for i, b := range t.branches {
+			if b.matches(v) {
+				return appendBranch(buf, t, i, v)
+			}
+		}
+		return nil, mismatch(t, v)
+	}
+	return nil, fmt.Errorf("%w: unsupported type %s", ErrAvro, t.kind)
+}
+
+func appendBranch(buf []byte, union *avroType, i int, v interface{}) ([]byte, error) {
+	buf = appendVarint(buf, int64(i))
+	return union.branches[i].encode(buf, v)
+}
+
+func mismatch(t *avroType, v interface{}) error {
+	kind := t.kind
+	if t.name != "" {
+		kind += " " + t.name
+	}
+	return fmt.Errorf("%w: %T does not match %s", ErrAvro, v, kind)
+}
+
+// matches whether v can be encoded as t, used to choose the union branch
+func (t *avroType) matches(v interface{}) bool {
+	switch t.kind {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "int", "long":
+		_, ok := toInt64(v)
+		return ok
+	case "float", "double":
+		_, ok := toFloat64(v)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "bytes":
+		_, ok := v.([]byte)
+		return ok
+	case "fixed":
+		b, ok := v.([]byte)
+		return ok && len(b) == t.size
+	case "enum":
+		s, ok := v.(string)
+		if ok {
+			for _, sym := range t.symbols {
+				if sym == s {
+					return true
+				}
+			}
+		}
+		return false
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	case "map", "record":
+		_, ok := v.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch n := v.(type) {
+	case int:
+		return int64(n), true
+	case int8:
+		return int64(n), true
+	case int16:
+		return int64(n), true
+	case int32:
+		return int64(n), true
+	case int64:
+		return n, true
+	case uint8:
+		return int64(n), true
+	case uint16:
+		return int64(n), true
+	case uint32:
+		return int64(n), true
+	case uint:
+		return int64(n), n <= math.MaxInt64
+	case uint64:
+		return int64(n), n <= math.MaxInt64
+	case float64:
+		return int64(n), n == math.Trunc(n) && math.Abs(n) < 1<<63
+	case json.Number:
+		i, err := n.Int64()
+		return i, err == nil
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float32:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	i, ok := toInt64(v)
+	return float64(i), ok
+}
+
+// avroReader reader of the avro binary encoding
+type avroReader struct {
+	data []byte
+}
+
+var errAvroShort = fmt.Errorf("%w: data too short", ErrAvro)
+
+func (r *avroReader) varint() (int64, error) {
+	n, size := binary.Varint(r.data)
+	if size <= 0 {
+		return 0, errAvroShort
+	}
+	r.data = r.data[size:]
+	return n, nil
+}
+
+func (r *avroReader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.data) {
+		return nil, errAvroShort
+	}
+	b := r.data[:n:n]
+	r.data = r.data[n:]
+	return b, nil
+}
+
+// decode read the value of t, records and maps are map[string]interface{},
+// arrays []interface{} and unions the value of their branch
+func (t *avroType) decode(r *avroReader) (interface{}, error) {
+	switch t.kind {
+	case "null":
+		return nil, nil
+	case "boolean":
+		b, err := r.bytes(1)
+		if err != nil {
+			return nil, err
+		}
+		return b[0] != 0, nil
+	case "int":
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if n < math.MinInt32 || n > math.MaxInt32 {
+			return nil, fmt.Errorf("%w: int %d overflows 32 bits", ErrAvro, n)
+		}
+		return int32(n), nil
+	case "long":
+		return r.varint()
+	case "float":
+		b, err := r.bytes(4)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b)), nil
+	case "double":
+		b, err := r.bytes(8)
+		if err != nil {
+			return nil, err
+		}
+		return math.Float64frombits(binary.LittleEndian.Uint64(b)), nil
+	case "bytes", "string":
+		n, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		b, err := r.bytes(int(n))
+		if err != nil {
+			return nil, err
+		}
+		if t.kind == "string" {
+			return string(b), nil
+		}
+		return append([]byte(nil), b...), nil
+	case "fixed":
+		b, err := r.bytes(t.size)
+		if err != nil {
+			return nil, err
+		}
+		return append([]byte(nil), b...), nil
+	case "enum":
+		i, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(t.symbols) {
+			return nil, fmt.Errorf("%w: enum %s index %d", ErrAvro, t.name, i)
+		}
+		return t.symbols[i], nil
+	case "array":
+		items := []interface{}{}
+		err := r.blocks(t.items.minSize(0), func() error {
+			item, err := t.items.decode(r)
+			items = append(items, item)
+			return err
+		})
+		return items, err
+	case "map":
+		m := make(map[string]interface{})
+		// keys take 1 byte at least
+		err := r.blocks(1+t.values.minSize(0), func() error {
+			n, err := r.varint()
+			if err != nil {
+				return err
+			}
+			k, err := r.bytes(int(n))
+			if err != nil {
+				return err
+			}
+			m[string(k)], err = t.values.decode(r)
+			return err
+		})
+		return m, err
+	case "record":
+		m := make(map[string]interface{}, len(t.fields))
+		for _, f := range t.fields {
+			v, err := f.typ.decode(r)
+			if err != nil {
+				return nil, err
+			}
+			m[f.name] = v
+		}
+		return m, nil
+	case "union":
+		i, err := r.varint()
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 || int(i) >= len(t.branches) {
+			return nil, fmt.Errorf("%w: union index %d", ErrAvro, i)
+		}
+		return t.branches[i].decode(r)
+	}
+	return nil, fmt.Errorf("%w: unsupported type %s", ErrAvro, t.kind)
+}
+
+// blocks read the blocks of an array or map, negative counts are followed by the block size
+// counts the remaining data can not hold with items of size bytes at least are rejected
+func (r *avroReader) blocks(size int, item func() error) error {
+	total := int64(0)
+	for {
+		n, err := r.varint()
+		if err != nil {
+			return err
+		}
+		if n == 0 {
+			return nil
+		}
+		if n < 0 {
+			if n = -n; n < 0 {
+				return fmt.Errorf("%w: block count overflow", ErrAvro)
+			}
+			if _, err = r.varint(); err != nil {
+				return err
+			}
+		}
+		if size > 0 && n > int64(len(r.data)/size) {
+			return fmt.Errorf("%w: block of %d items exceeds the data", ErrAvro, n)
+		}
+		if total += n; total > int64(AvroMaxItems) {
+			return fmt.Errorf("%w: more than %d items", ErrAvro, AvroMaxItems)
+		}
+		for ; n > 0; n-- {
+			if err = item(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// minSize the least bytes of an encoded value of t, depth guards the recursive records
+func (t *avroType) minSize(depth int) int {
+	switch t.kind {
+	case "boolean", "int", "long", "bytes", "string", "enum", "array", "map", "union":
+		return 1
+	case "float":
+		return 4
+	case "double":
+		return 8
+	case "fixed":
+		return t.size
+	case "record":
+		if depth > 32 {
+			return 0
+		}
+		n := 0
+		for _, f := range t.fields {
+			n += f.typ.minSize(depth + 1)
+		}
+		return n
+	}
+	return 0
+}
+
+// native generic form of v: structs become map[string]interface{} keyed like their
+// json encoding, []byte stays bytes, values marshaling themselves go through json
+func native(v interface{}) (interface{}, error) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() {
+		rv = rv.Elem()
+	}
+	if rv.Kind() != reflect.Struct {
+		return v, nil
+	}
+	return nativeValue(rv)
+}
+
+var (
+	jsonMarshaler = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshaler = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+func nativeValue(rv reflect.Value) (interface{}, error) {
+	if !rv.IsValid() {
+		return nil, nil
+	}
+	if t := rv.Type(); t.Implements(jsonMarshaler) || t.Implements(textMarshaler) {
+		if rv.Kind() == reflect.Ptr && rv.IsNil() {
+			return nil, nil
+		}
+		return jsonNative(rv.Interface())
+	}
+
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if rv.IsNil() {
+			return nil, nil
+		}
+		return nativeValue(rv.Elem())
+	case reflect.Bool:
+		return rv.Bool(), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return rv.Uint(), nil
+	case reflect.Float32:
+		return float32(rv.Float()), nil
+	case reflect.Float64:
+		return rv.Float(), nil
+	case reflect.String:
+		return rv.String(), nil
+	case reflect.Slice, reflect.Array:
+		if rv.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, rv.Len())
+			reflect.Copy(reflect.ValueOf(b), rv)
+			return b, nil
+		}
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil, nil
+		}
+		items := make([]interface{}, rv.Len())
+		for i := range items {
+			item, err := nativeValue(rv.Index(i))
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return items, nil
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return jsonNative(rv.Interface())
+		}
+		if rv.IsNil() {
+			return nil, nil
+		}
+		m := make(map[string]interface{}, rv.Len())
+		iter := rv.MapRange()
+		for iter.Next() {
+			value, err := nativeValue(iter.Value())
+			if err != nil {
+				return nil, err
+			}
+			m[iter.Key().String()] = value
+		}
+		return m, nil
+	case reflect.Struct:
+		m := make(map[string]interface{})
+		return m, nativeFields(rv, m)
+	}
+	return nil, fmt.Errorf("%w: unsupported go type %s", ErrAvro, rv.Type())
+}
+
+// nativeFields put the exported fields of the struct into m by their json names,
+// embedded structs without name are flattened
+func nativeFields(rv reflect.Value, m map[string]interface{}) error {
+	t := rv.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if j := strings.Index(tag, ","); j >= 0 {
+			name, opts = tag[:j], tag[j+1:]
+		}
+		fv := rv.Field(i)
+		if f.Anonymous && name == "" {
+			for fv.Kind() == reflect.Ptr && !fv.IsNil() {
+				fv = fv.Elem()
+			}
+			if fv.Kind() == reflect.Struct {
+				if err := nativeFields(fv, m); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if f.PkgPath != "" {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && empty(fv) {
+			continue
+		}
+		value, err := nativeValue(fv)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", f.Name, err)
+		}
+		m[name] = value
+	}
+	return nil
+}
+
+// empty the values omitted by omitempty of the json encoding
+func empty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool:
+		return !v.Bool()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	case reflect.Interface, reflect.Ptr:
+		return v.IsNil()
+	}
+	return false
+}
+
+// jsonNative generic form of v through its json encoding
+func jsonNative(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	d := json.NewDecoder(strings.NewReader(string(b)))
+	d.UseNumber()
+	var n interface{}
+	err = d.Decode(&n)
+	return n, err
+}
+
+// assign the decoded native value to v, *interface{} and *map[string]interface{}
+// are set directly, other types through the json encoding
+func assign(n interface{}, v interface{}) error {
+	switch p := v.(type) {
+	case *interface{}:
+		*p = n
+		return nil
+	case *map[string]interface{}:
+		if m, ok := n.(map[string]interface{}); ok {
+			*p = m
+			return nil
+		}
+	}
+	b, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func appendVarint(buf []byte, n int64) []byte {
+	var b [binary.MaxVarintLen64]byte
+	return append(buf, b[:binary.PutVarint(b[:], n)]...)
+}
+
+func appendUint32(buf []byte, n uint32) []byte {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], n)
+	return append(buf, b[:]...)
+}
+
+func appendUint64(buf []byte, n uint64) []byte {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], n)
+	return append(buf, b[:]...)
+}
@@ -0,0 +1,266 @@
+package serde
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+const userSchema = `{
+	"type": "record",
+	"name": "User",
+	"namespace": "com.example",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "name", "type": "string"},
+		{"name": "age", "type": "int"},
+		{"name": "score", "type": "double"},
+		{"name": "ratio", "type": "float"},
+		{"name": "active", "type": "boolean"},
+		{"name": "avatar", "type": "bytes"},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+		{"name": "role", "type": {"type": "enum", "name": "Role", "symbols": ["ADMIN", "USER"]}},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attrs", "type": {"type": "map", "values": "long"}},
+		{"name": "email", "type": ["null", "string"], "default": null},
+		{"name": "manager", "type": ["null", "User"], "default": null}
+	]
+}`
+
+func TestAvroRoundTrip(t *testing.T) {
+	r := NewMemoryRegistry()
+	s, err := NewAvroSerializer(r, userSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := NewAvroDeserializer(r)
+
+	manager := map[string]interface{}{
+		"id": int64(1), "name": "root", "age": int32(50), "score": 1.5, "ratio": float32(0.25),
+		"active": true, "avatar": []byte(nil), "hash": []byte{0, 0, 0, 0}, "role": "ADMIN",
+		"tags": []interface{}{}, "attrs": map[string]interface{}{}, "email": nil, "manager": nil,
+	}
+	user := map[string]interface{}{
+		"id": int64(-42), "name": "alice", "age": int32(30), "score": 99.5, "ratio": float32(0.5),
+		"active": false, "avatar": []byte{1, 2, 3}, "hash": []byte{0xde, 0xad, 0xbe, 0xef}, "role": "USER",
+		"tags": []interface{}{"a", "b"}, "attrs": map[string]interface{}{"x": int64(1), "y": int64(-2)},
+		"email": "alice@example.com", "manager": manager,
+	}
+
+	data, err := s.Serialize("users", user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _, err := SchemaId(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registered, err := r.Latest("users-value"); err != nil || registered.Id != id {
+		t.Fatalf("schema %d not registered under users-value: %v %v", id, registered, err)
+	}
+
+	var got map[string]interface{}
+	if err = d.Deserialize("users", data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, user) {
+		t.Errorf("round trip\n got %#v\nwant %#v", got, user)
+	}
+}
+
+func TestAvroStruct(t *testing.T) {
+	type event struct {
+		Name  string   `json:"name"`
+		Count int      `json:"count"`
+		Tags  []string `json:"tags"`
+		Note  *string  `json:"note"`
+	}
+	schema := `{"type": "record", "name": "Event", "fields": [
+		{"name": "name", "type": "string"},
+		{"name": "count", "type": "long"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "note", "type": ["null", "string"], "default": null}
+	]}`
+
+	r := NewMemoryRegistry()
+	s, err := NewAvroSerializer(r, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	note := "hi"
+	in := event{Name: "click", Count: 3, Tags: []string{"a"}, Note: &note}
+	data, err := s.Serialize("events", in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out event
+	if err = NewAvroDeserializer(r).Deserialize("events", data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(in, out) {
+		t.Errorf("round trip %+v, want %+v", out, in)
+	}
+}
+
+func TestAvroStructBytes(t *testing.T) {
+	type role string
+	type base struct {
+		Id int64 `json:"id"`
+	}
+	type user struct {
+		base
+		Avatar  []byte            `json:"avatar"`
+		Hash    [4]byte           `json:"hash"`
+		Role    role              `json:"role"`
+		Attrs   map[string]uint16 `json:"attrs"`
+		Email   *string           `json:"email,omitempty"`
+		Ignored string            `json:"-"`
+	}
+	schema := `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "avatar", "type": "bytes"},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 4}},
+		{"name": "role", "type": {"type": "enum", "name": "Role", "symbols": ["ADMIN", "USER"]}},
+		{"name": "attrs", "type": {"type": "map", "values": "int"}},
+		{"name": "email", "type": ["null", "string"], "default": null}
+	]}`
+	r := NewMemoryRegistry()
+	s, err := NewAvroSerializer(r, schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		in   user
+		// want the generic form encoding the same bytes
+		want map[string]interface{}
+	}{
+		{"bytes kept", user{base: base{Id: 7}, Avatar: []byte{0xff, 0, 1}, Hash: [4]byte{1, 2, 3, 4}, Role: "USER",
+			Attrs: map[string]uint16{"a": 1}, Ignored: "x"},
+			map[string]interface{}{"id": 7, "avatar": []byte{0xff, 0, 1}, "hash": []byte{1, 2, 3, 4}, "role": "USER",
+				"attrs": map[string]interface{}{"a": 1}}},
+		{"nil bytes empty", user{Role: "ADMIN", Attrs: map[string]uint16{}},
+			map[string]interface{}{"id": 0, "avatar": []byte{}, "hash": []byte{0, 0, 0, 0}, "role": "ADMIN",
+				"attrs": map[string]interface{}{}}},
+	}
+	for _, tt := range tests {
+		got, err := s.Serialize("users", tt.in)
+		if err != nil {
+			t.Errorf("%s: Serialize: %v", tt.name, err)
+			continue
+		}
+		want, err := s.Serialize("users", tt.want)
+		if err != nil {
+			t.Fatalf("%s: Serialize generic form: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: serialized %x, want %x", tt.name, got, want)
+		}
+	}
+}
+
+func TestAvroNamespace(t *testing.T) {
+	tests := []struct {
+		name   string
+		schema string
+		// names of the field types
+		names []string
+	}{
+		{"namespace attribute", `{"type": "record", "name": "R", "namespace": "a.b", "fields": [
+			{"name": "x", "type": {"type": "fixed", "name": "F", "size": 1}},
+			{"name": "y", "type": "a.b.F"}
+		]}`, []string{"a.b.F", "a.b.F"}},
+		// the namespace of the dotted name overrides the attribute and the enclosing one
+		{"dotted name", `{"type": "record", "name": "a.b.R", "namespace": "ignored", "fields": [
+			{"name": "x", "type": {"type": "fixed", "name": "F", "size": 1}},
+			{"name": "y", "type": "a.b.F"},
+			{"name": "z", "type": "F"}
+		]}`, []string{"a.b.F", "a.b.F", "a.b.F"}},
+		{"dotted nested name", `{"type": "record", "name": "R", "namespace": "a", "fields": [
+			{"name": "x", "type": {"type": "record", "name": "c.d.N", "fields": [
+				{"name": "e", "type": {"type": "enum", "name": "E", "symbols": ["A"]}}
+			]}},
+			{"name": "y", "type": "c.d.E"}
+		]}`, []string{"c.d.N", "c.d.E"}},
+	}
+	for _, tt := range tests {
+		typ, err := parseAvro(tt.schema)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		var names []string
+		for _, f := range typ.fields {
+			names = append(names, f.typ.name)
+		}
+		if !reflect.DeepEqual(names, tt.names) {
+			t.Errorf("%s: field types %v, want %v", tt.name, names, tt.names)
+		}
+	}
+}
+
+func TestAvroMismatch(t *testing.T) {
+	s, err := NewAvroSerializer(NewMemoryRegistry(), `{"type": "record", "name": "R", "fields": [
+		{"name": "n", "type": "int"},
+		{"name": "e", "type": {"type": "enum", "name": "E", "symbols": ["A"]}}
+	]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, v := range []map[string]interface{}{
+		{"n": "1", "e": "A"},
+		{"n": int64(1) << 40, "e": "A"},
+		{"n": 1, "e": "B"},
+		{"n": 1},
+	} {
+		if _, err := s.Serialize("t", v); !errors.Is(err, ErrAvro) {
+			t.Errorf("Serialize(%v): %v", v, err)
+		}
+	}
+}
+
+func TestAvroMalformed(t *testing.T) {
+	tests := []struct {
+		schema string
+		data   []byte
+	}{
+		{`"string"`, appendVarint(nil, 10)},
+		{`"string"`, appendVarint(nil, -1)},
+		{`"long"`, []byte{0x80}},
+		// ints over 32 bits
+		{`"int"`, appendVarint(nil, 1<<31)},
+		{`"int"`, appendVarint(nil, -(1<<31)-1)},
+		{`"double"`, []byte{1, 2, 3}},
+		{`{"type": "enum", "name": "E", "symbols": ["A"]}`, appendVarint(nil, 1)},
+		{`["null", "long"]`, appendVarint(nil, 2)},
+		// counts the data can not hold
+		{`{"type": "array", "items": "long"}`, appendVarint(nil, 1<<40)},
+		{`{"type": "map", "values": "null"}`, appendVarint(nil, 1<<40)},
+		{`{"type": "array", "items": "long"}`, append(appendVarint(nil, -(1<<40)), 0)},
+		// items taking no bytes are capped
+		{`{"type": "array", "items": "null"}`, appendVarint(nil, 1<<40)},
+	}
+	for _, tt := range tests {
+		typ, err := parseAvro(tt.schema)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, err := typ.decode(&avroReader{data: tt.data}); !errors.Is(err, ErrAvro) {
+			t.Errorf("decode %s %x = %v, %v", tt.schema, tt.data, v, err)
+		}
+	}
+}
+
+func TestAvroInvalidSchema(t *testing.T) {
+	for _, schema := range []string{
+		``,
+		`"unknown"`,
+		`[["null"]]`,
+		`{"type": "record", "name": "R", "fields": [{"name": "x", "type": "Missing"}]}`,
+	} {
+		if _, err := NewAvroSerializer(NewMemoryRegistry(), schema); err == nil {
+			t.Errorf("schema %q accepted", schema)
+		}
+	}
+}
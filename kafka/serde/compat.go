@@ -0,0 +1,269 @@
+package serde
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// checkCompatibility check schema against the versions of the subject, oldest first
+func checkCompatibility(level Compatibility, schema Schema, versions []Schema) (bool, error) {
+	if len(versions) == 0 {
+		return true, nil
+	}
+	var backward, forward, transitive bool
+	switch level {
+	case CompatibilityNone:
+		return true, nil
+	case CompatibilityBackward:
+		backward = true
+	case CompatibilityBackwardTransitive:
+		backward, transitive = true, true
+	case CompatibilityForward:
+		forward = true
+	case CompatibilityForwardTransitive:
+		forward, transitive = true, true
+	case CompatibilityFull:
+		backward, forward = true, true
+	case CompatibilityFullTransitive:
+		backward, forward, transitive = true, true, true
+	default:
+		return false, fmt.Errorf("serde: unknown compatibility %q", level)
+	}
+	if !transitive {
+		versions = versions[len(versions)-1:]
+	}
+
+	for _, old := range versions {
+		if old.SchemaType() != schema.SchemaType() {
+			return false, nil
+		}
+		if backward {
+			if ok, err := canRead(schema, old); err != nil || !ok {
+				return false, err
+			}
+		}
+		if forward {
+			if ok, err := canRead(old, schema); err != nil || !ok {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// canRead whether the data written with writer can be read with reader
+// protobuf schemas are not parsed and always compatible
+func canRead(reader, writer Schema) (bool, error) {
+	switch reader.SchemaType() {
+	case Avro:
+		r, err := parseAvro(reader.Schema)
+		if err != nil {
+			return false, err
+		}
+		w, err := parseAvro(writer.Schema)
+		if err != nil {
+			return false, err
+		}
+		return avroCanRead(r, w, make(map[[2]*avroType]bool)), nil
+	case JSON:
+		var r, w interface{}
+		if err := json.Unmarshal([]byte(reader.Schema), &r); err != nil {
+			return false, fmt.Errorf("serde: json schema: %w", err)
+		}
+		if err := json.Unmarshal([]byte(writer.Schema), &w); err != nil {
+			return false, fmt.Errorf("serde: json schema: %w", err)
+		}
+		return jsonCanRead(r, w), nil
+	}
+	return true, nil
+}
+
+// avroPromotions writer types readable as the reader types
+var avroPromotions = map[string][]string{
+	"int":    {"long", "float", "double"},
+	"long":   {"float", "double"},
+	"float":  {"double"},
+	"string": {"bytes"},
+	"bytes":  {"string"},
+}
+
+// avroCanRead schema resolution rules of the avro specification, seen breaks recursive records
+func avroCanRead(r, w *avroType, seen map[[2]*avroType]bool) bool {
+	if seen[[2]*avroType{r, w}] {
+		return true
+	}
+	seen[[2]*avroType{r, w}] = true
+
+	if w.kind == "union" {
+		for _, b := range w.branches {
+			if !avroCanRead(r, b, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	if r.kind == "union" {
+		for _, b := range r.branches {
+			if avroCanRead(b, w, seen) {
+				return true
+			}
+		}
+		return false
+	}
+	if r.kind != w.kind {
+		for _, k := range avroPromotions[w.kind] {
+			if k == r.kind {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch r.kind {
+	case "record":
+		if !avroSameName(r, w) {
+			return false
+		}
+		for _, rf := range r.fields {
+			found := false
+			for _, wf := range w.fields {
+				if wf.name == rf.name {
+					if !avroCanRead(rf.typ, wf.typ, seen) {
+						return false
+					}
+					found = true
+					break
+				}
+			}
+			if !found && !rf.hasDefault {
+				return false
+			}
+		}
+		return true
+	case "enum":
+		if !avroSameName(r, w) {
+			return false
+		}
+		for _, ws := range w.symbols {
+			found := false
+			for _, rs := range r.symbols {
+				if rs == ws {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	case "fixed":
+		return r.size == w.size && avroSameName(r, w)
+	case "array":
+		return avroCanRead(r.items, w.items, seen)
+	case "map":
+		return avroCanRead(r.values, w.values, seen)
+	}
+	return true
+}
+
+// avroSameName whether the named types match: the same unqualified name,
+// or the writer name is an alias of the reader
+func avroSameName(r, w *avroType) bool {
+	if unqualified(r.name) == unqualified(w.name) {
+		return true
+	}
+	for _, a := range r.aliases {
+		if a == w.name {
+			return true
+		}
+	}
+	return false
+}
+
+func unqualified(name string) string {
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// jsonCanRead whether the documents valid for the writer schema are valid for the reader schema,
+// checking the types, required properties and closed objects
+func jsonCanRead(r, w interface{}) bool {
+	rm, ok := r.(map[string]interface{})
+	if !ok {
+		// true accepts everything
+		return r != false
+	}
+	wm, ok := w.(map[string]interface{})
+	if !ok {
+		return len(rm) == 0
+	}
+
+	rt, wt := jsonTypes(rm["type"]), jsonTypes(wm["type"])
+	if rt != nil {
+		if wt == nil {
+			return false
+		}
+		for t := range wt {
+			if !rt[t] && !(t == "integer" && rt["number"]) {
+				return false
+			}
+		}
+	}
+
+	rProps, _ := rm["properties"].(map[string]interface{})
+	wProps, _ := wm["properties"].(map[string]interface{})
+	for name, rp := range rProps {
+		if wp, ok := wProps[name]; ok && !jsonCanRead(rp, wp) {
+			return false
+		}
+	}
+	if rm["additionalProperties"] == false {
+		if wm["additionalProperties"] != false {
+			return false
+		}
+		for name := range wProps {
+			if _, ok := rProps[name]; !ok {
+				return false
+			}
+		}
+	}
+
+	required := make(map[interface{}]bool)
+	if list, ok := wm["required"].([]interface{}); ok {
+		for _, name := range list {
+			required[name] = true
+		}
+	}
+	if list, ok := rm["required"].([]interface{}); ok {
+		for _, name := range list {
+			if !required[name] {
+				return false
+			}
+		}
+	}
+
+	if ri, ok := rm["items"]; ok {
+		if wi, ok := wm["items"]; !ok || !jsonCanRead(ri, wi) {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonTypes the set of the "type" keyword, nil when any type
+func jsonTypes(v interface{}) map[string]bool {
+	switch t := v.(type) {
+	case string:
+		return map[string]bool{t: true}
+	case []interface{}:
+		set := make(map[string]bool, len(t))
+		for _, s := range t {
+			if str, ok := s.(string); ok {
+				set[str] = true
+			}
+		}
+		return set
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+package serde
+
+import (
+	"errors"
+	"testing"
+)
+
+// avro versions of a record, v2 adds a field with default and v3 one without,
+// the others rename the record
+var (
+	avroV1 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "long"}
+	]}`
+	avroV2 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "email", "type": "string", "default": ""}
+	]}`
+	avroV3 = `{"type": "record", "name": "User", "fields": [
+		{"name": "id", "type": "long"},
+		{"name": "email", "type": "string", "default": ""},
+		{"name": "age", "type": "int"}
+	]}`
+	avroRenamed = `{"type": "record", "name": "Account", "fields": [
+		{"name": "id", "type": "long"}
+	]}`
+	avroAliased = `{"type": "record", "name": "Account", "aliases": ["User"], "fields": [
+		{"name": "id", "type": "long"}
+	]}`
+	avroNamespaced = `{"type": "record", "name": "User", "namespace": "com.example", "fields": [
+		{"name": "id", "type": "long"}
+	]}`
+)
+
+func TestAvroCanRead(t *testing.T) {
+	tests := []struct {
+		reader, writer string
+		want           bool
+	}{
+		{avroV1, avroV1, true},
+		// new field with default
+		{avroV2, avroV1, true},
+		{avroV1, avroV2, true},
+		// new field without default
+		{avroV3, avroV2, false},
+		{avroV2, avroV3, true},
+		// renamed records
+		{avroRenamed, avroV1, false},
+		{avroAliased, avroV1, true},
+		{avroNamespaced, avroV1, true},
+		// promotions
+		{`"long"`, `"int"`, true},
+		{`"int"`, `"long"`, false},
+		{`"double"`, `"float"`, true},
+		{`"string"`, `"bytes"`, true},
+		// unions
+		{`["null", "long"]`, `"long"`, true},
+		{`"long"`, `["null", "long"]`, false},
+		{`["null", "string", "long"]`, `["null", "long"]`, true},
+		// enums
+		{`{"type": "enum", "name": "E", "symbols": ["A", "B"]}`, `{"type": "enum", "name": "E", "symbols": ["A"]}`, true},
+		{`{"type": "enum", "name": "E", "symbols": ["A"]}`, `{"type": "enum", "name": "E", "symbols": ["A", "B"]}`, false},
+		{`{"type": "enum", "name": "F", "symbols": ["A"]}`, `{"type": "enum", "name": "E", "symbols": ["A"]}`, false},
+		// fixed
+		{`{"type": "fixed", "name": "H", "size": 4}`, `{"type": "fixed", "name": "H", "size": 4}`, true},
+		{`{"type": "fixed", "name": "H", "size": 8}`, `{"type": "fixed", "name": "H", "size": 4}`, false},
+		{`{"type": "fixed", "name": "G", "size": 4}`, `{"type": "fixed", "name": "H", "size": 4}`, false},
+		// containers
+		{`{"type": "array", "items": "long"}`, `{"type": "array", "items": "int"}`, true},
+		{`{"type": "map", "values": "int"}`, `{"type": "map", "values": "long"}`, false},
+	}
+	for _, tt := range tests {
+		got, err := canRead(Schema{Schema: tt.reader}, Schema{Schema: tt.writer})
+		if err != nil {
+			t.Fatalf("canRead(%s, %s): %v", tt.reader, tt.writer, err)
+		}
+		if got != tt.want {
+			t.Errorf("canRead(%s, %s) = %v, want %v", tt.reader, tt.writer, got, tt.want)
+		}
+	}
+}
+
+func TestJSONCanRead(t *testing.T) {
+	tests := []struct {
+		reader, writer string
+		want           bool
+	}{
+		{`{"type": "object"}`, `{"type": "object"}`, true},
+		{`{"type": "number"}`, `{"type": "integer"}`, true},
+		{`{"type": "integer"}`, `{"type": "number"}`, false},
+		{`{"type": ["string", "null"]}`, `{"type": "string"}`, true},
+		{`{"type": "string"}`, `{"type": ["string", "null"]}`, false},
+		{`{"type": "object", "required": ["id"]}`, `{"type": "object", "required": ["id", "name"]}`, true},
+		{`{"type": "object", "required": ["id", "name"]}`, `{"type": "object", "required": ["id"]}`, false},
+		{`{"type": "object", "additionalProperties": false, "properties": {"id": {}}}`,
+			`{"type": "object", "properties": {"id": {}}}`, false},
+		{`{"type": "object", "additionalProperties": false, "properties": {"id": {}}}`,
+			`{"type": "object", "additionalProperties": false, "properties": {"id": {}}}`, true},
+		{`{"type": "object", "properties": {"id": {"type": "string"}}}`,
+			`{"type": "object", "properties": {"id": {"type": "integer"}}}`, false},
+		{`{"type": "array", "items": {"type": "number"}}`, `{"type": "array", "items": {"type": "integer"}}`, true},
+		{`true`, `{"type": "string"}`, true},
+		{`false`, `{"type": "string"}`, false},
+	}
+	for _, tt := range tests {
+		got, err := canRead(Schema{Type: JSON, Schema: tt.reader}, Schema{Type: JSON, Schema: tt.writer})
+		if err != nil {
+			t.Fatalf("canRead(%s, %s): %v", tt.reader, tt.writer, err)
+		}
+		if got != tt.want {
+			t.Errorf("canRead(%s, %s) = %v, want %v", tt.reader, tt.writer, got, tt.want)
+		}
+	}
+}
+
+func TestCompatibilityLevels(t *testing.T) {
+	// v1 then v2 registered, v3 checked against them
+	tests := []struct {
+		level  Compatibility
+		schema string
+		want   bool
+	}{
+		{CompatibilityNone, avroRenamed, true},
+		// age of v3 has no default: v2 reads the data of v3, v3 can not read the data of v2
+		{CompatibilityBackward, avroV3, false},
+		{CompatibilityForward, avroV3, true},
+		{CompatibilityFull, avroV3, false},
+		// v1 reads and is read by v2
+		{CompatibilityBackward, avroV1, true},
+		{CompatibilityFull, avroV1, true},
+		{CompatibilityFullTransitive, avroV1, true},
+		{CompatibilityBackward, avroRenamed, false},
+		{CompatibilityForward, avroAliased, false},
+		{CompatibilityBackward, avroAliased, true},
+	}
+	for _, tt := range tests {
+		r := NewMemoryRegistry()
+		r.SetCompatibility("s", CompatibilityNone)
+		for _, v := range []string{avroV1, avroV2} {
+			if _, err := r.Register("s", Schema{Schema: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		r.SetCompatibility("s", tt.level)
+
+		got, err := r.Compatible("s", Schema{Schema: tt.schema})
+		if err != nil {
+			t.Fatalf("%s: %v", tt.level, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: Compatible(%s) = %v, want %v", tt.level, tt.schema, got, tt.want)
+		}
+		_, err = r.Register("s", Schema{Schema: tt.schema})
+		if tt.want != (err == nil) || (err != nil && !errors.Is(err, ErrIncompatible)) {
+			t.Errorf("%s: Register(%s) = %v", tt.level, tt.schema, err)
+		}
+	}
+}
+
+func TestCompatibilityTransitive(t *testing.T) {
+	// v2 drops the field of v1 and v3 adds it back with another type,
+	// v3 only breaks against v1
+	v1 := `{"type": "record", "name": "R", "fields": [
+		{"name": "a", "type": "long"}, {"name": "b", "type": "string", "default": ""}
+	]}`
+	v2 := `{"type": "record", "name": "R", "fields": [
+		{"name": "a", "type": "long"}
+	]}`
+	v3 := `{"type": "record", "name": "R", "fields": [
+		{"name": "a", "type": "long"}, {"name": "b", "type": "long", "default": 0}
+	]}`
+	for _, tt := range []struct {
+		level Compatibility
+		want  bool
+	}{
+		{CompatibilityBackward, true},
+		{CompatibilityBackwardTransitive, false},
+		{CompatibilityForward, true},
+		{CompatibilityForwardTransitive, false},
+	} {
+		r := NewMemoryRegistry()
+		r.SetCompatibility("s", tt.level)
+		for _, v := range []string{v1, v2} {
+			if _, err := r.Register("s", Schema{Schema: v}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if got, err := r.Compatible("s", Schema{Schema: v3}); err != nil || got != tt.want {
+			t.Errorf("%s: Compatible = %v %v, want %v", tt.level, got, err, tt.want)
+		}
+	}
+}
+
+func TestCompatibilityTypes(t *testing.T) {
+	r := NewMemoryRegistry()
+	if _, err := r.Register("s", Schema{Schema: avroV1}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.Compatible("s", Schema{Type: JSON, Schema: `{"type": "object"}`}); err != nil || ok {
+		t.Errorf("json against avro: %v %v", ok, err)
+	}
+	if _, err := checkCompatibility("UNKNOWN", Schema{Schema: avroV1}, []Schema{{Schema: avroV1}}); err == nil {
+		t.Error("unknown level accepted")
+	}
+}
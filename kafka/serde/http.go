@@ -0,0 +1,293 @@
+package serde
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// error codes of the schema registry REST API
+const (
+	codeSubjectNotFound = 40401
+	codeVersionNotFound = 40402
+	codeSchemaNotFound  = 40403
+	codeIncompatible    = 409
+	codeInvalidSchema   = 42201
+)
+
+// HTTPRegistry client of the schema registry REST API
+type HTTPRegistry struct {
+	url      string
+	client   *http.Client
+	username string
+	password string
+}
+
+type httpOption struct {
+	client             *http.Client
+	username, password string
+}
+type HTTPOptions func(*httpOption)
+
+// WithHTTPClient client of the requests, 10s timeout by default
+func WithHTTPClient(c *http.Client) HTTPOptions {
+	return func(o *httpOption) {
+		o.client = c
+	}
+}
+
+// WithBasicAuth credentials of the registry
+func WithBasicAuth(username, password string) HTTPOptions {
+	return func(o *httpOption) {
+		o.username, o.password = username, password
+	}
+}
+
+// NewHTTPRegistry client of the registry at url, such as http://localhost:8081
+func NewHTTPRegistry(url string, opts ...HTTPOptions) *HTTPRegistry {
+	opt := &httpOption{client: &http.Client{Timeout: 10 * time.Second}}
+	for _, o := range opts {
+		o(opt)
+	}
+	return &HTTPRegistry{
+		url:      strings.TrimRight(url, "/"),
+		client:   opt.client,
+		username: opt.username,
+		password: opt.password,
+	}
+}
+
+// apiError error body of the REST API
+type apiError struct {
+	Code    int    `json:"error_code"`
+	Message string `json:"message"`
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("serde: registry: %d %s", e.Code, e.Message)
+}
+
+// Unwrap the serde errors of the codes
+func (e *apiError) Unwrap() error {
+	switch e.Code {
+	case codeSubjectNotFound, codeVersionNotFound, codeSchemaNotFound:
+		return ErrSchemaNotFound
+	case codeIncompatible:
+		return ErrIncompatible
+	}
+	return nil
+}
+
+// do send the request with the json body and decode the json response into out
+func (r *HTTPRegistry) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		b, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, r.url+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if in != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if r.username != "" {
+		req.SetBasicAuth(r.username, r.password)
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		e := &apiError{Code: resp.StatusCode}
+		if err := json.NewDecoder(resp.Body).Decode(e); err != nil || e.Code == 0 {
+			e.Code, e.Message = resp.StatusCode, resp.Status
+		}
+		return e
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// schemaRequest request body of the schema, schemaType omitted for avro
+func schemaRequest(schema Schema) interface{} {
+	req := struct {
+		Schema string     `json:"schema"`
+		Type   SchemaType `json:"schemaType,omitempty"`
+	}{Schema: schema.Schema}
+	if schema.SchemaType() != Avro {
+		req.Type = schema.Type
+	}
+	return req
+}
+
+func subjectPath(subject string) string {
+	return "/subjects/" + url.PathEscape(subject)
+}
+
+func (r *HTTPRegistry) Register(subject string, schema Schema) (int, error) {
+	var resp struct {
+		Id int `json:"id"`
+	}
+	err := r.do(http.MethodPost, subjectPath(subject)+"/versions", schemaRequest(schema), &resp)
+	return resp.Id, err
+}
+
+func (r *HTTPRegistry) Lookup(subject string, schema Schema) (Schema, error) {
+	var s Schema
+	err := r.do(http.MethodPost, subjectPath(subject), schemaRequest(schema), &s)
+	return s, err
+}
+
+func (r *HTTPRegistry) SchemaById(id int) (Schema, error) {
+	var s Schema
+	err := r.do(http.MethodGet, "/schemas/ids/"+strconv.Itoa(id), nil, &s)
+	s.Id = id
+	return s, err
+}
+
+func (r *HTTPRegistry) Latest(subject string) (Schema, error) {
+	var s Schema
+	err := r.do(http.MethodGet, subjectPath(subject)+"/versions/latest", nil, &s)
+	return s, err
+}
+
+func (r *HTTPRegistry) Compatible(subject string, schema Schema) (bool, error) {
+	var resp struct {
+		Compatible bool `json:"is_compatible"`
+	}
+	err := r.do(http.MethodPost, "/compatibility"+subjectPath(subject)+"/versions/latest", schemaRequest(schema), &resp)
+	if errors.Is(err, ErrSchemaNotFound) {
+		// nothing to be compatible with
+		return true, nil
+	}
+	return resp.Compatible, err
+}
+
+// SetCompatibility compatibility level of subject, "" sets the global level
+func (r *HTTPRegistry) SetCompatibility(subject string, level Compatibility) error {
+	path := "/config"
+	if subject != "" {
+		path += "/" + url.PathEscape(subject)
+	}
+	var resp struct{}
+	return r.do(http.MethodPut, path, map[string]Compatibility{"compatibility": level}, &resp)
+}
+
+// NewHandler serve the registry with the subset of the REST API used by HTTPRegistry,
+// a local stand-in of the schema registry
+func NewHandler(r *MemoryRegistry) http.Handler {
+	return &handler{r: r}
+}
+
+type handler struct {
+	r *MemoryRegistry
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	parts := strings.Split(strings.Trim(req.URL.EscapedPath(), "/"), "/")
+	for i, p := range parts {
+		parts[i], _ = url.PathUnescape(p)
+	}
+
+	switch {
+	case req.Method == http.MethodGet && len(parts) == 1 && parts[0] == "subjects":
+		h.reply(w, h.r.Subjects(), nil)
+	case req.Method == http.MethodGet && len(parts) == 3 && parts[0] == "schemas" && parts[1] == "ids":
+		id, err := strconv.Atoi(parts[2])
+		if err != nil {
+			h.fail(w, http.StatusNotFound, codeSchemaNotFound, "schema not found")
+			return
+		}
+		s, err := h.r.SchemaById(id)
+		h.reply(w, schemaRequest(s), err)
+	case req.Method == http.MethodPost && len(parts) == 3 && parts[0] == "subjects" && parts[2] == "versions":
+		s, ok := h.schema(w, req)
+		if !ok {
+			return
+		}
+		id, err := h.r.Register(parts[1], s)
+		h.reply(w, map[string]int{"id": id}, err)
+	case req.Method == http.MethodPost && len(parts) == 2 && parts[0] == "subjects":
+		s, ok := h.schema(w, req)
+		if !ok {
+			return
+		}
+		s, err := h.r.Lookup(parts[1], s)
+		h.reply(w, s, err)
+	case req.Method == http.MethodGet && len(parts) == 4 && parts[0] == "subjects" && parts[2] == "versions" && parts[3] == "latest":
+		s, err := h.r.Latest(parts[1])
+		h.reply(w, s, err)
+	case req.Method == http.MethodPost && len(parts) == 5 && parts[0] == "compatibility" && parts[1] == "subjects":
+		s, ok := h.schema(w, req)
+		if !ok {
+			return
+		}
+		if _, err := h.r.Latest(parts[2]); err != nil {
+			h.reply(w, nil, err)
+			return
+		}
+		compatible, err := h.r.Compatible(parts[2], s)
+		h.reply(w, map[string]bool{"is_compatible": compatible}, err)
+	case req.Method == http.MethodPut && len(parts) <= 2 && parts[0] == "config":
+		var body struct {
+			Compatibility Compatibility `json:"compatibility"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			h.fail(w, http.StatusUnprocessableEntity, codeInvalidSchema, err.Error())
+			return
+		}
+		subject := ""
+		if len(parts) == 2 {
+			subject = parts[1]
+		}
+		h.r.SetCompatibility(subject, body.Compatibility)
+		h.reply(w, body, nil)
+	default:
+		h.fail(w, http.StatusNotFound, http.StatusNotFound, "not found")
+	}
+}
+
+// schema decode the schema of the request body
+func (h *handler) schema(w http.ResponseWriter, req *http.Request) (Schema, bool) {
+	var s Schema
+	if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+		h.fail(w, http.StatusUnprocessableEntity, codeInvalidSchema, err.Error())
+		return s, false
+	}
+	return s, true
+}
+
+// reply write v or the error of the registry
+func (h *handler) reply(w http.ResponseWriter, v interface{}, err error) {
+	switch {
+	case err == nil:
+		_ = json.NewEncoder(w).Encode(v)
+	case errors.Is(err, ErrSchemaNotFound):
+		h.fail(w, http.StatusNotFound, codeSchemaNotFound, err.Error())
+	case errors.Is(err, ErrIncompatible):
+		h.fail(w, http.StatusConflict, codeIncompatible, err.Error())
+	default:
+		h.fail(w, http.StatusUnprocessableEntity, codeInvalidSchema, err.Error())
+	}
+}
+
+func (h *handler) fail(w http.ResponseWriter, status, code int, message string) {
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(apiError{Code: code, Message: message})
+}
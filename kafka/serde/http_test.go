@@ -0,0 +1,151 @@
+package serde
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func newTestRegistry(t *testing.T) (*HTTPRegistry, *MemoryRegistry) {
+	m := NewMemoryRegistry()
+	server := httptest.NewServer(NewHandler(m))
+	t.Cleanup(server.Close)
+	return NewHTTPRegistry(server.URL + "/"), m
+}
+
+func TestHTTPRegistry(t *testing.T) {
+	r, m := newTestRegistry(t)
+
+	id, err := r.Register("users-value", Schema{Schema: avroV1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, err := r.Register("users-value", Schema{Schema: avroV1}); err != nil || again != id {
+		t.Errorf("registered again = %d %v, want %d", again, err, id)
+	}
+	jsonId, err := r.Register("events-value", Schema{Type: JSON, Schema: `{"type": "object"}`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(m.Subjects(), []string{"events-value", "users-value"}) {
+		t.Errorf("subjects %v", m.Subjects())
+	}
+
+	s, err := r.SchemaById(id)
+	if err != nil || s.Id != id || s.SchemaType() != Avro || s.Schema != avroV1 {
+		t.Errorf("SchemaById(%d) = %+v %v", id, s, err)
+	}
+	s, err = r.SchemaById(jsonId)
+	if err != nil || s.Type != JSON {
+		t.Errorf("SchemaById(%d) = %+v %v", jsonId, s, err)
+	}
+	if _, err = r.SchemaById(1000); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("SchemaById(1000): %v", err)
+	}
+
+	s, err = r.Lookup("users-value", Schema{Schema: avroV1})
+	if err != nil || s.Id != id || s.Subject != "users-value" || s.Version != 1 {
+		t.Errorf("Lookup = %+v %v", s, err)
+	}
+	if _, err = r.Lookup("users-value", Schema{Schema: avroV2}); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("Lookup of unregistered schema: %v", err)
+	}
+
+	if _, err = r.Register("users-value", Schema{Schema: avroV2}); err != nil {
+		t.Fatal(err)
+	}
+	s, err = r.Latest("users-value")
+	if err != nil || s.Version != 2 || s.Schema != avroV2 {
+		t.Errorf("Latest = %+v %v", s, err)
+	}
+	if _, err = r.Latest("missing"); !errors.Is(err, ErrSchemaNotFound) {
+		t.Errorf("Latest(missing): %v", err)
+	}
+}
+
+func TestHTTPRegistryCompatibility(t *testing.T) {
+	r, m := newTestRegistry(t)
+
+	if ok, err := r.Compatible("users-value", Schema{Schema: avroV1}); err != nil || !ok {
+		t.Errorf("Compatible with no version = %v %v", ok, err)
+	}
+	if _, err := r.Register("users-value", Schema{Schema: avroV2}); err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := r.Compatible("users-value", Schema{Schema: avroV1}); err != nil || !ok {
+		t.Errorf("Compatible(v1) = %v %v", ok, err)
+	}
+	if ok, err := r.Compatible("users-value", Schema{Schema: avroV3}); err != nil || ok {
+		t.Errorf("Compatible(v3) = %v %v", ok, err)
+	}
+	if _, err := r.Register("users-value", Schema{Schema: avroV3}); !errors.Is(err, ErrIncompatible) {
+		t.Errorf("Register(v3): %v", err)
+	}
+
+	if err := r.SetCompatibility("users-value", CompatibilityForward); err != nil {
+		t.Fatal(err)
+	}
+	if level := m.Compatibility("users-value"); level != CompatibilityForward {
+		t.Errorf("subject level %s", level)
+	}
+	if _, err := r.Register("users-value", Schema{Schema: avroV3}); err != nil {
+		t.Errorf("Register(v3) forward: %v", err)
+	}
+	if err := r.SetCompatibility("", CompatibilityNone); err != nil {
+		t.Fatal(err)
+	}
+	if level := m.Compatibility("other"); level != CompatibilityNone {
+		t.Errorf("global level %s", level)
+	}
+
+	if _, err := r.Register("bad", Schema{Schema: `"unknown"`}); err == nil || errors.Is(err, ErrIncompatible) {
+		t.Errorf("Register(invalid): %v", err)
+	}
+}
+
+func TestHTTPRegistrySerde(t *testing.T) {
+	r, _ := newTestRegistry(t)
+	s, err := NewAvroSerializer(r, avroV2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := s.Serialize("users", map[string]interface{}{"id": 7, "email": "a@b.c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err = NewAvroDeserializer(r).Deserialize("users", data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["id"] != int64(7) || got["email"] != "a@b.c" {
+		t.Errorf("round trip %v", got)
+	}
+
+	// the schema type of the writer is checked
+	if err = NewJSONDeserializer(r).Deserialize("users", data, &got); !errors.Is(err, ErrSchemaType) {
+		t.Errorf("json deserializer of avro data: %v", err)
+	}
+}
+
+func TestHTTPRegistryAuth(t *testing.T) {
+	m := NewMemoryRegistry()
+	handler := NewHandler(m)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if user, pass, ok := req.BasicAuth(); !ok || user != "user" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, req)
+	}))
+	defer server.Close()
+
+	if _, err := NewHTTPRegistry(server.URL).Register("s", Schema{Schema: avroV1}); err == nil {
+		t.Error("registered without credentials")
+	}
+	r := NewHTTPRegistry(server.URL, WithBasicAuth("user", "secret"), WithHTTPClient(server.Client()))
+	if _, err := r.Register("s", Schema{Schema: avroV1}); err != nil {
+		t.Error(err)
+	}
+}
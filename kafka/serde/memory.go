@@ -0,0 +1,174 @@
+package serde
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// MemoryRegistry schema registry kept in memory, used by tests and local development
+// and served over HTTP by NewHandler
+type MemoryRegistry struct {
+	m        sync.Mutex
+	nextId   int
+	ids      map[cacheKey]int
+	schemas  map[int]Schema
+	subjects map[string][]Schema
+	level    Compatibility
+	levels   map[string]Compatibility
+}
+
+// NewMemoryRegistry empty registry, subjects are CompatibilityBackward by default
+func NewMemoryRegistry() *MemoryRegistry {
+	return &MemoryRegistry{
+		nextId:   1,
+		ids:      make(map[cacheKey]int),
+		schemas:  make(map[int]Schema),
+		subjects: make(map[string][]Schema),
+		level:    CompatibilityBackward,
+		levels:   make(map[string]Compatibility),
+	}
+}
+
+// SetCompatibility compatibility level of subject, "" sets the default level
+func (r *MemoryRegistry) SetCompatibility(subject string, level Compatibility) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if subject == "" {
+		r.level = level
+		return
+	}
+	r.levels[subject] = level
+}
+
+// Compatibility compatibility level of subject
+func (r *MemoryRegistry) Compatibility(subject string) Compatibility {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.compatibility(subject)
+}
+
+func (r *MemoryRegistry) compatibility(subject string) Compatibility {
+	if level, ok := r.levels[subject]; ok {
+		return level
+	}
+	return r.level
+}
+
+func (r *MemoryRegistry) Register(subject string, schema Schema) (int, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if s, ok := r.lookup(subject, schema); ok {
+		return s.Id, nil
+	}
+	if err := validate(schema); err != nil {
+		return 0, err
+	}
+	versions := r.subjects[subject]
+	ok, err := checkCompatibility(r.compatibility(subject), schema, versions)
+	if err != nil {
+		return 0, err
+	}
+	if !ok {
+		return 0, fmt.Errorf("%w: subject %s, level %s", ErrIncompatible, subject, r.compatibility(subject))
+	}
+
+	// the same schema has the same id in all subjects
+	key := cacheKey{typ: schema.SchemaType(), schema: schema.Schema}
+	id, ok := r.ids[key]
+	if !ok {
+		id = r.nextId
+		r.nextId++
+		r.ids[key] = id
+		r.schemas[id] = Schema{Id: id, Type: schema.Type, Schema: schema.Schema}
+	}
+	r.subjects[subject] = append(versions, Schema{
+		Id:      id,
+		Subject: subject,
+		Version: len(versions) + 1,
+		Type:    schema.Type,
+		Schema:  schema.Schema,
+	})
+	return id, nil
+}
+
+func (r *MemoryRegistry) Lookup(subject string, schema Schema) (Schema, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if s, ok := r.lookup(subject, schema); ok {
+		return s, nil
+	}
+	return Schema{}, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+}
+
+func (r *MemoryRegistry) lookup(subject string, schema Schema) (Schema, bool) {
+	for _, s := range r.subjects[subject] {
+		if s.SchemaType() == schema.SchemaType() && s.Schema == schema.Schema {
+			return s, true
+		}
+	}
+	return Schema{}, false
+}
+
+func (r *MemoryRegistry) SchemaById(id int) (Schema, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	s, ok := r.schemas[id]
+	if !ok {
+		return Schema{}, fmt.Errorf("%w: id %d", ErrSchemaNotFound, id)
+	}
+	return s, nil
+}
+
+func (r *MemoryRegistry) Latest(subject string) (Schema, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	versions := r.subjects[subject]
+	if len(versions) == 0 {
+		return Schema{}, fmt.Errorf("%w: subject %s", ErrSchemaNotFound, subject)
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (r *MemoryRegistry) Compatible(subject string, schema Schema) (bool, error) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if err := validate(schema); err != nil {
+		return false, err
+	}
+	return checkCompatibility(r.compatibility(subject), schema, r.subjects[subject])
+}
+
+// Subjects the registered subjects in order
+func (r *MemoryRegistry) Subjects() []string {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	subjects := make([]string, 0, len(r.subjects))
+	for s := range r.subjects {
+		subjects = append(subjects, s)
+	}
+	sort.Strings(subjects)
+	return subjects
+}
+
+// validate parse the avro and json schemas
+func validate(schema Schema) error {
+	switch schema.SchemaType() {
+	case Avro:
+		_, err := parseAvro(schema.Schema)
+		return err
+	case JSON:
+		_, err := canRead(schema, schema)
+		return err
+	case Protobuf:
+		return nil
+	}
+	return fmt.Errorf("%w: %s", ErrSchemaType, schema.Type)
+}
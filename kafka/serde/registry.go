@@ -0,0 +1,152 @@
+package serde
+
+import (
+	"errors"
+	"sync"
+)
+
+// SchemaType type of the schema, "" is AVRO as in the schema registry
+type SchemaType string
+
+const (
+	Avro     SchemaType = "AVRO"
+	JSON     SchemaType = "JSON"
+	Protobuf SchemaType = "PROTOBUF"
+)
+
+// Compatibility level of the subject, checked when registering a new version
+type Compatibility string
+
+const (
+	CompatibilityNone               Compatibility = "NONE"
+	CompatibilityBackward           Compatibility = "BACKWARD"
+	CompatibilityBackwardTransitive Compatibility = "BACKWARD_TRANSITIVE"
+	CompatibilityForward            Compatibility = "FORWARD"
+	CompatibilityForwardTransitive  Compatibility = "FORWARD_TRANSITIVE"
+	CompatibilityFull               Compatibility = "FULL"
+	CompatibilityFullTransitive     Compatibility = "FULL_TRANSITIVE"
+)
+
+var (
+	ErrSchemaNotFound = errors.New("serde: schema not found")
+	ErrIncompatible   = errors.New("serde: schema incompatible")
+	ErrSchemaType     = errors.New("serde: unexpected schema type")
+)
+
+// Schema registered schema, Id and Version are set by the registry
+type Schema struct {
+	Id      int        `json:"id,omitempty"`
+	Subject string     `json:"subject,omitempty"`
+	Version int        `json:"version,omitempty"`
+	Type    SchemaType `json:"schemaType,omitempty"`
+	Schema  string     `json:"schema"`
+}
+
+// SchemaType type of the schema, Avro when not set
+func (s Schema) SchemaType() SchemaType {
+	if s.Type == "" {
+		return Avro
+	}
+	return s.Type
+}
+
+// Registry client of a schema registry
+type Registry interface {
+	// Register the schema under subject, ErrIncompatible when rejected by the compatibility level
+	// registering an existing schema returns its id
+	Register(subject string, schema Schema) (int, error)
+	// Lookup the registered version of the schema under subject
+	Lookup(subject string, schema Schema) (Schema, error)
+	// SchemaById the schema with the id
+	SchemaById(id int) (Schema, error)
+	// Latest the latest version of subject
+	Latest(subject string) (Schema, error)
+	// Compatible whether the schema is compatible with the versions of subject
+	Compatible(subject string, schema Schema) (bool, error)
+}
+
+// CachedRegistry cache the ids and schemas of the registry, registered schemas never change
+type CachedRegistry struct {
+	Registry
+
+	m        sync.RWMutex
+	ids      map[cacheKey]int
+	versions map[cacheKey]Schema
+	schemas  map[int]Schema
+}
+
+type cacheKey struct {
+	subject string
+	typ     SchemaType
+	schema  string
+}
+
+// NewCachedRegistry cache r
+func NewCachedRegistry(r Registry) *CachedRegistry {
+	if c, ok := r.(*CachedRegistry); ok {
+		return c
+	}
+	return &CachedRegistry{
+		Registry: r,
+		ids:      make(map[cacheKey]int),
+		versions: make(map[cacheKey]Schema),
+		schemas:  make(map[int]Schema),
+	}
+}
+
+func (c *CachedRegistry) Register(subject string, schema Schema) (int, error) {
+	key := cacheKey{subject, schema.SchemaType(), schema.Schema}
+	c.m.RLock()
+	id, ok := c.ids[key]
+	c.m.RUnlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := c.Registry.Register(subject, schema)
+	if err != nil {
+		return 0, err
+	}
+	c.m.Lock()
+	c.ids[key] = id
+	c.m.Unlock()
+	return id, nil
+}
+
+func (c *CachedRegistry) Lookup(subject string, schema Schema) (Schema, error) {
+	key := cacheKey{subject, schema.SchemaType(), schema.Schema}
+	c.m.RLock()
+	s, ok := c.versions[key]
+	c.m.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := c.Registry.Lookup(subject, schema)
+	if err != nil {
+		return Schema{}, err
+	}
+	c.m.Lock()
+	c.versions[key] = s
+	c.ids[key] = s.Id
+	c.m.Unlock()
+	return s, nil
+}
+
+func (c *CachedRegistry) SchemaById(id int) (Schema, error) {
+	c.m.RLock()
+	s, ok := c.schemas[id]
+	c.m.RUnlock()
+	if ok {
+		return s, nil
+	}
+
+	s, err := c.Registry.SchemaById(id)
+	if err != nil {
+		return Schema{}, err
+	}
+	c.m.Lock()
+	c.schemas[id] = s
+	c.m.Unlock()
+	return s, nil
+}
@@ -0,0 +1,352 @@
+package serde
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+)
+
+// Serializer encode the values of topic in the Confluent wire format:
+// magic byte, 4 bytes schema id and the payload
+type Serializer interface {
+	Serialize(topic string, v interface{}) ([]byte, error)
+}
+
+// Deserializer decode the data of topic in the Confluent wire format into v
+type Deserializer interface {
+	Deserialize(topic string, data []byte, v interface{}) error
+}
+
+// SubjectNameStrategy subject of the schema used for topic
+type SubjectNameStrategy func(topic string, isKey bool, schema Schema) string
+
+// TopicNameStrategy <topic>-key or <topic>-value, the default of the schema registry
+func TopicNameStrategy(topic string, isKey bool, _ Schema) string {
+	if isKey {
+		return topic + "-key"
+	}
+	return topic + "-value"
+}
+
+type serializerOption struct {
+	subject      SubjectNameStrategy
+	isKey        bool
+	autoRegister bool
+	indexes      []int
+	marshal      func(v interface{}) ([]byte, error)
+}
+type SerializerOptions func(*serializerOption)
+
+// WithSubjectNameStrategy subject of the schema, TopicNameStrategy by default
+func WithSubjectNameStrategy(strategy SubjectNameStrategy) SerializerOptions {
+	return func(o *serializerOption) {
+		o.subject = strategy
+	}
+}
+
+// ForKey serialize the message keys, subjects are <topic>-key
+func ForKey() SerializerOptions {
+	return func(o *serializerOption) {
+		o.isKey = true
+	}
+}
+
+// WithAutoRegister register the schema at the first use, true by default,
+// otherwise the schema should already be registered
+func WithAutoRegister(register bool) SerializerOptions {
+	return func(o *serializerOption) {
+		o.autoRegister = register
+	}
+}
+
+// WithMessageIndexes indexes of the message type in the protobuf schema, [0] the first message by default
+func WithMessageIndexes(indexes ...int) SerializerOptions {
+	return func(o *serializerOption) {
+		o.indexes = indexes
+	}
+}
+
+// WithProtoMarshal marshal of the protobuf messages, such as proto.Marshal,
+// by default the messages should have the Marshal() ([]byte, error) method
+func WithProtoMarshal(marshal func(v interface{}) ([]byte, error)) SerializerOptions {
+	return func(o *serializerOption) {
+		o.marshal = marshal
+	}
+}
+
+// serializer schema id resolution shared by the serializers
+type serializer struct {
+	r      Registry
+	schema Schema
+	opt    serializerOption
+	// ids schema id of each subject
+	ids sync.Map
+}
+
+func newSerializer(r Registry, schema Schema, opts []SerializerOptions) (*serializer, error) {
+	if err := validate(schema); err != nil {
+		return nil, err
+	}
+	s := &serializer{
+		r:      NewCachedRegistry(r),
+		schema: schema,
+		opt: serializerOption{
+			subject:      TopicNameStrategy,
+			autoRegister: true,
+		},
+	}
+	for _, o := range opts {
+		o(&s.opt)
+	}
+	return s, nil
+}
+
+// id the schema id for topic, registered or looked up at the first use
+func (s *serializer) id(topic string) (int, error) {
+	subject := s.opt.subject(topic, s.opt.isKey, s.schema)
+	if v, ok := s.ids.Load(subject); ok {
+		return v.(int), nil
+	}
+
+	var id int
+	if s.opt.autoRegister {
+		var err error
+		if id, err = s.r.Register(subject, s.schema); err != nil {
+			return 0, err
+		}
+	} else {
+		registered, err := s.r.Lookup(subject, s.schema)
+		if err != nil {
+			return 0, err
+		}
+		id = registered.Id
+	}
+	s.ids.Store(subject, id)
+	return id, nil
+}
+
+// JSONSerializer encode the values with encoding/json, the schema is a JSON schema
+type JSONSerializer struct {
+	*serializer
+}
+
+// NewJSONSerializer serializer of the values described by the JSON schema
+func NewJSONSerializer(r Registry, schema string, opts ...SerializerOptions) (*JSONSerializer, error) {
+	s, err := newSerializer(r, Schema{Type: JSON, Schema: schema}, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSerializer{s}, nil
+}
+
+func (s *JSONSerializer) Serialize(topic string, v interface{}) ([]byte, error) {
+	id, err := s.id(topic)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return append(appendHeader(make([]byte, 0, 5+len(payload)), id), payload...), nil
+}
+
+// AvroSerializer encode the values in the avro binary encoding, records are map[string]interface{}
+// or structs converted through their json encoding, arrays []interface{}
+type AvroSerializer struct {
+	*serializer
+	t *avroType
+}
+
+// NewAvroSerializer serializer of the values of the avro schema
+func NewAvroSerializer(r Registry, schema string, opts ...SerializerOptions) (*AvroSerializer, error) {
+	s, err := newSerializer(r, Schema{Type: Avro, Schema: schema}, opts)
+	if err != nil {
+		return nil, err
+	}
+	t, err := parseAvro(schema)
+	if err != nil {
+		return nil, err
+	}
+	return &AvroSerializer{serializer: s, t: t}, nil
+}
+
+func (s *AvroSerializer) Serialize(topic string, v interface{}) ([]byte, error) {
+	n, err := native(v)
+	if err != nil {
+		return nil, err
+	}
+	id, err := s.id(topic)
+	if err != nil {
+		return nil, err
+	}
+	return s.t.encode(appendHeader(nil, id), n)
+}
+
+// ProtobufSerializer encode the protobuf messages, the message indexes follow the schema id
+type ProtobufSerializer struct {
+	*serializer
+}
+
+// NewProtobufSerializer serializer of the messages of the .proto schema
+func NewProtobufSerializer(r Registry, schema string, opts ...SerializerOptions) (*ProtobufSerializer, error) {
+	s, err := newSerializer(r, Schema{Type: Protobuf, Schema: schema}, opts)
+	if err != nil {
+		return nil, err
+	}
+	if s.opt.marshal == nil {
+		s.opt.marshal = marshalProto
+	}
+	return &ProtobufSerializer{s}, nil
+}
+
+func (s *ProtobufSerializer) Serialize(topic string, v interface{}) ([]byte, error) {
+	id, err := s.id(topic)
+	if err != nil {
+		return nil, err
+	}
+	payload, err := s.opt.marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	buf := appendIndexes(appendHeader(nil, id), s.opt.indexes)
+	return append(buf, payload...), nil
+}
+
+func marshalProto(v interface{}) ([]byte, error) {
+	m, ok := v.(interface{ Marshal() ([]byte, error) })
+	if !ok {
+		return nil, fmt.Errorf("serde: %T has no Marshal method, use WithProtoMarshal", v)
+	}
+	return m.Marshal()
+}
+
+type deserializerOption struct {
+	unmarshal func(data []byte, v interface{}) error
+}
+type DeserializerOptions func(*deserializerOption)
+
+// WithProtoUnmarshal unmarshal of the protobuf messages, such as proto.Unmarshal,
+// by default the messages should have the Unmarshal([]byte) error method
+func WithProtoUnmarshal(unmarshal func(data []byte, v interface{}) error) DeserializerOptions {
+	return func(o *deserializerOption) {
+		o.unmarshal = unmarshal
+	}
+}
+
+// deserializer writer schema resolution shared by the deserializers
+type deserializer struct {
+	r   Registry
+	typ SchemaType
+	opt deserializerOption
+}
+
+func newDeserializer(r Registry, typ SchemaType, opts []DeserializerOptions) *deserializer {
+	d := &deserializer{r: NewCachedRegistry(r), typ: typ}
+	for _, o := range opts {
+		o(&d.opt)
+	}
+	return d
+}
+
+// schema the writer schema of data and the payload
+func (d *deserializer) schema(data []byte) (Schema, []byte, error) {
+	id, payload, err := SchemaId(data)
+	if err != nil {
+		return Schema{}, nil, err
+	}
+	s, err := d.r.SchemaById(id)
+	if err != nil {
+		return Schema{}, nil, err
+	}
+	if s.SchemaType() != d.typ {
+		return Schema{}, nil, fmt.Errorf("%w: schema %d is %s, expected %s", ErrSchemaType, id, s.SchemaType(), d.typ)
+	}
+	return s, payload, nil
+}
+
+// JSONDeserializer decode the values encoded by JSONSerializer
+type JSONDeserializer struct {
+	*deserializer
+}
+
+// NewJSONDeserializer deserializer of the JSON values
+func NewJSONDeserializer(r Registry, opts ...DeserializerOptions) *JSONDeserializer {
+	return &JSONDeserializer{newDeserializer(r, JSON, opts)}
+}
+
+func (d *JSONDeserializer) Deserialize(_ string, data []byte, v interface{}) error {
+	_, payload, err := d.schema(data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(payload, v)
+}
+
+// AvroDeserializer decode the values encoded by AvroSerializer with their writer schema,
+// v is *interface{}, *map[string]interface{} or a struct decoded through its json encoding
+type AvroDeserializer struct {
+	*deserializer
+	// types parsed writer schema of each id
+	types sync.Map
+}
+
+// NewAvroDeserializer deserializer of the avro values
+func NewAvroDeserializer(r Registry, opts ...DeserializerOptions) *AvroDeserializer {
+	return &AvroDeserializer{deserializer: newDeserializer(r, Avro, opts)}
+}
+
+func (d *AvroDeserializer) Deserialize(_ string, data []byte, v interface{}) error {
+	s, payload, err := d.schema(data)
+	if err != nil {
+		return err
+	}
+	var t *avroType
+	if cached, ok := d.types.Load(s.Id); ok {
+		t = cached.(*avroType)
+	} else {
+		if t, err = parseAvro(s.Schema); err != nil {
+			return err
+		}
+		d.types.Store(s.Id, t)
+	}
+
+	n, err := t.decode(&avroReader{data: payload})
+	if err != nil {
+		return err
+	}
+	return assign(n, v)
+}
+
+// ProtobufDeserializer decode the messages encoded by ProtobufSerializer
+type ProtobufDeserializer struct {
+	*deserializer
+}
+
+// NewProtobufDeserializer deserializer of the protobuf messages
+func NewProtobufDeserializer(r Registry, opts ...DeserializerOptions) *ProtobufDeserializer {
+	d := newDeserializer(r, Protobuf, opts)
+	if d.opt.unmarshal == nil {
+		d.opt.unmarshal = unmarshalProto
+	}
+	return &ProtobufDeserializer{d}
+}
+
+func (d *ProtobufDeserializer) Deserialize(_ string, data []byte, v interface{}) error {
+	_, payload, err := d.schema(data)
+	if err != nil {
+		return err
+	}
+	if _, payload, err = readIndexes(payload); err != nil {
+		return err
+	}
+	return d.opt.unmarshal(payload, v)
+}
+
+func unmarshalProto(data []byte, v interface{}) error {
+	m, ok := v.(interface{ Unmarshal([]byte) error })
+	if !ok {
+		return fmt.Errorf("serde: %T has no Unmarshal method, use WithProtoUnmarshal", v)
+	}
+	return m.Unmarshal(data)
+}
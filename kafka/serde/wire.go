@@ -0,0 +1,70 @@
+package serde
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+// MagicByte first byte of the Confluent wire format, followed by the 4 bytes big endian schema id
+const MagicByte byte = 0
+
+var (
+	ErrUnknownMagicByte = errors.New("serde: unknown magic byte")
+	ErrShortData        = errors.New("serde: data shorter than the wire format header")
+)
+
+// appendHeader append the magic byte and the schema id
+func appendHeader(buf []byte, id int) []byte {
+	var b [5]byte
+	b[0] = MagicByte
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	return append(buf, b[:]...)
+}
+
+// SchemaId the schema id of the data in wire format and the payload after the header
+func SchemaId(data []byte) (int, []byte, error) {
+	if len(data) < 5 {
+		return 0, nil, ErrShortData
+	}
+	if data[0] != MagicByte {
+		return 0, nil, fmt.Errorf("%w: %d", ErrUnknownMagicByte, data[0])
+	}
+	return int(binary.BigEndian.Uint32(data[1:5])), data[5:], nil
+}
+
+// appendIndexes append the protobuf message indexes, [0] is written as a single 0
+func appendIndexes(buf []byte, indexes []int) []byte {
+	if len(indexes) == 0 || (len(indexes) == 1 && indexes[0] == 0) {
+		return append(buf, 0)
+	}
+	buf = appendVarint(buf, int64(len(indexes)))
+	for _, i := range indexes {
+		buf = appendVarint(buf, int64(i))
+	}
+	return buf
+}
+
+// readIndexes read the protobuf message indexes, the payload after them
+func readIndexes(data []byte) ([]int, []byte, error) {
+	r := &avroReader{data: data}
+	n, err := r.varint()
+	if err != nil {
+		return nil, nil, err
+	}
+	if n == 0 {
+		return []int{0}, r.data, nil
+	}
+	if n < 0 || n > int64(len(r.data)) {
+		return nil, nil, fmt.Errorf("serde: invalid message indexes count %d", n)
+	}
+	indexes := make([]int, n)
+	for i := range indexes {
+		v, err := r.varint()
+		if err != nil {
+			return nil, nil, err
+		}
+		indexes[i] = int(v)
+	}
+	return indexes, r.data, nil
+}
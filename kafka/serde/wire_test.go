@@ -0,0 +1,58 @@
+package serde
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestWireHeader(t *testing.T) {
+	for _, id := range []int{0, 1, 255, 256, 1<<31 - 1} {
+		data := append(appendHeader(nil, id), "payload"...)
+		got, payload, err := SchemaId(data)
+		if err != nil {
+			t.Fatalf("SchemaId(%d): %v", id, err)
+		}
+		if got != id || string(payload) != "payload" {
+			t.Errorf("SchemaId = %d %q, want %d %q", got, payload, id, "payload")
+		}
+	}
+
+	if _, _, err := SchemaId([]byte{0, 0, 0}); !errors.Is(err, ErrShortData) {
+		t.Errorf("short data: %v", err)
+	}
+	if _, _, err := SchemaId([]byte{1, 0, 0, 0, 1}); !errors.Is(err, ErrUnknownMagicByte) {
+		t.Errorf("magic byte 1: %v", err)
+	}
+}
+
+func TestWireIndexes(t *testing.T) {
+	tests := []struct {
+		indexes []int
+		want    []int
+		size    int
+	}{
+		{nil, []int{0}, 1},
+		{[]int{0}, []int{0}, 1},
+		{[]int{1}, []int{1}, 2},
+		{[]int{2, 0, 300}, []int{2, 0, 300}, 5},
+	}
+	for _, tt := range tests {
+		data := append(appendIndexes(nil, tt.indexes), "payload"...)
+		if n := len(data) - len("payload"); n != tt.size {
+			t.Errorf("appendIndexes(%v) wrote %d bytes, want %d", tt.indexes, n, tt.size)
+		}
+		got, payload, err := readIndexes(data)
+		if err != nil {
+			t.Fatalf("readIndexes(%v): %v", tt.indexes, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) || string(payload) != "payload" {
+			t.Errorf("readIndexes = %v %q, want %v %q", got, payload, tt.want, "payload")
+		}
+	}
+
+	// count larger than the data
+	if _, _, err := readIndexes(appendVarint(nil, 100)); err == nil {
+		t.Error("invalid count accepted")
+	}
+}
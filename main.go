@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"os/signal"
@@ -34,19 +35,19 @@ func WriteProducer() {
 		once.Add(1)
 		go func(x int) {
 			client := producer.NewProducer(producer.WithBootstrapServer([]string{"172.16.119.211:9092"}))
+			publisher := producer.NewPublisher(client, producer.WithBatchMessages(10000))
 			for i := 0; i < 3000000; i++ {
-				_ = producer.PushMessage(
-					producer.WithProducer(client),
+				_ = publisher.Publish(context.Background(),
 					producer.WithBuffer([]byte(fmt.Sprintf("Hello lady data: [%d:%d]", x, i))),
 					producer.WithTopic("producer_golang_demo_test"),
 					producer.WithPartition(int32(x)),
 				)
-				if i%10000 > 9000 {
-					client.Flush(5)
-				}
 			}
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			undelivered, _ := publisher.Close(ctx)
+			cancel()
+			fmt.Printf("producer %d: %d undelivered\n", x, len(undelivered))
 			once.Done()
-			client.Flush(50)
 			client.Close()
 		}(x)
 	}